@@ -0,0 +1,741 @@
+package main
+
+/*
+=head1 NAME
+
+anonymize-netspoc - Anonymize names and IP addresses of Netspoc files
+
+=head1 SYNOPSIS
+
+anonymize-netspoc [options] FILE|DIR OUT-FILE|OUT-DIR
+
+=head1 DESCRIPTION
+
+This program reads a Netspoc configuration and writes an anonymized
+copy to OUT-FILE or OUT-DIR, which must not exist.
+The result can be shared e.g. in bug reports.
+It should compile to the same ACLs as the original configuration,
+only with names and IP addresses substituted.
+
+Each name of a network, router, host, service, owner, NAT tag, ...
+is substituted consistently by a generated name.
+Generated names keep the sort order of original names.
+
+IP addresses are substituted by a prefix preserving mapping.
+Hence relations between subnets, NAT networks, aggregates and
+prefix lengths are retained.
+Host part of an IP address is left unchanged, if it isn't part of
+any network, aggregate or other prefix of the configuration.
+Some bits are left unchanged as well to keep both ends of a range
+in order.
+
+Comments and descriptions are removed.
+Email addresses, hardware names, LDAP IDs and banners are substituted.
+Names of files and directories are substituted as well.
+Directory "raw" is left out.
+
+=head1 OPTIONS
+
+=over 4
+
+=item B<-key> string
+
+Use given key for mapping of IP addresses.
+The same key always results in the same mapping.
+By default a random key is used.
+
+=item B<-ipv6>
+
+Expect IPv6 definitions everywhere except in subdirectory "ipv4/".
+
+=item B<-quiet>
+
+Don't print progress messages.
+
+=item B<-help>
+
+Prints a brief help message and exits.
+
+=back
+
+=head1 COPYRIGHT AND DISCLAIMER
+
+(c) 2020 by Heinz Knutzen <heinz.knutzengooglemail.com>
+
+This program uses modules of Netspoc, a Network Security Policy Compiler.
+http://hknutzen.github.com/Netspoc
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/abort"
+	"github.com/hknutzen/Netspoc/go/pkg/ast"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/diag"
+	"github.com/hknutzen/Netspoc/go/pkg/fileop"
+	"github.com/hknutzen/Netspoc/go/pkg/filetree"
+	"github.com/hknutzen/Netspoc/go/pkg/parser"
+	"github.com/hknutzen/Netspoc/go/pkg/printer"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of objects, that are referenced as "type:name".
+var globalType = map[string]bool{
+	"router":          true,
+	"network":         true,
+	"host":            true,
+	"any":             true,
+	"group":           true,
+	"area":            true,
+	"service":         true,
+	"owner":           true,
+	"protocol":        true,
+	"protocolgroup":   true,
+	"pathrestriction": true,
+	"nat":             true,
+	"isakmp":          true,
+	"ipsec":           true,
+	"wireguard":       true,
+	"crypto":          true,
+}
+
+// Prefix of generated names.
+var namePrefix = map[string]string{
+	"router":          "r",
+	"vrf":             "vrf",
+	"network":         "n",
+	"bridge":          "b",
+	"host":            "h",
+	"any":             "a",
+	"group":           "g",
+	"area":            "area",
+	"service":         "s",
+	"owner":           "o",
+	"protocol":        "p",
+	"protocolgroup":   "pg",
+	"pathrestriction": "pr",
+	"nat":             "nat",
+	"isakmp":          "isakmp",
+	"ipsec":           "ipsec",
+	"wireguard":       "wg",
+	"crypto":          "crypto",
+	"user":            "u",
+	"domain":          "d",
+	"hardware":        "hw",
+	"ldap":            "ldap",
+	"text":            "text",
+	"file":            "f",
+}
+
+// Names of hardware, that are left unchanged.
+var knownHardware = map[string]bool{
+	"bri":             true,
+	"device":          true,
+	"dialer":          true,
+	"ethernet":        true,
+	"fastethernet":    true,
+	"gigabitethernet": true,
+	"inside":          true,
+	"loopback":        true,
+	"management":      true,
+	"outside":         true,
+	"serial":          true,
+	"state":           true,
+	"tunnel":          true,
+	"vlan":            true,
+}
+
+// Input is processed twice.
+// In first pass, names and IP prefixes are collected.
+// In second pass, names and IP addresses are substituted.
+var collecting bool
+
+// Collected names and their substitution for each type.
+var subst = make(map[string]map[string]string)
+
+func mapName(typ, name string) string {
+	m := subst[typ]
+	if m == nil {
+		m = make(map[string]string)
+		subst[typ] = m
+	}
+	if collecting {
+		m[name] = name
+		return name
+	}
+	if replace, found := m[name]; found {
+		return replace
+	}
+	abort.Msg("Missing substitution for %s:%s", typ, name)
+	return ""
+}
+
+// Generate names in sort order of original names.
+// Numbers are padded with zeros, to keep sort order of generated names.
+func setupSubst() {
+	for typ, m := range subst {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		width := len(strconv.Itoa(len(names)))
+		prefix := namePrefix[typ]
+		if prefix == "" {
+			prefix = typ
+		}
+		for i, name := range names {
+			m[name] = fmt.Sprintf("%s%0*d", prefix, width, i+1)
+		}
+	}
+}
+
+// Name of router may have extension "@vrf".
+func mapRouter(name string) string {
+	parts := strings.SplitN(name, "@", 2)
+	result := mapName("router", parts[0])
+	if len(parts) == 2 {
+		result += "@" + mapName("vrf", parts[1])
+	}
+	return result
+}
+
+// Name of bridged network has extension "/part".
+func mapNetwork(name string) string {
+	parts := strings.SplitN(name, "/", 2)
+	result := mapName("network", parts[0])
+	if len(parts) == 2 {
+		result += "/" + mapName("bridge", parts[1])
+	}
+	return result
+}
+
+// Substitute local part and domain of email address separately.
+// Wildcard "[all]" is left unchanged.
+func mapEmail(email string) string {
+	parts := strings.SplitN(email, "@", 2)
+	if len(parts) != 2 {
+		return email
+	}
+	user := parts[0]
+	if user != "[all]" && user != "" {
+		user = mapName("user", user)
+	}
+	return user + "@" + mapName("domain", parts[1])
+}
+
+// Name of ID host is "id:user@domain" or "id:@domain".
+func mapHost(name string) string {
+	if id := strings.TrimPrefix(name, "id:"); id != name {
+		return "id:" + mapEmail(id)
+	}
+	return mapName("host", name)
+}
+
+// Reference to ID host is extended by name of network:
+// host:id:user@domain.network
+func mapHostRef(name string) string {
+	if strings.HasPrefix(name, "id:") {
+		i := strings.LastIndex(name, ".")
+		if i == -1 {
+			abort.Msg("Unexpected host:%s", name)
+		}
+		return mapHost(name[:i]) + "." + mapNetwork(name[i+1:])
+	}
+	return mapHost(name)
+}
+
+func mapTypedName(typ, name string) string {
+	switch typ {
+	case "router":
+		return mapRouter(name)
+	case "network":
+		return mapNetwork(name)
+	case "host":
+		return mapHostRef(name)
+	}
+	return mapName(typ, name)
+}
+
+// Substitute name in "type:name", if type is known.
+func mapTyped(v string) string {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) == 2 && globalType[parts[0]] {
+		typ, name := parts[0], parts[1]
+		return typ + ":" + mapTypedName(typ, name)
+	}
+	return v
+}
+
+// Hardware names with common prefix are left unchanged.
+func mapHardware(name string) string {
+	root := strings.TrimRight(name, "0123456789/:.")
+	if knownHardware[strings.ToLower(root)] {
+		return name
+	}
+	return mapName("hardware", name)
+}
+
+//###################################################################
+// Prefix preserving substitution of IP addresses.
+// Bit i of address is inverted depending on a keyed hash of the
+// preceeding i bits. Only those bits are inverted, whose preceeding
+// bits are a proper prefix of some network or aggregate found in input.
+// Hence all other bits, typically the host part, are left unchanged.
+// Bits following a prefix, that is only partially covered by some
+// range, are left unchanged as well. Otherwise the substituted range
+// would be inverted or would cover some other addresses.
+//###################################################################
+
+var ipKey []byte
+
+// Proper prefixes of collected networks.
+type bitPrefix struct {
+	ip   string
+	bits int
+}
+
+var prefixes = make(map[bitPrefix]bool)
+
+// Prefixes partially covered by some range.
+var rangePrefixes = make(map[bitPrefix]bool)
+
+func maskBits(ip net.IP, n int) net.IP {
+	return ip.Mask(net.CIDRMask(n, len(ip)*8))
+}
+
+func collectPrefix(ip net.IP, ones int) {
+	for i := 0; i < ones; i++ {
+		prefixes[bitPrefix{string(maskBits(ip, i)), i}] = true
+	}
+}
+
+// Get last address of prefix of length n.
+func lastIP(ip net.IP, n int) net.IP {
+	mask := net.CIDRMask(n, len(ip)*8)
+	result := make(net.IP, len(ip))
+	for i := range ip {
+		result[i] = ip[i] | ^mask[i]
+	}
+	return result
+}
+
+// Collect prefixes partially covered by range from lo to hi.
+// These are all prefixes on path from common prefix of lo and hi
+// down to lo and hi.
+func collectRange(lo, hi net.IP) {
+	bits := len(lo) * 8
+	n := 0
+	for n < bits && maskBits(lo, n+1).Equal(maskBits(hi, n+1)) {
+		n++
+	}
+	for i := n; i < bits && !maskBits(lo, i).Equal(lo); i++ {
+		rangePrefixes[bitPrefix{string(maskBits(lo, i)), i}] = true
+	}
+	for i := n; i < bits && !lastIP(hi, i).Equal(hi); i++ {
+		rangePrefixes[bitPrefix{string(maskBits(hi, i)), i}] = true
+	}
+}
+
+func flipBit(prefix net.IP, i int) bool {
+	mac := hmac.New(sha256.New, ipKey)
+	mac.Write([]byte{byte(i)})
+	mac.Write(prefix)
+	return mac.Sum(nil)[0]&1 == 1
+}
+
+func mapIPBits(ip net.IP) net.IP {
+	result := make(net.IP, len(ip))
+	copy(result, ip)
+	for i := 0; i < len(ip)*8; i++ {
+		prefix := maskBits(ip, i)
+		p := bitPrefix{string(prefix), i}
+		if prefixes[p] && !rangePrefixes[p] && flipBit(prefix, i) {
+			result[i/8] ^= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+func mapIPNet(n *net.IPNet) *net.IPNet {
+	ip := normalizeIP(n.IP)
+	ones, bits := n.Mask.Size()
+	if collecting {
+		collectPrefix(ip, ones)
+		return n
+	}
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{IP: mapIPBits(ip).Mask(mask), Mask: mask}
+}
+
+func mapIP(ip net.IP) net.IP {
+	if collecting {
+		return ip
+	}
+	return mapIPBits(normalizeIP(ip))
+}
+
+// Substitute IP addresses and IP/prefixlen found in value.
+func mapValueIP(v string) string {
+	words := strings.Split(v, " ")
+	for i, w := range words {
+		if ip, n, err := net.ParseCIDR(w); err == nil {
+			if ip.Equal(n.IP) {
+				words[i] = mapIPNet(n).String()
+			} else {
+				ones, _ := n.Mask.Size()
+				words[i] = mapIP(ip).String() + "/" + strconv.Itoa(ones)
+			}
+		} else if ip := net.ParseIP(w); ip != nil {
+			words[i] = mapIP(ip).String()
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Substitute both ends of range "IP1 - IP2".
+func mapRange(v string) string {
+	if collecting {
+		l := strings.Split(v, "-")
+		if len(l) == 2 {
+			lo := net.ParseIP(strings.TrimSpace(l[0]))
+			hi := net.ParseIP(strings.TrimSpace(l[1]))
+			if lo != nil && hi != nil {
+				lo, hi = normalizeIP(lo), normalizeIP(hi)
+				if len(lo) == len(hi) {
+					collectRange(lo, hi)
+				}
+			}
+		}
+	}
+	return mapValueIP(v)
+}
+
+//###################################################################
+// Traverse AST.
+//###################################################################
+
+func element(n ast.Element) {
+	switch x := n.(type) {
+	case *ast.NamedRef:
+		x.Name = mapTypedName(x.Type, x.Name)
+	case *ast.IntfRef:
+		x.Router = mapRouter(x.Router)
+		if x.Network != "[" {
+			x.Network = mapNetwork(x.Network)
+		}
+	case *ast.SimpleAuto:
+		elementList(x.Elements)
+	case *ast.AggAuto:
+		if x.Net != nil {
+			x.Net = mapIPNet(x.Net)
+		}
+		elementList(x.Elements)
+	case *ast.IntfAuto:
+		elementList(x.Elements)
+	case *ast.Intersection:
+		elementList(x.Elements)
+	case *ast.Complement:
+		element(x.Element)
+	}
+}
+
+func elementList(l []ast.Element) {
+	for _, n := range l {
+		element(n)
+	}
+}
+
+func namedUnion(n *ast.NamedUnion) {
+	if n != nil {
+		elementList(n.Elements)
+	}
+}
+
+func valueList(l []*ast.Value, f func(string) string) {
+	for _, v := range l {
+		v.Value = f(v.Value)
+	}
+}
+
+func value(v string) string {
+	v = mapTyped(v)
+	if strings.Contains(v, "@") && !strings.Contains(v, ":") {
+		return mapEmail(v)
+	}
+	return mapValueIP(v)
+}
+
+func attribute(n *ast.Attribute) {
+	name := n.Name
+	switch name {
+	case "owner", "sub_owner":
+		valueList(n.ValueList, func(v string) string {
+			return mapName("owner", v)
+		})
+	case "bind_nat":
+		valueList(n.ValueList, func(v string) string {
+			return mapName("nat", v)
+		})
+	case "hardware":
+		valueList(n.ValueList, mapHardware)
+	case "range":
+		valueList(n.ValueList, mapRange)
+	case "ldap_id", "ldap_append":
+		valueList(n.ValueList, func(v string) string {
+			return mapName("ldap", v)
+		})
+	case "banner":
+		valueList(n.ValueList, func(v string) string {
+			return mapName("text", v)
+		})
+	default:
+		valueList(n.ValueList, value)
+	}
+	if strings.HasPrefix(name, "nat:") {
+		n.Name = "nat:" + mapName("nat", name[len("nat:"):])
+	}
+	attributeList(n.ComplexValue)
+}
+
+func attributeList(l []*ast.Attribute) {
+	for _, n := range l {
+		attribute(n)
+	}
+}
+
+// Remove attribute "description" in hosts and interfaces.
+func withoutDescription(l []*ast.Attribute) []*ast.Attribute {
+	j := 0
+	for _, a := range l {
+		if a.Name != "description" {
+			l[j] = a
+			j++
+		}
+	}
+	return l[:j]
+}
+
+func toplevel(n ast.Toplevel) {
+	typ, name := getTypeAndName(n.GetName())
+	n.SetName(typ + ":" + mapTypedName(typ, name))
+	switch x := n.(type) {
+	case *ast.TopList:
+		x.Description = nil
+		elementList(x.Elements)
+	case *ast.Protocolgroup:
+		x.Description = nil
+		valueList(x.ValueList, mapTyped)
+	case *ast.Protocol:
+		x.Description = nil
+	case *ast.TopStruct:
+		x.Description = nil
+		attributeList(x.Attributes)
+	case *ast.Service:
+		x.Description = nil
+		attributeList(x.Attributes)
+		namedUnion(x.User)
+		for _, r := range x.Rules {
+			namedUnion(r.Src)
+			namedUnion(r.Dst)
+			valueList(r.Prt.ValueList, mapTyped)
+		}
+	case *ast.Network:
+		x.Description = nil
+		attributeList(x.Attributes)
+		for _, h := range x.Hosts {
+			_, name := getTypeAndName(h.Name)
+			h.Name = "host:" + mapHost(name)
+			h.ComplexValue = withoutDescription(h.ComplexValue)
+			attributeList(h.ComplexValue)
+		}
+	case *ast.Router:
+		x.Description = nil
+		attributeList(x.Attributes)
+		for _, intf := range x.Interfaces {
+			// Name of loopback interface is handled like network name.
+			_, name := getTypeAndName(intf.Name)
+			intf.Name = "interface:" + mapNetwork(name)
+			intf.ComplexValue = withoutDescription(intf.ComplexValue)
+			attributeList(intf.ComplexValue)
+		}
+	case *ast.Area:
+		x.Description = nil
+		attributeList(x.Attributes)
+		namedUnion(x.Border)
+		namedUnion(x.InclusiveBorder)
+	}
+}
+
+func getTypeAndName(objName string) (string, string) {
+	pair := strings.SplitN(objName, ":", 2)
+	if len(pair) != 2 {
+		abort.Msg("Missing type in '%s'", objName)
+	}
+	return pair[0], pair[1]
+}
+
+//###################################################################
+// Read and write files.
+//###################################################################
+
+type file struct {
+	path  string
+	nodes []ast.Toplevel
+}
+
+func readFiles(path string) []*file {
+	var result []*file
+	filetree.Walk(path, func(input *filetree.Context) {
+		source := []byte(input.Data)
+		nodes := parser.ParseFile(source, input.Path)
+		result = append(result, &file{path: input.Path, nodes: nodes})
+	})
+	return result
+}
+
+// Substitute each component of path relative to input directory.
+// Subdirectories "ipv4" and "ipv6" are left unchanged.
+func mapPath(inDir, path string) string {
+	rel, err := filepath.Rel(inDir, path)
+	if err != nil {
+		abort.Msg("%v", err)
+	}
+	if rel == "." {
+		return rel
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		if part != "ipv4" && part != "ipv6" {
+			parts[i] = mapName("file", part)
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+func process(inPath string, files []*file) {
+	for _, f := range files {
+		mapPath(inPath, f.path)
+		for _, n := range f.nodes {
+			toplevel(n)
+		}
+	}
+}
+
+func writeFile(path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		abort.Msg("Can't %v", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		abort.Msg("Can't %v", err)
+	}
+}
+
+// Copy file "config" with options, but without comments.
+func copyConfig(inPath, outPath string) {
+	data, err := ioutil.ReadFile(filepath.Join(inPath, "config"))
+	if err != nil {
+		return
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = strings.TrimRight(line[:i], " \t")
+			if line == "" {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	writeFile(filepath.Join(outPath, "config"),
+		[]byte(strings.Join(lines, "\n")))
+}
+
+func main() {
+
+	// Setup custom usage function.
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s [options] FILE|DIR OUT-FILE|OUT-DIR\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// Command line flags
+	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
+	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	key := pflag.String("key", "", "Use key for mapping of IP addresses")
+	pflag.Parse()
+
+	// Argument processing
+	args := pflag.Args()
+	if len(args) != 2 {
+		pflag.Usage()
+		os.Exit(1)
+	}
+	inPath := args[0]
+	outPath := args[1]
+	if _, err := os.Stat(outPath); err == nil {
+		abort.Msg("%s already exists", outPath)
+	}
+	if *key != "" {
+		ipKey = []byte(*key)
+	} else {
+		ipKey = make([]byte, 32)
+		if _, err := rand.Read(ipKey); err != nil {
+			abort.Msg("%v", err)
+		}
+	}
+
+	dummyArgs := []string{
+		fmt.Sprintf("--verbose=%v", !*quiet),
+		fmt.Sprintf("--ipv6=%v", *ipv6),
+	}
+	conf.ConfigFromArgsAndFile(dummyArgs, inPath)
+
+	files := readFiles(inPath)
+	collecting = true
+	process(inPath, files)
+	setupSubst()
+	collecting = false
+	process(inPath, files)
+
+	for _, f := range files {
+		path := filepath.Join(outPath, mapPath(inPath, f.path))
+		writeFile(path, printer.File(f.nodes, nil))
+		diag.Info("Wrote %s", path)
+	}
+	if fileop.IsDir(inPath) {
+		copyConfig(inPath, outPath)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+/*
+=head1 NAME
+
+generate-netspoc - Generate synthetic Netspoc policy for benchmarks
+
+=head1 SYNOPSIS
+
+generate-netspoc [options] OUT-DIR
+
+=head1 DESCRIPTION
+
+This program writes a generated Netspoc configuration to OUT-DIR,
+which must not exist. Size and shape of the configuration are
+controlled by options. The result compiles without errors and can be
+used to measure performance of pass 1 and pass 2 on large
+policies.
+
+Each zone has one network with some hosts. Zones are connected
+by managed routers of models ASA, IOS, NX-OS and Linux to a common
+transit network. Additional routers connect zones of different
+routers and build loops. NAT tags are bound at transit interfaces.
+Crypto hubs are connected to transit network, each with some
+unmanaged spokes. Services use randomly selected objects and
+protocols and nested groups.
+
+=head1 OPTIONS
+
+=over 4
+
+=item B<-zones> N
+
+Number of zones. Default: 20
+
+=item B<-zones-per-router> N
+
+Number of zones connected by each managed router. Default: 4
+
+=item B<-hosts> N
+
+Number of hosts in each network. Default: 5
+
+=item B<-loops> N
+
+Number of additional routers building loops. Default: 0
+
+=item B<-nat> N
+
+Number of NAT tags. Default: 0
+
+=item B<-crypto> N
+
+Number of crypto hubs. Default: 0
+
+=item B<-spokes> N
+
+Number of spokes at each crypto hub. Default: 2
+
+=item B<-services> N
+
+Number of services. Default: 50
+
+=item B<-group-depth> N
+
+Nesting depth of groups used in services. Default: 2
+
+=item B<-seed> N
+
+Seed of random numbers. Same seed generates same configuration.
+Default: 1
+
+=item B<-help>
+
+Prints a brief help message and exits.
+
+=back
+
+=head1 COPYRIGHT AND DISCLAIMER
+
+(c) 2020 by Heinz Knutzen <heinz.knutzengooglemail.com>
+
+This program uses modules of Netspoc, a Network Security Policy Compiler.
+http://hknutzen.github.com/Netspoc
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/abort"
+	"github.com/hknutzen/Netspoc/go/pkg/generate"
+	"github.com/spf13/pflag"
+	"os"
+)
+
+func main() {
+
+	// Setup custom usage function.
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] OUT-DIR\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// Command line flags
+	p := generate.DefaultParams()
+	pflag.IntVar(&p.Zones, "zones", p.Zones, "Number of zones")
+	pflag.IntVar(&p.ZonesPerRouter, "zones-per-router", p.ZonesPerRouter,
+		"Number of zones at each managed router")
+	pflag.IntVar(&p.Hosts, "hosts", p.Hosts, "Number of hosts in each network")
+	pflag.IntVar(&p.Loops, "loops", p.Loops, "Number of routers building loops")
+	pflag.IntVar(&p.NATTags, "nat", p.NATTags, "Number of NAT tags")
+	pflag.IntVar(&p.CryptoHubs, "crypto", p.CryptoHubs,
+		"Number of crypto hubs")
+	pflag.IntVar(&p.Spokes, "spokes", p.Spokes, "Number of spokes at each crypto hub")
+	pflag.IntVar(&p.Services, "services", p.Services, "Number of services")
+	pflag.IntVar(&p.GroupDepth, "group-depth", p.GroupDepth,
+		"Nesting depth of groups")
+	pflag.Int64Var(&p.Seed, "seed", p.Seed, "Seed of random numbers")
+	pflag.Parse()
+
+	// Argument processing
+	args := pflag.Args()
+	if len(args) != 1 {
+		pflag.Usage()
+		os.Exit(1)
+	}
+	if err := generate.Write(args[0], p); err != nil {
+		abort.Msg("%v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+/*
+=head1 NAME
+
+modify-netspoc - Apply a change set in JSON format to netspoc files
+
+=head1 SYNOPSIS
+
+modify-netspoc [options] FILE|DIR [CHANGES]
+
+=head1 DESCRIPTION
+
+This program reads a netspoc configuration and a change set
+from file CHANGES or from STDIN.
+The change set is a single job or a list of jobs in JSON format.
+Each job has attributes "method" and "params":
+
+ [ { "method": "create_host",
+     "params": { "network": "n1", "name": "h1", "ip": "10.1.1.10" } },
+   { "method": "add_to_rule",
+     "params": { "service": "s1", "rule_num": 1, "src": "host:h1" } } ]
+
+All jobs are applied in memory. Then the resulting configuration is
+checked like in pass 1 of Netspoc.
+Changed files are written back, only if all jobs could be applied
+and no error was found.
+Changes are done in place, no backup files are created.
+
+=head1 METHODS
+
+=over 4
+
+=item B<create_service>
+
+Params: "name", "description", "user", "rules", "file".
+"rules" is a list of objects with attributes
+"action" (permit|deny), "src", "dst", "prt" and optional "log".
+"file" is the name of the file relative to DIR,
+where the new service is added.
+It may be left out, if a single FILE is processed.
+
+=item B<delete_service>
+
+Params: "name".
+
+=item B<add_to_user>, B<remove_from_user>
+
+Params: "service", "user".
+
+=item B<add_to_rule>, B<remove_from_rule>
+
+Params: "service", "rule_num", "src", "dst", "prt".
+"rule_num" counts rules of service, starting with 1.
+
+=item B<create_host>
+
+Params: "network", "name", "ip" or "range", optional "owner".
+
+=item B<change_owner>
+
+Params: "name", "owner".
+Changes owner of network, host, interface, router, any or area.
+Owner is removed, if "owner" is empty.
+
+=item B<set_attribute>
+
+Params: "name", "attribute", "value".
+Value is a comma separated list of values.
+An empty value adds an attribute without value.
+The attribute is removed, if "value" is null.
+
+=back
+
+User, src and dst are given as comma separated list of elements
+in Netspoc syntax.
+Prt is a comma separated list of protocols.
+Prefix "service:", "network:", "host:", "owner:" is optional in names.
+
+=head1 OPTIONS
+
+=over 4
+
+=item B<-ipv6>
+
+Expect IPv6 definitions everywhere except in subdirectory "ipv4/".
+
+=item B<-q>
+
+Quiet, don't print status messages.
+
+=item B<-help>
+
+Prints a brief help message and exits.
+
+=back
+
+=head1 COPYRIGHT AND DISCLAIMER
+
+(c) 2020 by Heinz Knutzen <heinz.knutzengooglemail.com>
+
+http://hknutzen.github.com/Netspoc
+
+This program is free software; you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along
+with this program; if not, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/abort"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/modify"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+
+	// Setup custom usage function.
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s [options] FILE|DIR [CHANGES]\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// Command line flags
+	quiet := pflag.BoolP("quiet", "q", false, "Don't show changed files")
+	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	pflag.Parse()
+
+	// Argument processing
+	args := pflag.Args()
+	if len(args) == 0 || len(args) > 2 {
+		pflag.Usage()
+		os.Exit(1)
+	}
+	path := args[0]
+
+	// Read change set.
+	var data []byte
+	var err error
+	if len(args) == 2 {
+		data, err = ioutil.ReadFile(args[1])
+	} else {
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		abort.Msg("Can't %s", err)
+	}
+	jobs, err := modify.ParseJobs(data)
+	if err != nil {
+		abort.Msg("Invalid JSON in change set: %s", err)
+	}
+
+	// Initialize config, especially "ignoreFiles'.
+	dummyArgs := []string{
+		fmt.Sprintf("--verbose=%v", !*quiet),
+		fmt.Sprintf("--ipv6=%v", *ipv6),
+	}
+	conf.ConfigFromArgsAndFile(dummyArgs, path)
+
+	if err := modify.Apply(path, jobs); err != nil {
+		abort.Msg("%v", err)
+	}
+}
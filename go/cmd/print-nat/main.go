@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/hknutzen/Netspoc/go/pkg/pass1"
+	"os"
+)
+
+func main() {
+	os.Exit(pass1.PrintNatMain())
+}
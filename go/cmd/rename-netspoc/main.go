@@ -31,6 +31,7 @@ var globalType = map[string]bool{
 	"nat":             true,
 	"isakmp":          true,
 	"ipsec":           true,
+	"wireguard":       true,
 	"crypto":          true,
 }
 
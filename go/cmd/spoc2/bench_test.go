@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/hknutzen/Netspoc/go/pkg/generate"
+	"github.com/hknutzen/Netspoc/go/pkg/pass1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	benchOnce  sync.Once
+	benchDir   string
+	benchFiles []string
+	benchErr   string
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchDir != "" {
+		os.RemoveAll(benchDir)
+	}
+	os.Exit(code)
+}
+
+// Generate policy and run pass 1 once for all benchmarks.
+// Returns names of files with rules of devices.
+func getRuleFiles(b *testing.B) []string {
+	benchOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "bench")
+		if err != nil {
+			benchErr = err.Error()
+			return
+		}
+		benchDir = dir
+		inDir := filepath.Join(dir, "in")
+		outDir := filepath.Join(dir, "out")
+		p := generate.DefaultParams()
+		p.Zones = 200
+		p.NATTags = 2
+		p.Services = 3000
+		p.GroupDepth = 3
+		if err := generate.Write(inDir, p); err != nil {
+			benchErr = err.Error()
+			return
+		}
+		os.Args = []string{"spoc1", "-q", inDir, outDir}
+		if pass1.SpocMain() != 0 {
+			benchErr = "pass 1 failed"
+			return
+		}
+		data, err := ioutil.ReadFile(outDir + "/.devlist")
+		if err != nil {
+			benchErr = err.Error()
+			return
+		}
+		for _, path := range strings.Fields(string(data)) {
+			benchFiles = append(benchFiles, outDir+"/"+path+".rules")
+		}
+	})
+	if benchErr != "" {
+		b.Fatal(benchErr)
+	}
+	return benchFiles
+}
+
+// Read and convert all ACLs of device.
+func readACLs(path string) *routerData {
+	r := openACLs(path)
+	defer r.close()
+	routerData := r.routerData
+	for aclInfo := r.next(); aclInfo != nil; aclInfo = r.next() {
+		routerData.acls = append(routerData.acls, aclInfo)
+	}
+	return routerData
+}
+
+// Read ACLs of all devices, either of model Linux or other models.
+func readAllACLs(b *testing.B, linux bool) []*routerData {
+	var result []*routerData
+	for _, file := range getRuleFiles(b) {
+		if r := readACLs(file); (r.model == "Linux") == linux {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func BenchmarkOptimizeRules(b *testing.B) {
+	getRuleFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		l := readAllACLs(b, false)
+		b.StartTimer()
+		for _, r := range l {
+			for _, acl := range r.acls {
+				acl.intfRules = optimizeRules(acl.intfRules, acl)
+				acl.rules = optimizeRules(acl.rules, acl)
+			}
+		}
+	}
+}
+
+func BenchmarkFindObjectgroups(b *testing.B) {
+	getRuleFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		l := readAllACLs(b, false)
+		for _, r := range l {
+			for _, acl := range r.acls {
+				optimizeACL(acl)
+			}
+		}
+		b.StartTimer()
+		for _, r := range l {
+			for _, acl := range r.acls {
+				if !acl.isCryptoACL {
+					findObjectgroups(acl, r)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkFindChains(b *testing.B) {
+	getRuleFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		l := readAllACLs(b, true)
+		b.StartTimer()
+		for _, r := range l {
+			for _, acl := range r.acls {
+				findChains(acl, r)
+			}
+		}
+	}
+}
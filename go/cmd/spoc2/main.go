@@ -32,14 +32,16 @@ import (
 	"github.com/hknutzen/Netspoc/go/pkg/diag"
 	"github.com/hknutzen/Netspoc/go/pkg/fileop"
 	"github.com/hknutzen/Netspoc/go/pkg/jcode"
+	"github.com/hknutzen/Netspoc/go/pkg/stats"
 	"github.com/json-iterator/go"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type ipNet struct {
@@ -655,8 +657,10 @@ func joinRanges(rules ciscoRules, prt2obj name2Proto) ciscoRules {
 
 type aclInfo struct {
 	name                                             string
-	isStdACL                                         bool
+	isStdACL, isCryptoACL                            bool
 	intfRules, rules                                 ciscoRules
+	intfHasLog, hasLog                               bool
+	addPermit, addDeny                               bool
 	lrules                                           linuxRules
 	prt2obj                                          name2Proto
 	ipNet2obj                                        name2ipNet
@@ -2136,7 +2140,7 @@ func actionCode(rule *linuxRule) string {
 // Print chains of iptables.
 // Objects have already been normalized to ip/mask pairs.
 // NAT has already been applied.
-func printChains(fd *os.File, routerData *routerData) {
+func printChains(fd io.Writer, routerData *routerData) {
 	chains := routerData.chains
 	routerData.chains = nil
 	if len(chains) == 0 {
@@ -2204,7 +2208,7 @@ func printChains(fd *os.File, routerData *routerData) {
 	fmt.Fprintln(fd)
 }
 
-func iptablesACLLine(fd *os.File, rule *linuxRule, prefix string, ipv6 bool) {
+func iptablesACLLine(fd io.Writer, rule *linuxRule, prefix string, ipv6 bool) {
 	src, dst, srcRange, prt := rule.src, rule.dst, rule.srcRange, rule.prt
 	result := prefix + " " + jumpCode(rule) + " " + actionCode(rule)
 	if size, _ := src.Mask.Size(); size != 0 {
@@ -2219,7 +2223,7 @@ func iptablesACLLine(fd *os.File, rule *linuxRule, prefix string, ipv6 bool) {
 	fmt.Fprintln(fd, result)
 }
 
-func printIptablesACL(fd *os.File, aclInfo *aclInfo, routerData *routerData) {
+func printIptablesACL(fd io.Writer, aclInfo *aclInfo, routerData *routerData) {
 	name := aclInfo.name
 	fmt.Fprintf(fd, ":%s -\n", name)
 	intfPrefix := "-A " + name
@@ -2261,9 +2265,11 @@ func convertRuleObjects(rules []*jcode.Rule, ipNet2obj name2ipNet, prt2obj name2
 }
 
 type routerData struct {
-	model           string
-	ipv6            bool
+	model string
+	ipv6  bool
+	// Prepared ACLs are only kept for model Linux.
 	acls            []*aclInfo
+	aclLines        int
 	logDeny         string
 	filterOnlyGroup *ipNet
 	doObjectgroup   bool
@@ -2294,113 +2300,220 @@ func prtList(names []string, prt2obj name2Proto) []*proto {
 	return result
 }
 
-func prepareACLs(path string) *routerData {
-	var jdata jcode.RouterData
+// Reads ACLs of device lazily from file with rules in JSON format.
+// Only a single ACL is held in memory at a time, as long as ACLs are
+// requested in order of file.
+type aclReader struct {
+	path       string
+	fd         *os.File
+	iter       *jsoniter.Iterator
+	filterOnly []string
+	routerData *routerData
+	// ACLs, that have been read and prepared, but not yet requested.
+	pending map[string]*aclInfo
+	// All ACLs have been read.
+	done bool
+}
+
+// Open file with rules of device and read all fields preceding ACLs.
+func openACLs(path string) *aclReader {
+	fd, err := os.Open(path)
+	if err != nil {
+		abort.Msg("Can't open %s for reading: %v", path, err)
+	}
 	routerData := new(routerData)
-	data, e := ioutil.ReadFile(path)
-	if e != nil {
-		panic(e)
+	if strings.Contains(path, "/ipv6/") {
+		routerData.ipv6 = true
 	}
-	e = jsoniter.Unmarshal(data, &jdata)
-	if e != nil {
-		panic(e)
+	r := &aclReader{
+		path:       path,
+		fd:         fd,
+		iter:       jsoniter.Parse(jsoniter.ConfigDefault, fd, 64*1024),
+		routerData: routerData,
+		pending:    make(map[string]*aclInfo),
 	}
-	var ipv6 bool
-	if index := strings.Index(path, "/ipv6/"); index != -1 {
-		routerData.ipv6 = true
-		ipv6 = true
-	}
-	model := jdata.Model
-	routerData.model = model
-	routerData.logDeny = jdata.LogDeny
-	doObjectgroup := jdata.DoObjectgroup == 1
-	routerData.doObjectgroup = doObjectgroup
-	rawACLs := jdata.ACLs
-	acls := make([]*aclInfo, len(rawACLs))
-	for i, rawInfo := range rawACLs {
-
-		// Process networks and protocols of each interface individually,
-		// because relation between networks may be changed by NAT.
-		ipNet2obj := make(name2ipNet)
-		prt2obj := make(name2Proto)
-
-		intfRules, hasLog1 := convertRuleObjects(
-			rawInfo.IntfRules, ipNet2obj, prt2obj)
-		rules, hasLog2 := convertRuleObjects(
-			rawInfo.Rules, ipNet2obj, prt2obj)
-
-		filterOnly := ipNetList(jdata.FilterOnly, ipNet2obj)
-
-		optNetworks := ipNetList(rawInfo.OptNetworks, ipNet2obj)
-		for _, obj := range optNetworks {
-			obj.optNetworks = obj
-		}
-		noOptAddrs := ipNetList(rawInfo.NoOptAddrs, ipNet2obj)
-		for _, obj := range noOptAddrs {
-			obj.noOptAddrs = true
-		}
-		needProtect := ipNetList(rawInfo.NeedProtect, ipNet2obj)
-		for _, obj := range needProtect {
-			obj.needProtect = true
-		}
-		setupIPNetRelation(ipNet2obj, ipv6)
-
-		aclInfo := &aclInfo{
-			name:         rawInfo.Name,
-			isStdACL:     rawInfo.IsStdACL == 1,
-			intfRules:    intfRules,
-			rules:        rules,
-			prt2obj:      prt2obj,
-			ipNet2obj:    ipNet2obj,
-			filterOnly:   filterOnly,
-			optNetworks:  optNetworks,
-			noOptAddrs:   noOptAddrs,
-			filterAnySrc: rawInfo.FilterAnySrc == 1,
-			needProtect:  needProtect,
-			network00:    ipNet2obj[getNet00Addr(ipv6)],
-		}
-		acls[i] = aclInfo
-
-		if len(needProtect) > 0 {
-			markSupernetsOfNeedProtect(needProtect)
-		}
-		if model == "Linux" {
-			addTCPUDPIcmp(prt2obj)
-		}
-
-		setupPrtRelation(prt2obj)
-		aclInfo.prtIP = prt2obj["ip"]
-
-		if model == "Linux" {
-			findChains(aclInfo, routerData)
-		} else {
-			intfRules = optimizeRules(intfRules, aclInfo)
-			intfRules = joinRanges(intfRules, prt2obj)
-			rules = optimizeRules(rules, aclInfo)
+	iter := r.iter
+	for {
+		switch field := iter.ReadObject(); field {
+		case "model":
+			routerData.model = iter.ReadString()
+		case "filter_only":
+			iter.ReadVal(&r.filterOnly)
+		case "do_objectgroup":
+			routerData.doObjectgroup = iter.ReadInt() == 1
+		case "log_deny":
+			routerData.logDeny = iter.ReadString()
+		case "acls":
+			r.check()
+			return r
+		case "":
+			r.check()
+			r.done = true
+			return r
+		default:
+			iter.Skip()
+		}
+		r.check()
+	}
+}
+
+func (r *aclReader) check() {
+	if err := r.iter.Error; err != nil && err != io.EOF {
+		abort.Msg("Can't read %s: %v", r.path, err)
+	}
+}
 
-			// Join adjacent port ranges. This must be called after
-			// local optimization, because protocols will be
-			// overlapping again after joining.
-			rules = joinRanges(rules, prt2obj)
-			aclInfo.intfRules = moveRulesEspAh(intfRules, prt2obj, hasLog1)
-			aclInfo.rules = moveRulesEspAh(rules, prt2obj, hasLog2)
+func (r *aclReader) close() {
+	r.fd.Close()
+}
 
-			hasFinalPermit := checkFinalPermit(aclInfo)
-			addPermit := rawInfo.AddPermit == 1
-			addDeny := rawInfo.AddDeny == 1
-			addProtectRules(aclInfo, hasFinalPermit || addPermit)
-			if rawInfo.IsCryptoACL != 1 {
-				findObjectgroups(aclInfo, routerData)
-			}
-			if len(filterOnly) > 0 && !addPermit {
-				addLocalDenyRules(aclInfo, routerData)
-			} else if !hasFinalPermit {
-				addFinalPermitDenyRule(aclInfo, addDeny, addPermit)
-			}
+// Read and convert next ACL. Returns nil after last ACL.
+func (r *aclReader) next() *aclInfo {
+	if r.done {
+		return nil
+	}
+	if !r.iter.ReadArray() {
+		r.check()
+		r.done = true
+		return nil
+	}
+	rawInfo := new(jcode.ACLInfo)
+	r.iter.ReadVal(rawInfo)
+	r.check()
+	return convertACL(rawInfo, r.filterOnly, r.routerData)
+}
+
+// Get prepared ACL with given name. Returns nil, if ACL isn't found.
+// ACLs are prepared in order of file, even if requested in other
+// order, hence numbering of object-groups and chains is stable.
+func (r *aclReader) get(name string) *aclInfo {
+	routerData := r.routerData
+	if routerData.model == "Linux" && !r.done {
+
+		// All sub-chains are printed before first toplevel chain,
+		// hence all ACLs must be prepared in advance.
+		for aclInfo := r.next(); aclInfo != nil; aclInfo = r.next() {
+			routerData.acls = append(routerData.acls, aclInfo)
+			prepareACL(aclInfo, routerData)
+			r.pending[aclInfo.name] = aclInfo
+		}
+	}
+	if aclInfo, found := r.pending[name]; found {
+		delete(r.pending, name)
+		return aclInfo
+	}
+	for aclInfo := r.next(); aclInfo != nil; aclInfo = r.next() {
+		prepareACL(aclInfo, routerData)
+		if aclInfo.name == name {
+			return aclInfo
 		}
+		r.pending[aclInfo.name] = aclInfo
 	}
-	routerData.acls = acls
-	return routerData
+	return nil
+}
+
+// Convert rules of ACL to internal objects.
+func convertACL(
+	rawInfo *jcode.ACLInfo, rawFilterOnly []string, routerData *routerData,
+) *aclInfo {
+
+	// Process networks and protocols of each interface individually,
+	// because relation between networks may be changed by NAT.
+	ipNet2obj := make(name2ipNet)
+	prt2obj := make(name2Proto)
+
+	intfRules, hasLog1 := convertRuleObjects(
+		rawInfo.IntfRules, ipNet2obj, prt2obj)
+	rules, hasLog2 := convertRuleObjects(
+		rawInfo.Rules, ipNet2obj, prt2obj)
+
+	filterOnly := ipNetList(rawFilterOnly, ipNet2obj)
+
+	optNetworks := ipNetList(rawInfo.OptNetworks, ipNet2obj)
+	for _, obj := range optNetworks {
+		obj.optNetworks = obj
+	}
+	noOptAddrs := ipNetList(rawInfo.NoOptAddrs, ipNet2obj)
+	for _, obj := range noOptAddrs {
+		obj.noOptAddrs = true
+	}
+	needProtect := ipNetList(rawInfo.NeedProtect, ipNet2obj)
+	for _, obj := range needProtect {
+		obj.needProtect = true
+	}
+	ipv6 := routerData.ipv6
+	setupIPNetRelation(ipNet2obj, ipv6)
+
+	aclInfo := &aclInfo{
+		name:         rawInfo.Name,
+		isStdACL:     rawInfo.IsStdACL == 1,
+		isCryptoACL:  rawInfo.IsCryptoACL == 1,
+		intfRules:    intfRules,
+		rules:        rules,
+		intfHasLog:   hasLog1,
+		hasLog:       hasLog2,
+		addPermit:    rawInfo.AddPermit == 1,
+		addDeny:      rawInfo.AddDeny == 1,
+		prt2obj:      prt2obj,
+		ipNet2obj:    ipNet2obj,
+		filterOnly:   filterOnly,
+		optNetworks:  optNetworks,
+		noOptAddrs:   noOptAddrs,
+		filterAnySrc: rawInfo.FilterAnySrc == 1,
+		needProtect:  needProtect,
+		network00:    ipNet2obj[getNet00Addr(ipv6)],
+	}
+
+	if len(needProtect) > 0 {
+		markSupernetsOfNeedProtect(needProtect)
+	}
+	if routerData.model == "Linux" {
+		addTCPUDPIcmp(prt2obj)
+	}
+
+	setupPrtRelation(prt2obj)
+	aclInfo.prtIP = prt2obj["ip"]
+	return aclInfo
+}
+
+// Remove redundant rules and join adjacent port ranges of Cisco ACL.
+func optimizeACL(aclInfo *aclInfo) {
+	prt2obj := aclInfo.prt2obj
+	intfRules := optimizeRules(aclInfo.intfRules, aclInfo)
+	intfRules = joinRanges(intfRules, prt2obj)
+	rules := optimizeRules(aclInfo.rules, aclInfo)
+
+	// Join adjacent port ranges. This must be called after
+	// local optimization, because protocols will be
+	// overlapping again after joining.
+	rules = joinRanges(rules, prt2obj)
+	aclInfo.intfRules = moveRulesEspAh(intfRules, prt2obj, aclInfo.intfHasLog)
+	aclInfo.rules = moveRulesEspAh(rules, prt2obj, aclInfo.hasLog)
+}
+
+// Add protect rules, object-groups and final rule to optimized Cisco ACL.
+func finishACL(aclInfo *aclInfo, routerData *routerData) {
+	hasFinalPermit := checkFinalPermit(aclInfo)
+	addPermit := aclInfo.addPermit
+	addProtectRules(aclInfo, hasFinalPermit || addPermit)
+	if !aclInfo.isCryptoACL {
+		findObjectgroups(aclInfo, routerData)
+	}
+	if len(aclInfo.filterOnly) > 0 && !addPermit {
+		addLocalDenyRules(aclInfo, routerData)
+	} else if !hasFinalPermit {
+		addFinalPermitDenyRule(aclInfo, aclInfo.addDeny, addPermit)
+	}
+}
+
+func prepareACL(aclInfo *aclInfo, routerData *routerData) {
+	if routerData.model == "Linux" {
+		findChains(aclInfo, routerData)
+	} else {
+		optimizeACL(aclInfo)
+		finishACL(aclInfo, routerData)
+	}
+	routerData.aclLines += countACLLines(aclInfo)
 }
 
 // Given IP or group object, return its address in Cisco syntax.
@@ -2453,7 +2566,7 @@ func ciscoACLAddr(obj *ipNet, model string) string {
 	return ipCode + " " + maskCode
 }
 
-func printObjectGroups(fd *os.File, aclInfo *aclInfo, model string) {
+func printObjectGroups(fd io.Writer, aclInfo *aclInfo, model string) {
 	var keyword string
 	if model == "NX-OS" {
 		keyword = "object-group ip address"
@@ -2553,7 +2666,7 @@ func getCiscoAction(deny bool) string {
 	return "permit"
 }
 
-func printAsaStdACL(fd *os.File, aclInfo *aclInfo, model string) {
+func printAsaStdACL(fd io.Writer, aclInfo *aclInfo, model string) {
 	for _, rule := range aclInfo.rules {
 		fmt.Fprintln(
 			fd,
@@ -2565,7 +2678,7 @@ func printAsaStdACL(fd *os.File, aclInfo *aclInfo, model string) {
 	}
 }
 
-func printCiscoACL(fd *os.File, aclInfo *aclInfo, routerData *routerData) {
+func printCiscoACL(fd io.Writer, aclInfo *aclInfo, routerData *routerData) {
 	model := routerData.model
 
 	if aclInfo.isStdACL {
@@ -2624,7 +2737,7 @@ func printCiscoACL(fd *os.File, aclInfo *aclInfo, routerData *routerData) {
 	}
 }
 
-func printACL(fd *os.File, aclInfo *aclInfo, routerData *routerData) {
+func printACL(fd io.Writer, aclInfo *aclInfo, routerData *routerData) {
 	model := routerData.model
 	if model == "Linux" {
 
@@ -2639,84 +2752,192 @@ func printACL(fd *os.File, aclInfo *aclInfo, routerData *routerData) {
 
 const aclMarker = "#insert "
 
-func printCombined(config []string, routerData *routerData, outPath string) {
+// Print config and insert printed ACLs at aclMarker.
+// Config is read line by line and ACLs are read on demand.
+func printCombined(configPath string, r *aclReader, outPath string) {
+	in, err := os.Open(configPath)
+	if err != nil {
+		abort.Msg("Can't open %s for reading: %v", configPath, err)
+	}
+	defer in.Close()
 	fd, err := os.Create(outPath)
 	if err != nil {
 		abort.Msg("Can't open %s for writing: %v", outPath, err)
 	}
-	aclLookup := make(map[string]*aclInfo)
-	for _, acl := range routerData.acls {
-		aclLookup[acl.name] = acl
-	}
-
-	// Print config and insert printed ACLs at aclMarker.
-	for _, line := range config {
+	w := bufio.NewWriter(fd)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
 		if strings.HasPrefix(line, aclMarker) {
 			// Print ACL.
 			name := line[len(aclMarker):]
-			aclInfo, ok := aclLookup[name]
-			if !ok {
+			aclInfo := r.get(name)
+			if aclInfo == nil {
 				abort.Msg("Unexpected ACL %s", name)
 			}
-			printACL(fd, aclInfo, routerData)
+			printACL(w, aclInfo, r.routerData)
 		} else {
 			// Print unchanged config line.
-			fmt.Fprintln(fd, line)
+			fmt.Fprintln(w, line)
 		}
 	}
-
+	if err := scanner.Err(); err != nil {
+		abort.Msg("Can't read %s: %v", configPath, err)
+	}
+	if err := w.Flush(); err != nil {
+		abort.Msg("Can't write %s: %v", outPath, err)
+	}
 	if err := fd.Close(); err != nil {
 		abort.Msg("Can't close %s: %v", outPath, err)
 	}
 }
 
+// Content hashes of intermediate and generated code.
+type manifests struct {
+	// From pass1 of current run; nil if pass1 sends devices by pipe.
+	pass1 jcode.Manifest
+	// From previous run; nil if not available.
+	// Is read, when first device has been received from pass1,
+	// because pass1 moves old files to directory .prev/ before.
+	prev     jcode.Manifest
+	prevDir  string
+	prevOnce sync.Once
+	// Collected during current run.
+	sync.Mutex
+	out     jcode.Manifest
+	changed []string
+}
+
+func readManifests(dir, prev string) *manifests {
+	m := &manifests{out: make(jcode.Manifest), prevDir: prev}
+	if !conf.Conf.Pipe {
+		m.pass1, _ = jcode.ReadManifest(dir + "/" + jcode.ManifestFile)
+	}
+	return m
+}
+
+func (m *manifests) readPrev() {
+	m.prevOnce.Do(func() {
+		path := m.prevDir + "/" + jcode.OutputManifestFile
+		m.prev, _ = jcode.ReadManifest(path)
+	})
+}
+
+// Get hashes of files with extension .config and .rules,
+// either from manifest or by reading files.
+// Hash of generated code is only read from manifest.
+func getHashes(m jcode.Manifest, devicePath, dir string) *jcode.Hashes {
+	if h := m[devicePath]; h != nil {
+		return h
+	}
+	file := dir + "/" + devicePath
+	config, err := fileop.Hash(file + ".config")
+	if err != nil {
+		return nil
+	}
+	rules, err := fileop.Hash(file + ".rules")
+	if err != nil {
+		return nil
+	}
+	return &jcode.Hashes{Config: config, Rules: rules}
+}
+
+// Record hashes of generated code.
+// Device is marked as changed, if generated code differs from
+// previous run.
+func (m *manifests) add(devicePath string, h *jcode.Hashes) {
+	m.Lock()
+	defer m.Unlock()
+	m.out[devicePath] = h
+	if p := m.prev[devicePath]; p == nil || p.Code != h.Code {
+		m.changed = append(m.changed, devicePath)
+	}
+}
+
+// Write manifest of generated code and list of changed devices.
+// Devices of previous run, that are no longer generated,
+// are listed as changed as well.
+func (m *manifests) write(dir string) {
+	if err := m.out.Write(dir + "/" + jcode.OutputManifestFile); err != nil {
+		abort.Msg("Can't write manifest: %v", err)
+	}
+	m.readPrev()
+	for devicePath := range m.prev {
+		if m.out[devicePath] == nil {
+			m.changed = append(m.changed, devicePath)
+		}
+	}
+	sort.Strings(m.changed)
+	var buf bytes.Buffer
+	for _, devicePath := range m.changed {
+		buf.WriteString(devicePath + "\n")
+	}
+	path := dir + "/" + jcode.ChangedFile
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		abort.Msg("Can't %v", err)
+	}
+}
+
 // Try to use pass2 file from previous run.
-// If identical files with extension .config and .rules
-// exist in directory .prev/, then use copy.
-func tryPrev(devicePath, dir, prev string) bool {
-	if !fileop.IsDir(prev) {
+// If files with extension .config and .rules in directory .prev/
+// have same hashes as current files, then use link or copy.
+func tryPrev(devicePath, dir, prev string, cur *jcode.Hashes, m *manifests) bool {
+	if cur == nil || !fileop.IsDir(prev) {
 		return false
 	}
 	prevFile := prev + "/" + devicePath
 	if !fileop.IsRegular(prevFile) {
 		return false
 	}
-	codeFile := dir + "/" + devicePath
-	for _, ext := range [...]string{"config", "rules"} {
-		pass1name := codeFile + "." + ext
-		pass1prev := prevFile + "." + ext
-		if !fileop.IsRegular(pass1prev) {
-			return false
-		}
-		cmd := exec.Command("cmp", "-s", pass1name, pass1prev)
-		if cmd.Run() != nil {
+	old := getHashes(m.prev, devicePath, prev)
+	if old == nil || old.Config != cur.Config || old.Rules != cur.Rules {
+		return false
+	}
+	code := old.Code
+	if code == "" {
+		var err error
+		if code, err = fileop.Hash(prevFile); err != nil {
 			return false
 		}
 	}
-	if err := os.Link(prevFile, codeFile); err != nil {
+	codeFile := dir + "/" + devicePath
+	if err := fileop.LinkOrCopy(prevFile, codeFile); err != nil {
 		return false
 	}
 
-	// File was found and hardlink was created successfully.
+	// File was found and link or copy was created successfully.
 	diag.Msg("Reused .prev/" + devicePath)
+	m.add(devicePath, &jcode.Hashes{
+		Config: cur.Config, Rules: cur.Rules, Code: code,
+		ACLLines: old.ACLLines})
 	return true
 }
 
-func readFileLines(filename string) []string {
-	fd, err := os.Open(filename)
-	if err != nil {
-		abort.Msg("Can't open %s for reading: %v", filename, err)
+func countACLLines(aclInfo *aclInfo) int {
+	return len(aclInfo.intfRules) + len(aclInfo.rules) + len(aclInfo.lrules)
+}
+
+// Report of option --stats.
+type passStats struct {
+	Phases []stats.Phase `json:"phases"`
+	// Number of ACL lines of each device.
+	ACLLines map[string]int `json:"acl_lines"`
+}
+
+func writeStats(timer *stats.Timer, m *manifests) {
+	s := &passStats{
+		Phases:   []stats.Phase{timer.Stop()},
+		ACLLines: make(map[string]int),
 	}
-	result := make([]string, 0)
-	scanner := bufio.NewScanner(fd)
-	for scanner.Scan() {
-		line := scanner.Text()
-		result = append(result, line)
+	for devicePath, h := range m.out {
+		// Leave out reused device with unknown number of ACL lines.
+		if h.ACLLines != nil {
+			s.ACLLines[devicePath] = *h.ACLLines
+		}
 	}
-	if err := scanner.Err(); err != nil {
-		abort.Msg("While reading device names: %v", err)
+	if err := stats.Update(conf.Conf.Stats, "pass2", s); err != nil {
+		abort.Msg("Can't write statistics: %v", err)
 	}
-	return result
 }
 
 type pass2Result int
@@ -2727,24 +2948,36 @@ const (
 	reuse
 )
 
-func pass2File(devicePath, dir, prev string, c chan pass2Result) {
+func pass2File(devicePath, dir, prev string, m *manifests, c chan pass2Result) {
 	success := fail
 
 	// Send ok on success
 	defer func() { c <- success }()
 
-	if tryPrev(devicePath, dir, prev) {
+	m.readPrev()
+	cur := getHashes(m.pass1, devicePath, dir)
+	if tryPrev(devicePath, dir, prev, cur, m) {
 		success = reuse
 		return
 	}
 	file := dir + "/" + devicePath
-	routerData := prepareACLs(file + ".rules")
-	config := readFileLines(file + ".config")
-	printCombined(config, routerData, file)
+	r := openACLs(file + ".rules")
+	printCombined(file+".config", r, file)
+	r.close()
+	if cur != nil {
+		code, err := fileop.Hash(file)
+		if err != nil {
+			abort.Msg("Can't %v", err)
+		}
+		aclLines := r.routerData.aclLines
+		m.add(devicePath, &jcode.Hashes{
+			Config: cur.Config, Rules: cur.Rules, Code: code,
+			ACLLines: &aclLines})
+	}
 	success = ok
 }
 
-func applyConcurrent(deviceNamesFh *os.File, dir, prev string) {
+func applyConcurrent(deviceNamesFh *os.File, dir, prev string, m *manifests) {
 
 	var started, generated, reused, errors int
 	concurrent := conf.Conf.ConcurrencyPass2
@@ -2770,17 +3003,17 @@ func applyConcurrent(deviceNamesFh *os.File, dir, prev string) {
 
 		if 1 >= concurrent {
 			// Process sequentially.
-			pass2File(devicePath, dir, prev, c)
+			pass2File(devicePath, dir, prev, m, c)
 			waitAndCheck()
 		} else if workersLeft > 0 {
 			// Start concurrent jobs at beginning.
-			go pass2File(devicePath, dir, prev, c)
+			go pass2File(devicePath, dir, prev, m, c)
 			workersLeft--
 			started++
 		} else {
 			// Start next job, after some job has finished.
 			waitAndCheck()
-			go pass2File(devicePath, dir, prev, c)
+			go pass2File(devicePath, dir, prev, m, c)
 			started++
 		}
 	}
@@ -2806,6 +3039,7 @@ func applyConcurrent(deviceNamesFh *os.File, dir, prev string) {
 }
 
 func pass2(dir string) {
+	timer := stats.Start("pass2")
 	prev := dir + "/.prev"
 
 	// Read to be processed files either from STDIN or from file.
@@ -2821,7 +3055,12 @@ func pass2(dir string) {
 		}
 	}
 
-	applyConcurrent(fromPass1, dir, prev)
+	m := readManifests(dir, prev)
+	applyConcurrent(fromPass1, dir, prev, m)
+	m.write(dir)
+	if conf.Conf.Stats != "" {
+		writeStats(timer, m)
+	}
 
 	// Remove directory '.prev' created by pass1
 	// or remove symlink '.prev' created by newpolicy.pl.
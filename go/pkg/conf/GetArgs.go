@@ -93,7 +93,16 @@ type Config struct {
 	CheckUnusedGroups            TriState
 	CheckUnusedOwners            TriState
 	CheckUnusedProtocols         TriState
+	CheckCryptoPolicy            TriState
+	CryptoPolicyEncryption       []string
+	CryptoPolicyHash             []string
+	CryptoPolicyGroup            []string
+	CryptoPolicyIkeVersion       []string
+	CryptoPolicyIsakmpLifetime   int
+	CryptoPolicyIpsecLifetime    int
+	CryptoPolicyIpsecKilobytes   int
 	AutoDefaultRoute             bool
+	SummarizeRoutes              bool
 	ConcurrencyPass1             int
 	ConcurrencyPass2             int
 	IgnoreFiles                  *regexp.Regexp
@@ -102,6 +111,9 @@ type Config struct {
 	Verbose                      bool `flag:"verbose v"`
 	TimeStamps                   bool `flag:"time_stamps t"`
 	StartTime                    int64
+	Stats                        string
+	Snapshot                     string
+	Watch                        bool
 	Pipe                         bool
 }
 
@@ -159,6 +171,28 @@ func defaultOptions(fs *flag.FlagSet) *Config {
 		// 'policy_distribution_point', either directly or from inheritance.
 		CheckPolicyDistributionPoint: "",
 
+		// Check definitions of isakmp and ipsec used in crypto
+		// definitions against crypto policy given below.
+		CheckCryptoPolicy: "",
+
+		// Crypto policy: Allowed values of attributes of isakmp and ipsec.
+		// - encryption, esp_encryption,
+		// - hash, esp_authentication, ah,
+		// - group, pfs_group,
+		// - ike_version.
+		// Empty list allows all values.
+		CryptoPolicyEncryption: nil,
+		CryptoPolicyHash:       nil,
+		CryptoPolicyGroup:      nil,
+		CryptoPolicyIkeVersion: nil,
+
+		// Crypto policy: Maximum lifetime of isakmp and ipsec
+		// in seconds and maximum lifetime of ipsec in kilobytes.
+		// Value 0 doesn't restrict lifetime.
+		CryptoPolicyIsakmpLifetime: 0,
+		CryptoPolicyIpsecLifetime:  0,
+		CryptoPolicyIpsecKilobytes: 0,
+
 		// Optimize the number of routing entries per router:
 		// For each router find the hop, where the largest
 		// number of routing entries points to
@@ -167,6 +201,12 @@ func defaultOptions(fs *flag.FlagSet) *Config {
 		// which have no default route to the internet.
 		AutoDefaultRoute: true,
 
+		// Optimize the number of routing entries per router:
+		// Combine networks with same hop into smallest covering prefix,
+		// if this prefix doesn't overlap with some network,
+		// that is reachable via other hop.
+		SummarizeRoutes: false,
+
 		// Ignore these names when reading directories:
 		// - CVS and RCS directories
 		// - CVS working files
@@ -192,6 +232,18 @@ func defaultOptions(fs *flag.FlagSet) *Config {
 		// Use this value when printing passed time span.
 		StartTime: 0,
 
+		// Write report with resource usage of each phase
+		// in JSON format to this file.
+		Stats: "",
+
+		// Pass 1 saves processed model to this file.
+		// Query tools read model from this file, if it matches input.
+		Snapshot: "",
+
+		// Pass 1 doesn't terminate, but recompiles input
+		// after some file has changed.
+		Watch: false,
+
 		// Pass 1 writes processed device names to STDOUT,
 		// pass 2 reads to be processed device names from STDIN.
 		Pipe: false,
@@ -5,14 +5,18 @@ import (
 	"github.com/hknutzen/Netspoc/go/pkg/conf"
 	"github.com/hknutzen/Netspoc/go/pkg/fileop"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
+	"sync"
 )
 
 type Context struct {
 	Path string
 	Data string
 	IPV6 bool
+	// Position of file in processing order.
+	Index int
 }
 type parser func(*Context)
 
@@ -27,13 +31,88 @@ func processFile(input *Context, fn parser) {
 }
 
 func Walk(fname string, fn parser) {
+	for _, input := range collectFiles(fname) {
+		processFile(input, fn)
+	}
+}
+
+// WalkParallel is like Walk, but up to n files are read and processed
+// concurrently. Attribute Index of Context can be used to store
+// results in the same order as files would be processed by Walk.
+// Number of processed files is returned.
+func WalkParallel(fname string, n int, fn parser) int {
+	files := collectFiles(fname)
+	if n <= 1 {
+		for _, input := range files {
+			processFile(input, fn)
+		}
+		return len(files)
+	}
+	ch := make(chan *Context)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			for input := range ch {
+				processFile(input, fn)
+			}
+			wg.Done()
+		}()
+	}
+	for _, input := range files {
+		ch <- input
+	}
+	close(ch)
+	wg.Wait()
+	return len(files)
+}
+
+// Files returns files to be processed by Walk in same order.
+// Attribute Data isn't set.
+func Files(fname string) []*Context {
+	return collectFiles(fname)
+}
+
+// KeyFiles returns files below toplevel directory 'keys' in sorted
+// order. These files aren't processed by Walk, but are read
+// separately as public keys of WireGuard.
+func KeyFiles(fname string) []string {
+	dir := filepath.Join(fname, "keys")
+	if !fileop.IsDir(dir) {
+		return nil
+	}
+	var result []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if base := info.Name(); p != dir && base[0] == '.' {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			result = append(result, p)
+		}
+		return nil
+	})
+	return result
+}
+
+// Collect files to be processed in sorted order.
+func collectFiles(fname string) []*Context {
+	var result []*Context
+	add := func(fname string, v6 bool) {
+		input := &Context{Path: fname, IPV6: v6, Index: len(result)}
+		result = append(result, input)
+	}
 	v6 := conf.Conf.IPV6
 
 	// Handle toplevel file.
 	if !fileop.IsDir(fname) {
-		input := &Context{Path: fname, IPV6: v6}
-		processFile(input, fn)
-		return
+		add(fname, v6)
+		return result
 	}
 
 	ipvDir := "ipv6"
@@ -51,7 +130,7 @@ func Walk(fname string, fn parser) {
 		base := file.Name()
 
 		// Skip special file/directory.
-		if base == "config" || base == "raw" {
+		if base == "config" || base == "raw" || base == "keys" {
 			continue
 		}
 		name := filepath.Join(fname, base)
@@ -70,8 +149,7 @@ func Walk(fname string, fn parser) {
 			}
 
 			if !fileop.IsDir(fname) {
-				input := &Context{Path: fname, IPV6: v6}
-				processFile(input, fn)
+				add(fname, v6)
 				return
 			}
 			files, err := ioutil.ReadDir(fname)
@@ -86,4 +164,5 @@ func Walk(fname string, fn parser) {
 		}
 		walk(name, v6)
 	}
+	return result
 }
@@ -0,0 +1,364 @@
+// Package generate creates synthetic Netspoc policies of configurable
+// size and shape. Generated policies compile without errors and are
+// used to measure performance of pass 1 and pass 2.
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Params describe shape and size of generated policy.
+type Params struct {
+	// Number of security zones, each with one network.
+	Zones int
+	// Number of zones connected by each managed router.
+	ZonesPerRouter int
+	// Number of hosts in each network.
+	Hosts int
+	// Number of additional routers, that connect two zones and
+	// hence build loops.
+	Loops int
+	// Number of NAT tags, each bound at a subset of routers.
+	NATTags int
+	// Number of crypto hubs and number of spokes at each hub.
+	CryptoHubs int
+	Spokes     int
+	// Number of services.
+	Services int
+	// Nesting depth of groups used in services.
+	GroupDepth int
+	// Seed of random numbers. Same seed generates same policy.
+	Seed int64
+}
+
+// DefaultParams returns parameters of a small policy.
+func DefaultParams() Params {
+	return Params{
+		Zones:          20,
+		ZonesPerRouter: 4,
+		Hosts:          5,
+		Spokes:         2,
+		Services:       50,
+		GroupDepth:     2,
+		Seed:           1,
+	}
+}
+
+var models = []string{"ASA", "IOS", "NX-OS", "Linux"}
+
+type generator struct {
+	Params
+	rand        *rand.Rand
+	routerCount int
+	// Resulting files with content.
+	files map[string]*strings.Builder
+	// Next free host part of IP address in each zone network.
+	nextIP map[int]int
+}
+
+func (g *generator) printf(file, format string, args ...interface{}) {
+	b := g.files[file]
+	if b == nil {
+		b = new(strings.Builder)
+		g.files[file] = b
+	}
+	fmt.Fprintf(b, format, args...)
+}
+
+// Network of zone i has IP 10.x.y.0/24, NAT IP 11.x.y.0/24.
+func zoneNet(i int) string {
+	return fmt.Sprintf("%d.%d", 1+i/256, i%256)
+}
+
+// Get IP of next interface in network of zone i.
+func (g *generator) intfIP(i int) string {
+	n := g.nextIP[i]
+	if n == 0 {
+		n = 1
+	}
+	g.nextIP[i] = n + 1
+	return fmt.Sprintf("10.%s.%d", zoneNet(i), n)
+}
+
+// Managed router k has transit IP 10.0.x.y.
+func transitIP(k int) string {
+	return fmt.Sprintf("10.0.%d.%d", k/250, k%250+1)
+}
+
+// NAT tag bound at router k or empty string.
+func (g *generator) natTag(k int) string {
+	if g.NATTags == 0 {
+		return ""
+	}
+	return fmt.Sprintf("nat%d", k%g.NATTags+1)
+}
+
+func (g *generator) hardware(model, name string) string {
+	if model == "NX-OS" {
+		return "Ethernet" + name
+	}
+	return name
+}
+
+// Loops would lead to ambiguous static routes,
+// hence dynamic routing is used at each managed interface.
+func (g *generator) routing() string {
+	if g.Loops == 0 {
+		return ""
+	}
+	return " routing = OSPF;"
+}
+
+func (g *generator) zones() {
+	for i := 0; i < g.Zones; i++ {
+		file := fmt.Sprintf("topology/zone%d", i/100)
+		g.printf(file, "network:n%d = {\n ip = 10.%s.0/24;\n", i, zoneNet(i))
+		if tag := g.natTag(i / g.ZonesPerRouter); tag != "" {
+			g.printf(file, " nat:%s = { ip = 11.%s.0/24; }\n", tag, zoneNet(i))
+		}
+		for j := 0; j < g.Hosts; j++ {
+			g.printf(file, " host:h%d_%d = { ip = 10.%s.%d; }\n",
+				i, j, zoneNet(i), 100+j)
+		}
+		g.printf(file, "}\n")
+	}
+}
+
+func (g *generator) routers() {
+	file := "topology/routers"
+	g.printf(file, "network:transit = { ip = 10.0.0.0/16; }\n")
+	for k := 0; k < g.routerCount; k++ {
+		model := models[k%len(models)]
+		g.printf(file, "router:r%d = {\n managed;\n model = %s;\n", k, model)
+		g.printf(file, " interface:transit = { ip = %s; hardware = %s;%s",
+			transitIP(k), g.hardware(model, "0"), g.routing())
+		if tag := g.natTag(k); tag != "" {
+			g.printf(file, " bind_nat = %s;", tag)
+		}
+		g.printf(file, " }\n")
+		for i := k * g.ZonesPerRouter; i < (k+1)*g.ZonesPerRouter; i++ {
+			if i >= g.Zones {
+				break
+			}
+			g.printf(file, " interface:n%d = { ip = %s; hardware = %s;%s }\n",
+				i, g.intfIP(i), g.hardware(model, fmt.Sprint(i+1)), g.routing())
+		}
+		g.printf(file, "}\n")
+	}
+}
+
+// Loop router connects zones of two managed routers with same NAT tag.
+func (g *generator) loops() {
+	step := g.NATTags
+	if step == 0 {
+		step = 1
+	}
+	if g.routerCount <= step {
+		return
+	}
+	file := "topology/loops"
+	for j := 0; j < g.Loops; j++ {
+		k1 := j % g.routerCount
+		k2 := (k1 + step) % g.routerCount
+		offset := (j / g.routerCount) % g.ZonesPerRouter
+		a := k1*g.ZonesPerRouter + offset
+		b := k2*g.ZonesPerRouter + offset
+		if a >= g.Zones || b >= g.Zones || k1 == k2 {
+			continue
+		}
+		g.printf(file, "router:l%d = {\n managed;\n model = IOS;\n", j)
+		g.printf(file, " interface:n%d = { ip = %s; hardware = a;%s }\n",
+			a, g.intfIP(a), g.routing())
+		g.printf(file, " interface:n%d = { ip = %s; hardware = b;%s }\n",
+			b, g.intfIP(b), g.routing())
+		g.printf(file, "}\n")
+	}
+}
+
+// Each crypto hub is connected to transit network.
+// Spokes are unmanaged routers with one network each.
+func (g *generator) crypto() {
+	if g.CryptoHubs == 0 {
+		return
+	}
+	file := "topology/crypto"
+	g.printf(file, `ipsec:aes256SHA = {
+ key_exchange = isakmp:aes256SHA;
+ esp_encryption = aes256;
+ esp_authentication = sha;
+ pfs_group = 2;
+ lifetime = 1 hour;
+}
+isakmp:aes256SHA = {
+ authentication = rsasig;
+ encryption = aes256;
+ hash = sha;
+ group = 2;
+ lifetime = 43200 sec;
+ trust_point = ASDM_TrustPoint3;
+}
+`)
+	for h := 0; h < g.CryptoHubs; h++ {
+		g.printf(file, "crypto:c%d = { type = ipsec:aes256SHA; }\n", h)
+		g.printf(file, "network:dmz%d = { ip = 172.16.%d.0/24; }\n", h, h)
+		g.printf(file, `router:hub%d = {
+ managed;
+ model = ASA;
+ interface:transit = { ip = 10.0.255.%d; hardware = inside;%s }
+ interface:dmz%d = { ip = 172.16.%d.1; hub = crypto:c%d; hardware = outside; }
+}
+`, h, h+1, g.routing(), h, h, h)
+		for s := 0; s < g.Spokes; s++ {
+			g.printf(file, `router:spoke%d_%d = {
+ interface:dmz%d = {
+  ip = 172.16.%d.%d;
+  id = spoke%d_%d@example.com;
+  spoke = crypto:c%d;
+ }
+ interface:lan%d_%d;
+}
+network:lan%d_%d = { ip = 10.255.%d.0/24; }
+`, h, s, h, h, s+10, h, s, h, h, s, h, s, h*g.Spokes+s)
+		}
+	}
+}
+
+// Returns random object of some zone.
+func (g *generator) object() string {
+	z := g.rand.Intn(g.Zones)
+	if g.Hosts > 0 && g.rand.Intn(3) != 0 {
+		return fmt.Sprintf("host:h%d_%d", z, g.rand.Intn(g.Hosts))
+	}
+	return fmt.Sprintf("network:n%d", z)
+}
+
+// Returns n or less different objects, that haven't been seen before.
+func (g *generator) objects(n int, seen map[string]bool) string {
+	var l []string
+	for i := 0; i < n; i++ {
+		o := g.object()
+		if !seen[o] {
+			seen[o] = true
+			l = append(l, o)
+		}
+	}
+	sort.Strings(l)
+	return strings.Join(l, ", ")
+}
+
+var protocols = []string{
+	"tcp 22", "tcp 80", "tcp 443", "tcp 8080-8090", "udp 53", "udp 123",
+	"icmp 8", "tcp 1024-65535",
+}
+
+func (g *generator) protocols() string {
+	n := 1 + g.rand.Intn(3)
+	seen := make(map[string]bool)
+	var l []string
+	for i := 0; i < n; i++ {
+		p := protocols[g.rand.Intn(len(protocols))]
+		if !seen[p] {
+			seen[p] = true
+			l = append(l, p)
+		}
+	}
+	return strings.Join(l, ", ")
+}
+
+// Services use nested groups as user.
+// Some services reference networks behind crypto spokes.
+func (g *generator) services() {
+	for m := 0; m < g.Services; m++ {
+		file := fmt.Sprintf("services/s%d", m/100)
+		// Objects of nested groups must be different.
+		seen := make(map[string]bool)
+		group := ""
+		for d := 0; d < g.GroupDepth; d++ {
+			elements := g.objects(1+g.rand.Intn(4), seen)
+			if elements == "" {
+				elements = group
+			} else if group != "" {
+				elements = group + ", " + elements
+			}
+			group = fmt.Sprintf("group:g%d_%d", m, d)
+			g.printf(file, "%s = %s;\n", group, elements)
+		}
+		user := group
+		if user == "" {
+			user = g.objects(1+g.rand.Intn(4), seen)
+		}
+		dst := g.objects(1+g.rand.Intn(3), make(map[string]bool))
+		if g.CryptoHubs > 0 && g.Spokes > 0 && m%10 == 0 {
+			dst += fmt.Sprintf(", network:lan%d_%d",
+				g.rand.Intn(g.CryptoHubs), g.rand.Intn(g.Spokes))
+		}
+		g.printf(file, "service:s%d = {\n user = %s;\n", m, user)
+		g.printf(file, " permit src = user; dst = %s; prt = %s;\n",
+			dst, g.protocols())
+		if m%5 == 0 {
+			g.printf(file, " permit src = %s; dst = user; prt = %s;\n",
+				g.objects(1, make(map[string]bool)), g.protocols())
+		}
+		g.printf(file, "}\n")
+	}
+}
+
+// Generate returns content of generated files by relative file name.
+func Generate(p Params) map[string]string {
+	if p.ZonesPerRouter < 1 {
+		p.ZonesPerRouter = 1
+	}
+	if p.Zones < 1 {
+		p.Zones = 1
+	}
+	g := &generator{
+		Params:      p,
+		rand:        rand.New(rand.NewSource(p.Seed)),
+		routerCount: (p.Zones + p.ZonesPerRouter - 1) / p.ZonesPerRouter,
+		files:       make(map[string]*strings.Builder),
+		nextIP:      make(map[int]int),
+	}
+	g.routers()
+	g.zones()
+	g.loops()
+	g.crypto()
+	g.services()
+
+	// Services aren't checked for redundancy and unused groups,
+	// because they are randomly generated.
+	g.printf("config", `check_redundant_rules = 0;
+check_duplicate_rules = 0;
+check_unused_groups = 0;
+check_supernet_rules = 0;
+check_transient_supernet_rules = 0;
+check_unenforceable = 0;
+`)
+	result := make(map[string]string)
+	for name, b := range g.files {
+		result[name] = b.String()
+	}
+	return result
+}
+
+// Write generated files to directory dir, which must not exist.
+func Write(dir string, p Params) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+	for name, data := range Generate(p) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package jcode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Content hashes of files in output directory.
+// Pass1 writes hashes of intermediate code of each device to file
+// ManifestFile. Pass2 additionally records hash of generated code in
+// file OutputManifestFile. Files of a device are reused in next run,
+// if hashes of intermediate code are unchanged.
+type Manifest map[string]*Hashes
+
+type Hashes struct {
+	Config string `json:"config"`
+	Rules  string `json:"rules"`
+	Code   string `json:"code,omitempty"`
+	// Number of ACL lines in generated code.
+	// Is nil, if unknown, e.g. if read from manifest of older version.
+	ACLLines *int `json:"acl_lines,omitempty"`
+}
+
+const (
+	ManifestFile       = ".manifest"
+	OutputManifestFile = ".output-manifest"
+	// List of devices, where generated code has changed
+	// since previous run. Removed devices are listed as well;
+	// no file is found in output directory for these.
+	ChangedFile = ".changed"
+)
+
+func ReadManifest(path string) (Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Write manifest to temporary file and rename it afterwards.
+// Hence readers never see partially written manifest.
+func (m Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
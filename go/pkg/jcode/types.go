@@ -5,12 +5,14 @@ import (
 )
 
 // JSON format of intermediate code written by pass1 and read by pass2.
+// Field ACLs must be last, because pass2 reads the other fields first
+// and then decodes ACLs one by one.
 type RouterData struct {
 	Model         string     `json:"model"`
-	ACLs          []*ACLInfo `json:"acls"`
 	FilterOnly    []string   `json:"filter_only,omitempty"`
 	DoObjectgroup int        `json:"do_objectgroup,omitempty"`
 	LogDeny       string     `json:"log_deny,omitempty"`
+	ACLs          []*ACLInfo `json:"acls"`
 }
 
 type ACLInfo struct {
@@ -0,0 +1,787 @@
+// Package modify applies a change set given in JSON format to the
+// files of a Netspoc policy.
+//
+// A change set is a list of jobs. Each job has a method and
+// parameters:
+//
+//	[ { "method": "create_host",
+//	    "params": { "network": "n1", "name": "h1", "ip": "10.1.1.10" } },
+//	  { "method": "add_to_rule",
+//	    "params": { "service": "s1", "rule_num": 1, "src": "host:h1" } } ]
+//
+// All jobs are applied to the parsed files in memory.
+// Result is checked by pass1 and only if no error occurs,
+// changed files are written back.
+// Hence either all or none of the jobs of a change set take effect.
+package modify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/ast"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/diag"
+	"github.com/hknutzen/Netspoc/go/pkg/fileop"
+	"github.com/hknutzen/Netspoc/go/pkg/filetree"
+	"github.com/hknutzen/Netspoc/go/pkg/parser"
+	"github.com/hknutzen/Netspoc/go/pkg/pass1"
+	"github.com/hknutzen/Netspoc/go/pkg/printer"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Job struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// ParseJobs reads a change set. Data is either a list of jobs
+// or a single job.
+func ParseJobs(data []byte) ([]*Job, error) {
+	data = bytes.TrimSpace(data)
+	var jobs []*Job
+	if len(data) > 0 && data[0] == '{' {
+		job := new(Job)
+		if err := json.Unmarshal(data, job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	} else if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+type file struct {
+	path    string
+	source  []byte
+	ipv6    bool
+	nodes   []ast.Toplevel
+	changed bool
+}
+
+type state struct {
+	path  string
+	files []*file
+}
+
+func (s *state) readFiles() {
+	filetree.Walk(s.path, func(input *filetree.Context) {
+		source := []byte(input.Data)
+		f := &file{
+			path:   input.Path,
+			source: source,
+			ipv6:   input.IPV6,
+			nodes:  parser.ParseFile(source, input.Path),
+		}
+		s.files = append(s.files, f)
+	})
+}
+
+// Find toplevel definition with typed name.
+func (s *state) findToplevel(name string) (*file, int) {
+	for _, f := range s.files {
+		for i, n := range f.nodes {
+			if n.GetName() == name {
+				return f, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+func (s *state) getToplevel(name string) (*file, ast.Toplevel, error) {
+	f, i := s.findToplevel(name)
+	if f == nil {
+		return nil, nil, fmt.Errorf("Can't find %s", name)
+	}
+	return f, f.nodes[i], nil
+}
+
+// Get file where new definition is added.
+// Relative name is taken from directory of policy.
+// If policy is given as single file, definition is added there.
+func (s *state) getFile(name string) (*file, error) {
+	if name == "" {
+		if fileop.IsDir(s.path) {
+			return nil, fmt.Errorf("Missing 'file' for new definition")
+		}
+		return s.files[0], nil
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+		return nil, fmt.Errorf("Invalid file name: %s", name)
+	}
+	path := filepath.Join(s.path, name)
+	for _, f := range s.files {
+		if f.path == path {
+			return f, nil
+		}
+	}
+	if !fileop.IsDir(s.path) {
+		return nil, fmt.Errorf("Can't add file %s to single file %s",
+			name, s.path)
+	}
+	// Add new file. IPv6 is derived from directory name in the
+	// same way as in filetree.Walk.
+	v6 := conf.Conf.IPV6
+	for _, part := range strings.Split(filepath.Dir(name), "/") {
+		switch part {
+		case "ipv4":
+			v6 = false
+		case "ipv6":
+			v6 = true
+		}
+	}
+	f := &file{path: path, ipv6: v6}
+	s.files = append(s.files, f)
+	return f, nil
+}
+
+// Elements parsed from change set have positions relative to some
+// other source. Clear positions, so printer doesn't take comments
+// from wrong place.
+func clearPos(l []ast.Element) {
+	for _, el := range l {
+		switch x := el.(type) {
+		case *ast.User:
+			x.Start = 0
+		case *ast.NamedRef:
+			x.Start = 0
+		case *ast.IntfRef:
+			x.Start = 0
+		case *ast.SimpleAuto:
+			x.Start = 0
+			clearPos(x.Elements)
+		case *ast.AggAuto:
+			x.Start = 0
+			clearPos(x.Elements)
+		case *ast.IntfAuto:
+			x.Start = 0
+			clearPos(x.Elements)
+		case *ast.Intersection:
+			x.Start = 0
+			clearPos(x.Elements)
+		case *ast.Complement:
+			x.Start = 0
+			clearPos([]ast.Element{x.Element})
+		}
+	}
+}
+
+// Parse list of elements.
+// Program is aborted on syntax error, before any file is written.
+func parseUnion(s string) []ast.Element {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	l := parser.ParseUnion([]byte(s))
+	clearPos(l)
+	return l
+}
+
+func getValues(s string) []*ast.Value {
+	var result []*ast.Value
+	for _, v := range strings.Split(s, ",") {
+		v = strings.Join(strings.Fields(v), " ")
+		if v != "" {
+			result = append(result, &ast.Value{Value: v})
+		}
+	}
+	return result
+}
+
+func elementName(el ast.Element) string {
+	switch el.(type) {
+	case *ast.NamedRef, *ast.IntfRef:
+		return el.GetType() + ":" + el.GetName()
+	}
+	return ""
+}
+
+func addElements(l *[]ast.Element, add []ast.Element) {
+	seen := make(map[string]bool)
+	for _, el := range *l {
+		seen[elementName(el)] = true
+	}
+	for _, el := range add {
+		if name := elementName(el); name == "" || !seen[name] {
+			*l = append(*l, el)
+		}
+	}
+}
+
+func removeElements(l *[]ast.Element, rm []ast.Element, ctx string) error {
+	for _, el := range rm {
+		name := elementName(el)
+		if name == "" {
+			return fmt.Errorf("Can only remove named element from %s", ctx)
+		}
+		found := false
+		for i, el2 := range *l {
+			if elementName(el2) == name {
+				*l = append((*l)[:i], (*l)[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Can't find %s in %s", name, ctx)
+		}
+	}
+	return nil
+}
+
+func addValues(l *[]*ast.Value, add []*ast.Value) {
+	seen := make(map[string]bool)
+	for _, v := range *l {
+		seen[v.Value] = true
+	}
+	for _, v := range add {
+		if !seen[v.Value] {
+			*l = append(*l, v)
+		}
+	}
+}
+
+func removeValues(l *[]*ast.Value, rm []*ast.Value, ctx string) error {
+	for _, v := range rm {
+		found := false
+		for i, v2 := range *l {
+			if strings.Join(strings.Fields(v2.Value), " ") == v.Value {
+				*l = append((*l)[:i], (*l)[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Can't find '%s' in %s", v.Value, ctx)
+		}
+	}
+	return nil
+}
+
+// Set value of attribute in list of attributes.
+// Attribute is removed if value is nil.
+// Attribute without value is added if value is empty.
+func setAttr(l *[]*ast.Attribute, name string, value *string) {
+	var a *ast.Attribute
+	if value != nil {
+		a = &ast.Attribute{Name: name, ValueList: getValues(*value)}
+	}
+	for i, a2 := range *l {
+		if a2.Name == name {
+			if a == nil {
+				*l = append((*l)[:i], (*l)[i+1:]...)
+			} else {
+				// Retain position for comments.
+				a.Start = a2.Start
+				a.Next = a2.Next
+				(*l)[i] = a
+			}
+			return
+		}
+	}
+	if a != nil {
+		*l = append(*l, a)
+	}
+}
+
+func typedName(typ, name string) string {
+	return typ + ":" + strings.TrimPrefix(name, typ+":")
+}
+
+func (s *state) getService(name string) (*file, *ast.Service, error) {
+	f, n, err := s.getToplevel(typedName("service", name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, n.(*ast.Service), nil
+}
+
+// Find list of attributes of named object.
+// Hosts and interfaces are found in attributes of network or router.
+func (s *state) getAttributes(name string) (*file, *[]*ast.Attribute, error) {
+	typ, objName := splitTypedName(name)
+	findSub := func(l []*ast.Attribute, sub string) *[]*ast.Attribute {
+		for _, a := range l {
+			if a.Name == sub {
+				return &a.ComplexValue
+			}
+		}
+		return nil
+	}
+	switch typ {
+	case "host":
+		for _, f := range s.files {
+			for _, n := range f.nodes {
+				if x, ok := n.(*ast.Network); ok {
+					if l := findSub(x.Hosts, name); l != nil {
+						return f, l, nil
+					}
+				}
+			}
+		}
+	case "interface":
+		i := strings.Index(objName, ".")
+		if i != -1 {
+			rName := "router:" + objName[:i]
+			iName := "interface:" + objName[i+1:]
+			for _, f := range s.files {
+				for _, n := range f.nodes {
+					if x, ok := n.(*ast.Router); ok && x.Name == rName {
+						if l := findSub(x.Interfaces, iName); l != nil {
+							return f, l, nil
+						}
+					}
+				}
+			}
+		}
+	default:
+		f, n, err := s.getToplevel(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch x := n.(type) {
+		case *ast.TopStruct:
+			return f, &x.Attributes, nil
+		case *ast.Network:
+			return f, &x.Attributes, nil
+		case *ast.Router:
+			return f, &x.Attributes, nil
+		case *ast.Area:
+			return f, &x.Attributes, nil
+		case *ast.Service:
+			return f, &x.Attributes, nil
+		}
+		return nil, nil, fmt.Errorf("Can't set attribute of %s", name)
+	}
+	return nil, nil, fmt.Errorf("Can't find %s", name)
+}
+
+func splitTypedName(s string) (string, string) {
+	i := strings.Index(s, ":")
+	if i == -1 {
+		return "", s
+	}
+	return s[:i], s[i+1:]
+}
+
+type jsonRule struct {
+	Action string `json:"action"`
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Prt    string `json:"prt"`
+	Log    string `json:"log"`
+}
+
+func (s *state) createService(j *Job) error {
+	var p struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description"`
+		User        string     `json:"user"`
+		Rules       []jsonRule `json:"rules"`
+		File        string     `json:"file"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	name := typedName("service", p.Name)
+	if f, _ := s.findToplevel(name); f != nil {
+		return fmt.Errorf("Duplicate definition of %s", name)
+	}
+	sv := new(ast.Service)
+	sv.Name = name
+	if d := p.Description; d != "" {
+		sv.Description = &ast.Description{Text: " " + d}
+	}
+	sv.User = &ast.NamedUnion{Name: "user", Elements: parseUnion(p.User)}
+	for _, r := range p.Rules {
+		rule := new(ast.Rule)
+		switch r.Action {
+		case "deny":
+			rule.Deny = true
+		case "permit":
+		default:
+			return fmt.Errorf("Expected 'permit' or 'deny' in rule of %s", name)
+		}
+		rule.Src = &ast.NamedUnion{Name: "src", Elements: parseUnion(r.Src)}
+		rule.Dst = &ast.NamedUnion{Name: "dst", Elements: parseUnion(r.Dst)}
+		rule.Prt = &ast.Attribute{Name: "prt", ValueList: getValues(r.Prt)}
+		if r.Log != "" {
+			rule.Log = &ast.Attribute{Name: "log", ValueList: getValues(r.Log)}
+		}
+		sv.Rules = append(sv.Rules, rule)
+	}
+	f, err := s.getFile(p.File)
+	if err != nil {
+		return err
+	}
+	if f.ipv6 {
+		sv.SetIPV6()
+	}
+	sv.Order()
+	f.nodes = append(f.nodes, sv)
+	f.changed = true
+	return nil
+}
+
+func (s *state) deleteService(j *Job) error {
+	var p struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	name := typedName("service", p.Name)
+	f, i := s.findToplevel(name)
+	if f == nil {
+		return fmt.Errorf("Can't find %s", name)
+	}
+	f.nodes = append(f.nodes[:i], f.nodes[i+1:]...)
+	f.changed = true
+	return nil
+}
+
+func (s *state) changeUser(j *Job, add bool) error {
+	var p struct {
+		Service string `json:"service"`
+		User    string `json:"user"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	f, sv, err := s.getService(p.Service)
+	if err != nil {
+		return err
+	}
+	l := parseUnion(p.User)
+	if add {
+		addElements(&sv.User.Elements, l)
+	} else {
+		err := removeElements(&sv.User.Elements, l, "user of "+sv.Name)
+		if err != nil {
+			return err
+		}
+	}
+	sv.Order()
+	f.changed = true
+	return nil
+}
+
+func (s *state) addToUser(j *Job) error      { return s.changeUser(j, true) }
+func (s *state) removeFromUser(j *Job) error { return s.changeUser(j, false) }
+
+func (s *state) changeRule(j *Job, add bool) error {
+	var p struct {
+		Service string `json:"service"`
+		RuleNum int    `json:"rule_num"`
+		Src     string `json:"src"`
+		Dst     string `json:"dst"`
+		Prt     string `json:"prt"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	f, sv, err := s.getService(p.Service)
+	if err != nil {
+		return err
+	}
+	if p.RuleNum < 1 || p.RuleNum > len(sv.Rules) {
+		return fmt.Errorf("Invalid rule_num %d, have %d rules in %s",
+			p.RuleNum, len(sv.Rules), sv.Name)
+	}
+	r := sv.Rules[p.RuleNum-1]
+	ctx := fmt.Sprintf("rule %d of %s", p.RuleNum, sv.Name)
+	for _, u := range []*ast.NamedUnion{r.Src, r.Dst} {
+		val := p.Src
+		if u == r.Dst {
+			val = p.Dst
+		}
+		l := parseUnion(val)
+		if add {
+			addElements(&u.Elements, l)
+		} else {
+			err := removeElements(&u.Elements, l, u.Name+" of "+ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	prt := getValues(p.Prt)
+	if add {
+		addValues(&r.Prt.ValueList, prt)
+	} else if err := removeValues(&r.Prt.ValueList, prt, ctx); err != nil {
+		return err
+	}
+	sv.Order()
+	f.changed = true
+	return nil
+}
+
+func (s *state) addToRule(j *Job) error      { return s.changeRule(j, true) }
+func (s *state) removeFromRule(j *Job) error { return s.changeRule(j, false) }
+
+func (s *state) createHost(j *Job) error {
+	var p struct {
+		Network string `json:"network"`
+		Name    string `json:"name"`
+		IP      string `json:"ip"`
+		Range   string `json:"range"`
+		Owner   string `json:"owner"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	netName := typedName("network", p.Network)
+	f, n, err := s.getToplevel(netName)
+	if err != nil {
+		return err
+	}
+	x, ok := n.(*ast.Network)
+	if !ok {
+		return fmt.Errorf("Can't add host to %s", netName)
+	}
+	name := typedName("host", p.Name)
+	if _, l, _ := s.getAttributes(name); l != nil {
+		return fmt.Errorf("Duplicate definition of %s", name)
+	}
+	var attr []*ast.Attribute
+	switch {
+	case p.IP != "" && p.Range == "":
+		setAttr(&attr, "ip", &p.IP)
+	case p.Range != "" && p.IP == "":
+		setAttr(&attr, "range", &p.Range)
+	default:
+		return fmt.Errorf("Must give either 'ip' or 'range' for %s", name)
+	}
+	if p.Owner != "" {
+		ow := strings.TrimPrefix(p.Owner, "owner:")
+		setAttr(&attr, "owner", &ow)
+	}
+	x.Hosts = append(x.Hosts, &ast.Attribute{Name: name, ComplexValue: attr})
+	x.Order()
+	f.changed = true
+	return nil
+}
+
+func (s *state) changeOwner(j *Job) error {
+	var p struct {
+		Name  string `json:"name"`
+		Owner string `json:"owner"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	switch typ, _ := splitTypedName(p.Name); typ {
+	case "network", "host", "interface", "router", "any", "area":
+	default:
+		return fmt.Errorf("Can't change owner of %s", p.Name)
+	}
+	f, l, err := s.getAttributes(p.Name)
+	if err != nil {
+		return err
+	}
+	var val *string
+	if p.Owner != "" {
+		ow := strings.TrimPrefix(p.Owner, "owner:")
+		val = &ow
+	}
+	setAttr(l, "owner", val)
+	f.changed = true
+	return nil
+}
+
+func (s *state) setAttribute(j *Job) error {
+	var p struct {
+		Name      string  `json:"name"`
+		Attribute string  `json:"attribute"`
+		Value     *string `json:"value"`
+	}
+	if err := json.Unmarshal(j.Params, &p); err != nil {
+		return err
+	}
+	if p.Attribute == "" || strings.IndexAny(p.Attribute, " =;,{}") != -1 {
+		return fmt.Errorf("Invalid attribute name '%s'", p.Attribute)
+	}
+	f, l, err := s.getAttributes(p.Name)
+	if err != nil {
+		return err
+	}
+	setAttr(l, p.Attribute, p.Value)
+	f.changed = true
+	return nil
+}
+
+var methods = map[string]func(*state, *Job) error{
+	"create_service":   (*state).createService,
+	"delete_service":   (*state).deleteService,
+	"add_to_user":      (*state).addToUser,
+	"remove_from_user": (*state).removeFromUser,
+	"add_to_rule":      (*state).addToRule,
+	"remove_from_rule": (*state).removeFromRule,
+	"create_host":      (*state).createHost,
+	"change_owner":     (*state).changeOwner,
+	"set_attribute":    (*state).setAttribute,
+}
+
+// Print changed files, parse printed result again and check whole
+// policy by pass1. Parsing again ensures, that exactly those
+// definitions are checked, that will be written to files.
+func (s *state) check() (map[*file][]byte, error) {
+	result := make(map[*file][]byte)
+	var all []ast.Toplevel
+	for _, f := range s.files {
+		source := f.source
+		nodes := f.nodes
+		if f.changed {
+			source = printer.File(nodes, f.source)
+			result[f] = source
+			nodes = parser.ParseFile(source, f.path)
+		}
+		if f.ipv6 {
+			for _, n := range nodes {
+				n.SetIPV6()
+			}
+		}
+		all = append(all, nodes...)
+	}
+	// Don't show progress messages of pass1.
+	verbose := conf.Conf.Verbose
+	conf.Conf.Verbose = false
+	errCount := pass1.CheckAST(all)
+	conf.Conf.Verbose = verbose
+	if errCount > 0 {
+		return nil, fmt.Errorf("Changes are not applied because of errors")
+	}
+	return result, nil
+}
+
+// Apply reads policy from path, applies jobs and writes changed files.
+// Nothing is written if some job fails or the result has errors.
+func Apply(path string, jobs []*Job) error {
+	s := &state{path: strings.TrimSuffix(path, "/")}
+	s.readFiles()
+	for i, j := range jobs {
+		m := methods[j.Method]
+		if m == nil {
+			return fmt.Errorf("Unknown method '%s' in job %d", j.Method, i+1)
+		}
+		if err := m(s, j); err != nil {
+			return fmt.Errorf("%s in job %d (%s)", err, i+1, j.Method)
+		}
+	}
+	changed, err := s.check()
+	if err != nil {
+		return err
+	}
+	return s.write(changed)
+}
+
+// write stores changed files of policy.
+// All new content is written to temporary files first.
+// Only if this succeeds, temporary files are renamed to their final
+// names. If some step fails, files already renamed are restored to
+// their original content and temporary files and new directories
+// are removed. Hence the policy is either changed completely or not
+// at all.
+func (s *state) write(changed map[*file][]byte) error {
+	var files []*file
+	var tmpNames []string
+	var newDirs []string
+	cleanup := func() {
+		for _, tmp := range tmpNames {
+			os.Remove(tmp)
+		}
+		// Remove innermost directories first.
+		for i := len(newDirs) - 1; i >= 0; i-- {
+			os.Remove(newDirs[i])
+		}
+	}
+	for _, f := range s.files {
+		data, found := changed[f]
+		if !found {
+			continue
+		}
+		dir := filepath.Dir(f.path)
+		dirs, err := mkdirAll(dir)
+		newDirs = append(newDirs, dirs...)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		tmp, err := writeTemp(f.path, data)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		files = append(files, f)
+		tmpNames = append(tmpNames, tmp)
+	}
+	for i, f := range files {
+		if err := os.Rename(tmpNames[i], f.path); err != nil {
+			// Restore files already renamed.
+			for _, f := range files[:i] {
+				if f.source == nil {
+					os.Remove(f.path)
+				} else {
+					fileop.Overwrite(f.path, f.source)
+				}
+			}
+			cleanup()
+			return fmt.Errorf("Can't rename %s to %s: %v", tmpNames[i], f.path, err)
+		}
+	}
+	for _, f := range files {
+		diag.Info("Changed %s", f.path)
+	}
+	return nil
+}
+
+// mkdirAll creates directory dir and missing parent directories.
+// It returns the list of directories, that have been created,
+// outermost directory first.
+func mkdirAll(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; !fileop.IsDir(d); d = filepath.Dir(d) {
+		missing = append([]string{d}, missing...)
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	var created []string
+	for _, d := range missing {
+		if err := os.Mkdir(d, 0777); err != nil {
+			return created, err
+		}
+		created = append(created, d)
+	}
+	return created, nil
+}
+
+// writeTemp writes data to new temporary file in directory of path.
+// Name of temporary file starts with ".#", hence it is ignored when
+// reading files of policy.
+func writeTemp(path string, data []byte) (string, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; ; i++ {
+		tmp := filepath.Join(dir, fmt.Sprintf(".#%s.%d.%d", base, os.Getpid(), i))
+		fh, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("Can't create %s: %v", tmp, err)
+		}
+		_, err = fh.Write(data)
+		if err2 := fh.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return "", fmt.Errorf("Can't write to %s: %v", tmp, err)
+		}
+		return tmp, nil
+	}
+}
@@ -385,6 +385,18 @@ func (p *parser) multiValue(nextSpecial func(*parser)) *ast.Value {
 	return a
 }
 
+// Read port translation like "443 -> 8443".
+// Value is stored with single space around "->".
+func (p *parser) portMap(nextSpecial func(*parser)) *ast.Value {
+	a := p.value(nextSpecial)
+	for !(p.tok == "," || p.tok == ";" || p.tok == "") {
+		a.Value += p.tok
+		nextSpecial(p)
+	}
+	a.Value = strings.Replace(a.Value, "->", " -> ", 1)
+	return a
+}
+
 func (p *parser) protocolRef(nextSpecial func(*parser)) *ast.Value {
 	return p.multiValue((*parser).nextProto)
 }
@@ -437,8 +449,11 @@ var specialSubTokenAttr = map[string]func(*parser){
 var specialValueAttr = map[string]func(*parser, func(*parser)) *ast.Value{
 	"prt":            (*parser).protocolRef,
 	"general_permit": (*parser).protocolRef,
+	"keepalive":      (*parser).multiValue,
 	"lifetime":       (*parser).multiValue,
 	"range":          (*parser).multiValue,
+	"tcp":            (*parser).portMap,
+	"udp":            (*parser).portMap,
 }
 
 func (p *parser) specialAttribute(nextSpecial func(*parser)) *ast.Attribute {
@@ -673,6 +688,7 @@ var globalType = map[string]func(*parser) ast.Toplevel{
 	"crypto":          (*parser).topStruct,
 	"ipsec":           (*parser).topStruct,
 	"isakmp":          (*parser).topStruct,
+	"wireguard":       (*parser).topStruct,
 }
 
 func (p *parser) toplevel() ast.Toplevel {
@@ -702,6 +718,24 @@ func ParseFile(src []byte, fileName string) []ast.Toplevel {
 	return p.file()
 }
 
+// ParseFileErr is like ParseFile, but returns syntax error
+// instead of terminating program.
+func ParseFileErr(src []byte, fileName string) (l []ast.Toplevel, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*scanner.Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	p := new(parser)
+	p.scanner.SetPanicOnErr()
+	p.init(src, fileName)
+	return p.file(), nil
+}
+
 // Read from string
 func ParseUnion(src []byte) []ast.Element {
 	src = append(src, ';')
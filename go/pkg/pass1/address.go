@@ -90,3 +90,40 @@ func natAddress(ip net.IP, mask net.IPMask, nat map[string]net.IP, network *netw
 	}
 	return &net.IPNet{IP: mergeIP(ip, network), Mask: mask}
 }
+
+// Get port translations of host or interface, that are active in natSet.
+// Port translation is active together with static NAT of host or
+// interface inside network with dynamic NAT.
+func activePortNat(obj someObj, ns natSet) []*portNat {
+	var x *netObj
+	switch o := obj.(type) {
+	case *subnet:
+		x = &o.netObj
+	case *routerIntf:
+		x = &o.netObj
+	default:
+		return nil
+	}
+	if x.portNat == nil {
+		return nil
+	}
+	natNet := getNatNetwork(x.network, ns)
+	if !natNet.dynamic {
+		return nil
+	}
+	return x.portNat[natNet.natTag]
+}
+
+// Get translated port of protocol or 0, if protocol isn't a single
+// port, that is translated.
+func translatePort(l []*portNat, prt *proto) int {
+	if prt.ports[0] != prt.ports[1] {
+		return 0
+	}
+	for _, p := range l {
+		if p.proto == prt.proto && p.realPort == prt.ports[0] {
+			return p.natPort
+		}
+	}
+	return 0
+}
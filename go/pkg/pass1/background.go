@@ -15,11 +15,11 @@ func (c *spoc) startInBackground(f func(*spoc)) <-chan spocMsg {
 	ch := make(chan spocMsg, 1000)
 	c2.msgChan = ch
 	go func() {
+		defer close(ch)
 		f(&c2)
 		if conf.Conf.TimeStamps {
 			c2.progress("Finished background job")
 		}
-		close(ch)
 	}()
 	return ch
 }
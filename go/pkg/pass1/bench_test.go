@@ -0,0 +1,108 @@
+package pass1
+
+import (
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/generate"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Shapes of generated policies used in benchmarks.
+var benchShapes = []struct {
+	name   string
+	params func(p *generate.Params)
+}{
+	{"zones", func(p *generate.Params) { p.Zones = 400 }},
+	{"loops", func(p *generate.Params) { p.Zones = 200; p.Loops = 40 }},
+	{"nat", func(p *generate.Params) { p.Zones = 200; p.NATTags = 8 }},
+	{"crypto", func(p *generate.Params) {
+		p.Zones = 100
+		p.CryptoHubs = 4
+		p.Spokes = 20
+	}},
+	{"services", func(p *generate.Params) {
+		p.Zones = 100
+		p.Services = 3000
+		p.GroupDepth = 3
+	}},
+}
+
+// Run pass 1 on inDir, writing code to outDir.
+// Returns resource usage of phases and messages, if some error occurred.
+func runPass1(inDir, outDir string) (*passStats, []string) {
+	c := initSpoc()
+	c.quit = make(chan bool)
+	go func() {
+		defer close(c.msgChan)
+		c.initStats()
+		toplevel := parseFiles(inDir)
+		c.setupTopology(toplevel)
+		c.compile(inDir, outDir)
+		c.writeStats()
+	}()
+	var messages []string
+	errors := c.handleMessages(func(typ int, t string) {
+		switch typ {
+		case infoM, progressM:
+		default:
+			messages = append(messages, t)
+		}
+	})
+	if errors > 0 {
+		return nil, messages
+	}
+	return c.stats, nil
+}
+
+// BenchmarkPass1 compiles generated policies of different shapes and
+// additionally reports average wall time of each phase of pass 1.
+func BenchmarkPass1(b *testing.B) {
+	for _, shape := range benchShapes {
+		b.Run(shape.name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			inDir := filepath.Join(dir, "in")
+			p := generate.DefaultParams()
+			shape.params(&p)
+			if err := generate.Write(inDir, p); err != nil {
+				b.Fatal(err)
+			}
+			conf.ConfigFromArgsAndFile(
+				[]string{"--quiet", "--stats=" + filepath.Join(dir, "stats")},
+				inDir)
+			var names []string
+			wall := make(map[string]float64)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				outDir, err := ioutil.TempDir(dir, "out")
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				s, messages := runPass1(inDir, outDir)
+				b.StopTimer()
+				if s == nil {
+					b.Fatal(strings.Join(messages, "\n"))
+				}
+				for _, ph := range s.Phases {
+					if _, found := wall[ph.Name]; !found {
+						names = append(names, ph.Name)
+					}
+					wall[ph.Name] += ph.Wall
+				}
+				os.RemoveAll(outDir)
+				b.StartTimer()
+			}
+			for _, name := range names {
+				b.ReportMetric(wall[name]*1e9/float64(b.N), name+"-ns/op")
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package pass1
+
+import (
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Check attributes of isakmp and ipsec definitions against crypto
+// policy given in config. Only definitions are checked, that are
+// used by some crypto definition with active tunnels.
+func (c *spoc) checkCryptoPolicy() {
+	printType := conf.Conf.CheckCryptoPolicy
+	if printType == "" {
+		return
+	}
+	c = c.sortingSpoc()
+	cfg := conf.Conf
+
+	allowed := func(l []string, v string) bool {
+		if len(l) == 0 {
+			return true
+		}
+		for _, a := range l {
+			if strings.TrimSpace(a) == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Collect hubs and spokes of crypto definitions
+	// using some isakmp or ipsec definition.
+	type hubSpoke struct{ hubs, spokes map[*router]bool }
+	usedAt := make(map[string]*hubSpoke)
+	add := func(name string, hub, spoke *router) {
+		h := usedAt[name]
+		if h == nil {
+			h = &hubSpoke{make(map[*router]bool), make(map[*router]bool)}
+			usedAt[name] = h
+		}
+		h.hubs[hub] = true
+		h.spokes[spoke] = true
+	}
+	var ipsecList []*ipsec
+	var isakmpList []*isakmp
+	for _, cr := range symTable.crypto {
+		is := cr.ipsec
+		if is == nil {
+			continue
+		}
+		for _, tunnel := range cr.tunnels {
+			if tunnel.disabled {
+				continue
+			}
+			spoke, hub := tunnel.interfaces[0].router, tunnel.interfaces[1].router
+			if usedAt[is.name] == nil {
+				ipsecList = append(ipsecList, is)
+			}
+			add(is.name, hub, spoke)
+			if usedAt[is.isakmp.name] == nil {
+				isakmpList = append(isakmpList, is.isakmp)
+			}
+			add(is.isakmp.name, hub, spoke)
+		}
+	}
+
+	show := func(name string, violations stringList) {
+		if len(violations) == 0 {
+			return
+		}
+		names := func(m map[*router]bool) string {
+			var l stringList
+			for r := range m {
+				l.push(r.name)
+			}
+			sort.Strings(l)
+			return " - " + strings.Join(l, "\n - ")
+		}
+		h := usedAt[name]
+		c.warnOrErr(printType,
+			"%s violates crypto policy:\n - %s\n"+
+				" Affected hubs:\n%s\n Affected spokes:\n%s",
+			name, strings.Join(violations, "\n - "),
+			names(h.hubs), names(h.spokes))
+	}
+	for _, is := range isakmpList {
+		var v stringList
+		if !allowed(cfg.CryptoPolicyEncryption, is.encryption) {
+			v.push("encryption = " + is.encryption)
+		}
+		if !allowed(cfg.CryptoPolicyHash, is.hash) {
+			v.push("hash = " + is.hash)
+		}
+		if !allowed(cfg.CryptoPolicyGroup, is.group) {
+			v.push("group = " + is.group)
+		}
+		if !allowed(cfg.CryptoPolicyIkeVersion, strconv.Itoa(is.ikeVersion)) {
+			v.push("ike_version = " + strconv.Itoa(is.ikeVersion))
+		}
+		if max := cfg.CryptoPolicyIsakmpLifetime; max > 0 && is.lifetime > max {
+			v.push(fmt.Sprintf("lifetime = %d sec, exceeds %d sec",
+				is.lifetime, max))
+		}
+		show(is.name, v)
+	}
+	for _, is := range ipsecList {
+		var v stringList
+		if e := is.espEncryption; e != "" &&
+			!allowed(cfg.CryptoPolicyEncryption, e) {
+			v.push("esp_encryption = " + e)
+		}
+		if a := is.espAuthentication; a != "" && a != "none" &&
+			!allowed(cfg.CryptoPolicyHash, a) {
+			v.push("esp_authentication = " + a)
+		}
+		if a := is.ah; a != "" && a != "none" &&
+			!allowed(cfg.CryptoPolicyHash, a) {
+			v.push("ah = " + a)
+		}
+		if g := is.pfsGroup; g != "" && !allowed(cfg.CryptoPolicyGroup, g) {
+			v.push("pfs_group = " + g)
+		}
+		// lifetime is nil, if error has already been shown.
+		if lt := is.lifetime; lt != nil {
+			if max := cfg.CryptoPolicyIpsecLifetime; max > 0 && lt[0] > max {
+				v.push(fmt.Sprintf("lifetime = %d sec, exceeds %d sec",
+					lt[0], max))
+			}
+			if max := cfg.CryptoPolicyIpsecKilobytes; max > 0 && lt[1] > max {
+				v.push(fmt.Sprintf("lifetime = %d kilobytes, exceeds %d kilobytes",
+					lt[1], max))
+			}
+		}
+		show(is.name, v)
+	}
+	c.finish()
+}
@@ -448,3 +448,79 @@ func (c *spoc) checkDynamicNatRules(
 	process(c.allPathRules.deny)
 	process(c.allPathRules.permit)
 }
+
+// Check rules with host or interface having port translation.
+// In NAT domain, where port translation is active, host or interface
+// is only reachable by translated ports. Hence each protocol of rule
+// must be a single port, that is translated.
+// Such host or interface must not be used as source of rule,
+// because other ports are translated dynamically.
+func (c *spoc) checkPortNatRules() {
+	found := false
+	for _, n := range c.allNetworks {
+		for _, s := range n.subnets {
+			if s.portNat != nil {
+				found = true
+			}
+		}
+		for _, intf := range n.interfaces {
+			if intf.portNat != nil {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return
+	}
+	showRule := func(r *groupedRule, src, dst someObj, prt *proto) string {
+		sr := *r.serviceRule
+		sr.prt = []*proto{prt}
+		rule := *r
+		rule.serviceRule = &sr
+		rule.src = []someObj{src}
+		rule.dst = []someObj{dst}
+		return rule.print()
+	}
+	type key struct {
+		obj someObj
+		prt *proto
+	}
+	seen := make(map[key]bool)
+	check := func(r *groupedRule, l []someObj, other someObj, isSrc bool) {
+		ns := other.getNetwork().zone.natDomain.natSet
+		for _, obj := range l {
+			ports := activePortNat(obj, ns)
+			if ports == nil {
+				continue
+			}
+			tag := getNatNetwork(obj.getNetwork(), ns).natTag
+			if isSrc {
+				if !seen[key{obj, nil}] {
+					seen[key{obj, nil}] = true
+					c.err("%s with port translation of nat:%s"+
+						" must not be used as source in rule\n "+
+						showRule(r, obj, other, r.prt[0]),
+						obj, tag)
+				}
+				continue
+			}
+			for _, prt := range r.prt {
+				if translatePort(ports, prt) != 0 || seen[key{obj, prt}] {
+					continue
+				}
+				seen[key{obj, prt}] = true
+				c.err("%s has no port translation of nat:%s for %s in rule\n "+
+					showRule(r, other, obj, prt),
+					obj, tag, prt.name)
+			}
+		}
+	}
+	process := func(list ruleList) {
+		for _, r := range list {
+			check(r, r.src, r.dst[0], true)
+			check(r, r.dst, r.src[0], false)
+		}
+	}
+	process(c.allPathRules.deny)
+	process(c.allPathRules.permit)
+}
@@ -16,7 +16,6 @@ type expandedRule struct {
 	prt       *proto
 	log       string
 	rule      *unexpRule
-	redundant bool
 	overlaps  bool
 }
 
@@ -171,9 +170,9 @@ func (c *spoc) checkAttrOverlaps(
 }
 
 func (c *spoc) collectDuplicateRules(
-	rule, other *expandedRule, collect *[][2]*expandedRule) {
+	t *ruleTable, rule, other int32, collect *[][2]*expandedRule) {
 
-	svc := rule.rule.service
+	svc := t.service(rule)
 
 	// Mark duplicate rules in both services.
 
@@ -182,12 +181,12 @@ func (c *spoc) collectDuplicateRules(
 	// compared with other. But we need to mark rule as well, because
 	// it must only be counted once, if it is both duplicate and
 	// redundandant.
-	rule.redundant = true
+	t.rules[rule].redundant = true
 	svc.duplicateCount++
-	osvc := other.rule.service
-	if !other.redundant {
+	osvc := t.service(other)
+	if !t.rules[other].redundant {
 		osvc.duplicateCount++
-		other.redundant = true
+		t.rules[other].redundant = true
 	}
 
 	// Link both services, so we later show only one of both service as
@@ -202,17 +201,18 @@ func (c *spoc) collectDuplicateRules(
 	osvc.hasSameDupl[svc] = true
 
 	// Return early, so overlapsUsed isn't set below.
-	if rule.overlaps && other.overlaps {
+	if t.overlaps(rule) && t.overlaps(other) {
 		return
 	}
 
-	if c.checkAttrOverlaps(svc, osvc, rule) ||
-		c.checkAttrOverlaps(osvc, svc, rule) {
+	e := t.expanded(rule)
+	if c.checkAttrOverlaps(svc, osvc, e) ||
+		c.checkAttrOverlaps(osvc, svc, e) {
 		return
 	}
 
 	if conf.Conf.CheckDuplicateRules != "" {
-		*collect = append(*collect, [2]*expandedRule{rule, other})
+		*collect = append(*collect, [2]*expandedRule{e, t.expanded(other)})
 	}
 }
 
@@ -235,24 +235,25 @@ func (c *spoc) showDuplicateRules(collected [][2]*expandedRule) {
 }
 
 func (c *spoc) collectRedundantRules(
-	rule, other *expandedRule, collect *[][2]*expandedRule) int {
+	t *ruleTable, rule, other int32, collect *[][2]*expandedRule) int {
 
-	service := rule.rule.service
+	service := t.service(rule)
 	count := 0
 
 	// Count each redundant rule only once.
-	if !rule.redundant {
-		rule.redundant = true
+	if r := &t.rules[rule]; !r.redundant {
+		r.redundant = true
 		count++
 		service.redundantCount++
 	}
 
-	if rule.overlaps && other.overlaps {
+	if t.overlaps(rule) && t.overlaps(other) {
 		return count
 	}
 
-	if !c.checkAttrOverlaps(service, other.rule.service, rule) {
-		*collect = append(*collect, [2]*expandedRule{rule, other})
+	e := t.expanded(rule)
+	if !c.checkAttrOverlaps(service, t.service(other), e) {
+		*collect = append(*collect, [2]*expandedRule{e, t.expanded(other)})
 	}
 	return count
 }
@@ -336,198 +337,307 @@ func (c *spoc) warnUnusedOverlaps() {
 	}
 }
 
+// Compact representation of expanded rule.
+// Objects and protocols are referenced by IDs, that are valid
+// only inside a single ruleTable.
+type expRule struct {
+	stateless bool
+	deny      bool
+	redundant bool
+	srcRange  int32
+	src       int32
+	dst       int32
+	prt       int32
+	// Index of grouped rule in ruleTable.
+	group int32
+}
+
+// Number of attributes of expRule, that are used for comparison.
+const expRuleLevels = 6
+
+// Objects, referenced by ID, together with ID of next larger object.
+// Host or interface with active port translation gets a separate ID
+// without next larger object, because a rule to some network isn't
+// applicable to translated port of this object.
+type objIDs struct {
+	id  map[objKey]int32
+	obj []someObj
+	up  []int32
+}
+
+type objKey struct {
+	obj     someObj
+	portNat bool
+}
+
+func (t *objIDs) intern(obj someObj, portNat bool) int32 {
+	key := objKey{obj, portNat}
+	if id, found := t.id[key]; found {
+		return id
+	}
+	id := int32(len(t.obj))
+	t.id[key] = id
+	t.obj = append(t.obj, obj)
+	t.up = append(t.up, -1)
+	if portNat {
+		return id
+	}
+	if up := obj.getUp(); up != nil {
+		t.up[id] = t.intern(up, false)
+	}
+	return id
+}
+
+// Protocols, referenced by ID, together with ID of next larger
+// protocol, as given by function 'next'.
+type prtIDs struct {
+	id   map[*proto]int32
+	prt  []*proto
+	up   []int32
+	next func(*proto) *proto
+}
+
+func (t *prtIDs) intern(prt *proto) int32 {
+	if id, found := t.id[prt]; found {
+		return id
+	}
+	id := int32(len(t.prt))
+	t.id[prt] = id
+	t.prt = append(t.prt, prt)
+	t.up = append(t.up, -1)
+	if up := t.next(prt); up != nil {
+		t.up[id] = t.intern(up)
+	}
+	return id
+}
+
+// Expanded rules of some grouped rules.
+type ruleTable struct {
+	grouped []*groupedRule
+	// Rules in order of expansion.
+	rules   []expRule
+	objects objIDs
+	ranges  prtIDs
+	prts    prtIDs
+}
+
 // Expand path_rules to elementary rules.
-func expandRules(rules []*groupedRule) []*expandedRule {
-	var result []*expandedRule
+// Missing srcRange is represented by protocol 'ip'.
+func expandRules(rules []*groupedRule, prtIP *proto) *ruleTable {
+	setLocalPrtRelation(rules)
+	t := &ruleTable{
+		grouped: rules,
+		objects: objIDs{id: make(map[objKey]int32)},
+		ranges: prtIDs{
+			id:   make(map[*proto]int32),
+			next: func(p *proto) *proto { return p.up },
+		},
+		prts: prtIDs{
+			id:   make(map[*proto]int32),
+			next: func(p *proto) *proto { return p.localUp },
+		},
+	}
+	n := 0
 	for _, rule := range rules {
+		n += len(rule.src) * len(rule.dst) * len(rule.prt)
+	}
+	t.rules = make([]expRule, 0, n)
+	for i, rule := range rules {
 		service := rule.rule.service
+		srcRange := rule.srcRange
+		if srcRange == nil {
+			srcRange = prtIP
+		}
+		e := expRule{
+			stateless: rule.stateless,
+			deny:      rule.deny,
+			srcRange:  t.ranges.intern(srcRange),
+			group:     int32(i),
+		}
 		for _, src := range rule.src {
+			e.src = t.objects.intern(src, false)
+			// Port translation of dst is active in NAT domain of src.
+			ns := src.getNetwork().zone.natDomain.natSet
 			for _, dst := range rule.dst {
+				e.dst = t.objects.intern(dst, activePortNat(dst, ns) != nil)
 				for _, prt := range rule.prt {
-					e := fillExpandedRule(rule)
-					e.src = src
-					e.dst = dst
-					e.prt = prt
-					result = append(result, e)
+					e.prt = t.prts.intern(prt)
+					t.rules = append(t.rules, e)
 					service.ruleCount++
 				}
 			}
 		}
 	}
-	return result
+	return t
 }
 
-// Build rule tree of nested maps.
-// Leaf node has rule as value.
-type ruleTree1 map[*proto]*expandedRule
-type ruleTree2 map[someObj]ruleTree1
-type ruleTree3 map[someObj]ruleTree2
-type ruleTree4 map[*proto]ruleTree3
-type ruleTree5 map[bool]ruleTree4
-type ruleTree map[bool]ruleTree5
+// Get expanded rule with referenced objects for printing.
+func (t *ruleTable) expanded(i int32) *expandedRule {
+	r := &t.rules[i]
+	e := fillExpandedRule(t.grouped[r.group])
+	e.src = t.objects.obj[r.src]
+	e.dst = t.objects.obj[r.dst]
+	e.prt = t.prts.prt[r.prt]
+	return e
+}
 
-func (tree ruleTree2) add(dst someObj) ruleTree1 {
-	subtree, found := tree[dst]
-	if !found {
-		subtree = make(ruleTree1)
-		tree[dst] = subtree
-	}
-	return subtree
+func (t *ruleTable) log(i int32) string {
+	return t.grouped[t.rules[i].group].log
 }
-func (tree ruleTree3) add(src someObj) ruleTree2 {
-	subtree, found := tree[src]
-	if !found {
-		subtree = make(ruleTree2)
-		tree[src] = subtree
-	}
-	return subtree
+
+func (t *ruleTable) service(i int32) *service {
+	return t.grouped[t.rules[i].group].rule.service
 }
-func (tree ruleTree4) add(srcRange *proto) ruleTree3 {
-	subtree, found := tree[srcRange]
-	if !found {
-		subtree = make(ruleTree3)
-		tree[srcRange] = subtree
-	}
-	return subtree
+
+func (t *ruleTable) overlaps(i int32) bool {
+	return t.grouped[t.rules[i].group].overlaps
 }
-func (tree ruleTree5) add(deny bool) ruleTree4 {
-	subtree, found := tree[deny]
-	if !found {
-		subtree = make(ruleTree4)
-		tree[deny] = subtree
+
+func boolID(b bool) int32 {
+	if b {
+		return 1
 	}
-	return subtree
+	return 0
 }
-func (tree ruleTree) add(stateless bool) ruleTree5 {
-	subtree, found := tree[stateless]
-	if !found {
-		subtree = make(ruleTree5)
-		tree[stateless] = subtree
+
+// Get value of attribute at level for comparison of rules.
+func (t *ruleTable) field(i int32, level int) int32 {
+	r := &t.rules[i]
+	switch level {
+	case 0:
+		return boolID(r.stateless)
+	case 1:
+		return boolID(r.deny)
+	case 2:
+		return r.srcRange
+	case 3:
+		return r.src
+	case 4:
+		return r.dst
+	default:
+		return r.prt
 	}
-	return subtree
 }
 
-// Build rule tree from expanded rules for efficient comparison of rules.
-// Rule tree is a nested map for ordering all rules.
-// Put attributes with small value set first, to get a more
-// memory efficient tree with few branches at root.
-func (c *spoc) buildRuleTree(
-	rules []*expandedRule, collect *[][2]*expandedRule) (ruleTree, int) {
-	count := 0
-	ruleTree := make(ruleTree)
-
-	// Simpler version of rule tree. It is used for rules without attributes
-	// deny, stateless and srcRange.
-	simpleTree := make(ruleTree3)
-
-	for _, rule := range rules {
-		srcRange := rule.srcRange
-		var midTree ruleTree3
+// Get next larger value of attribute at level or -1.
+// Stateless rule is compared with stateful rule,
+// permit rule is compared with deny rule.
+func (t *ruleTable) up(level int, v int32) int32 {
+	switch level {
+	case 0:
+		return v - 1
+	case 1:
+		if v == 0 {
+			return 1
+		}
+		return -1
+	case 2:
+		return t.ranges.up[v]
+	case 3, 4:
+		return t.objects.up[v]
+	default:
+		return t.prts.up[v]
+	}
+}
 
-		if rule.deny || rule.stateless || srcRange != nil {
-			if srcRange == nil {
-				srcRange = c.prt.IP
-			}
-			midTree = ruleTree.add(rule.stateless).add(rule.deny).add(srcRange)
-		} else {
-			midTree = simpleTree
+func (t *ruleTable) less(i, j int32) bool {
+	for level := 0; level < expRuleLevels; level++ {
+		a, b := t.field(i, level), t.field(j, level)
+		if a != b {
+			return a < b
 		}
-		leafMap := midTree.add(rule.src).add(rule.dst)
+	}
+	return false
+}
 
-		if otherRule, found := leafMap[rule.prt]; found {
-			if rule.log != otherRule.log {
-				c.err(
-					"Duplicate rules must have identical log attribute:\n %s\n %s",
-					otherRule.print(), rule.print())
-			}
+// Find sub slice of rules in l, having value v at level.
+// Rules in l must be sorted and must have equal values at
+// lower levels.
+func (t *ruleTable) lookup(l []int32, level int, v int32) []int32 {
+	lo := sort.Search(len(l), func(k int) bool {
+		return t.field(l[k], level) >= v
+	})
+	hi := lo + sort.Search(len(l)-lo, func(k int) bool {
+		return t.field(l[lo+k], level) > v
+	})
+	return l[lo:hi]
+}
 
-			// Found identical rule.
-			c.collectDuplicateRules(rule, otherRule, collect)
-			count++
-		} else {
-			leafMap[rule.prt] = rule
+// Sort rules for efficient comparison and find identical rules.
+// Returns sorted list of rules without duplicates and
+// number of duplicate rules.
+func (c *spoc) findDuplicateRules(
+	t *ruleTable, collect *[][2]*expandedRule) ([]int32, int) {
+
+	order := make([]int32, len(t.rules))
+	for i := range order {
+		order[i] = int32(i)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return t.less(order[i], order[j])
+	})
+
+	// Identical rules are adjacent. First rule in order of expansion
+	// is kept, following rules are duplicate to first rule.
+	var dupl [][2]int32
+	unique := order[:0]
+	for _, i := range order {
+		if n := len(unique); n > 0 {
+			other := unique[n-1]
+			if !t.less(other, i) {
+				dupl = append(dupl, [2]int32{i, other})
+				continue
+			}
 		}
+		unique = append(unique, i)
 	}
 
-	// Insert simpleTree into ruleTree.
-	if len(simpleTree) != 0 {
-		ruleTree.add(false).add(false)[c.prt.IP] = simpleTree
+	// Process duplicate rules in order of expansion.
+	sort.Slice(dupl, func(i, j int) bool { return dupl[i][0] < dupl[j][0] })
+	for _, pair := range dupl {
+		rule, other := pair[0], pair[1]
+		if t.log(rule) != t.log(other) {
+			c.err(
+				"Duplicate rules must have identical log attribute:\n %s\n %s",
+				t.expanded(other).print(), t.expanded(rule).print())
+		}
+		c.collectDuplicateRules(t, rule, other, collect)
 	}
-	return ruleTree, count
+	return unique, len(dupl)
 }
 
+// Compare each rule with all rules having same or larger values in
+// each attribute.
 func (c *spoc) findRedundantRules(
-	cmpHash ruleTree, collect *[][2]*expandedRule) int {
+	t *ruleTable, rules []int32, collect *[][2]*expandedRule) int {
 
-	chgHash := cmpHash
 	count := 0
-	for stateless, chgHash := range chgHash {
-		for {
-			if cmpHash, found := cmpHash[stateless]; found {
-				for deny, chgHash := range chgHash {
-					for {
-						if cmpHash, found := cmpHash[deny]; found {
-							for srcRange, chgHash := range chgHash {
-								for {
-									if cmpHash, found := cmpHash[srcRange]; found {
-										for src, chgHash := range chgHash {
-											for {
-												if cmpHash, found := cmpHash[src]; found {
-													for dst, chgHash := range chgHash {
-														for {
-															if cmpHash, found := cmpHash[dst]; found {
-																for prt, chgRule := range chgHash {
-																	for {
-																		if cmpRule, found :=
-																			cmpHash[prt]; found {
-																			if cmpRule !=
-																				chgRule &&
-																				cmpRule.log ==
-																					chgRule.log {
-																				count += c.collectRedundantRules(chgRule, cmpRule, collect)
-																			}
-																		}
-																		prt = prt.localUp
-																		if prt == nil {
-																			break
-																		}
-																	}
-																}
-															}
-															dst = dst.getUp()
-															if dst == nil {
-																break
-															}
-														}
-													}
-												}
-												src = src.getUp()
-												if src == nil {
-													break
-												}
-											}
-										}
-									}
-									srcRange = srcRange.up
-									if srcRange == nil {
-										break
-									}
-								}
-							}
-						}
-						if deny {
-							break
-						}
-						deny = true
-					}
+	var walk func(level int, chg, cmp []int32)
+	walk = func(level int, chg, cmp []int32) {
+		for len(chg) != 0 {
+			v := t.field(chg[0], level)
+			n := sort.Search(len(chg), func(k int) bool {
+				return t.field(chg[k], level) > v
+			})
+			part := chg[:n]
+			chg = chg[n:]
+			for u := v; u != -1; u = t.up(level, u) {
+				sub := t.lookup(cmp, level, u)
+				if len(sub) == 0 {
+					continue
+				}
+				if level < expRuleLevels-1 {
+					walk(level+1, part, sub)
+					continue
+				}
+				chgRule, cmpRule := part[0], sub[0]
+				if cmpRule != chgRule && t.log(cmpRule) == t.log(chgRule) {
+					count += c.collectRedundantRules(t, chgRule, cmpRule, collect)
 				}
 			}
-			if !stateless {
-				break
-			}
-			stateless = false
 		}
 	}
+	walk(0, rules, rules)
 	return count
 }
 
@@ -556,12 +666,11 @@ func (c *spoc) checkRedundantRules() {
 	add(c.allPathRules.deny)
 	add(c.allPathRules.permit)
 	for _, rules := range path2rules {
-		expandedRules := expandRules(rules)
-		count += len(expandedRules)
-		ruleTree, deleted := c2.buildRuleTree(expandedRules, &collectDupl)
+		t := expandRules(rules, c.prt.IP)
+		count += len(t.rules)
+		unique, deleted := c2.findDuplicateRules(t, &collectDupl)
 		dcount += deleted
-		setLocalPrtRelation(rules)
-		rcount += c2.findRedundantRules(ruleTree, &collectRedun)
+		rcount += c2.findRedundantRules(t, unique, &collectRedun)
 	}
 
 	c2.showDuplicateRules(collectDupl)
@@ -571,4 +680,8 @@ func (c *spoc) checkRedundantRules() {
 	c.showFullyRedundantRules()
 	c.info("Expanded rule count: %d; duplicate: %d; redundant: %d",
 		count, dcount, rcount)
+	if s := c.stats; s != nil {
+		s.Rules.Expanded, s.Rules.Duplicate, s.Rules.Redundant =
+			count, dcount, rcount
+	}
 }
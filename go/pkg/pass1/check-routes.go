@@ -0,0 +1,367 @@
+package pass1
+
+/*
+=head1 NAME
+
+check-routes - Compare static routes with routing table of devices
+
+=head1 SYNOPSIS
+
+check-routes [options] FILE|DIR ROUTE-DIR
+
+=head1 DESCRIPTION
+
+This program computes static routes of all managed routers from
+Netspoc configuration and compares them with routing tables of
+devices. Routing table of each router is read from file in ROUTE-DIR,
+that is named like the router without prefix "router:". Routing
+tables of IPv6 routers are read from subdirectory "ipv6/".
+
+A routing table is the saved output of command "show ip route",
+"show ipv6 route", "show route" or "ip route show".
+
+For each network, that is reached by a static route in Netspoc,
+the route with longest matching prefix is searched in routing
+table of device. Each deviation is printed as a single line:
+
+missing: network:X at router:R, expected via HOP
+
+wrong next hop: network:X at router:R, expected via HOP, found PREFIX via HOP2
+
+shadowed: network:X at router:R by PREFIX via HOP2
+
+Networks are shadowed, if some more specific route inside the network
+uses a different next hop.
+
+=head1 OPTIONS
+
+=over 4
+
+=item B<-ipv6>
+
+Expect IPv6 definitions everywhere except in subdirectory "ipv4/".
+
+=item B<-quiet>
+
+Don't print progress messages.
+
+=item B<-help>
+
+Prints a brief help message && exits.
+
+=item B<-man>
+
+Prints the manual page && exits.
+
+=back
+
+=head1 COPYRIGHT AND DISCLAIMER
+
+(c) 2020 by Heinz Knutzen <heinz.knutzengooglemail.com>
+
+This program uses modules of Netspoc, a Network Security Policy Compiler.
+http://hknutzen.github.com/Netspoc
+
+This program is free software; you can redistribute it &&/|| modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, ||
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY || FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along
+with this program; if !, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Entry of routing table of device.
+type deviceRoute struct {
+	*net.IPNet
+	// IP addresses or names of interfaces, used as next hop.
+	hops      stringList
+	connected bool
+}
+
+func (r *deviceRoute) hopsString() string {
+	if r.connected {
+		return "connected"
+	}
+	return strings.Join(r.hops, ", ")
+}
+
+// Parse text of routing table as shown by IOS, NX-OS, ASA or Linux.
+// Continuation lines without destination add next hops to previous
+// route.
+func parseRouteTable(text string, ipv6 bool) []*deviceRoute {
+	var result []*deviceRoute
+	var current *deviceRoute
+	var subnetMask net.IPMask
+	bits := 32
+	if ipv6 {
+		bits = 128
+	}
+	for _, line := range strings.Split(text, "\n") {
+		var tokens []string
+		for _, t := range strings.Fields(line) {
+			tokens = append(tokens, strings.Trim(t, ",*"))
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		// Find destination in tokens before "via".
+		var dst *net.IPNet
+		for i, t := range tokens {
+			if t == "via" {
+				break
+			}
+			if t == "default" && i == 0 {
+				dst = &net.IPNet{IP: getZeroIp(ipv6), Mask: getZeroMask(ipv6)}
+				break
+			}
+			if _, n, err := net.ParseCIDR(t); err == nil {
+				dst = n
+				break
+			}
+			ip := net.ParseIP(t)
+			if ip == nil {
+				continue
+			}
+			mask := subnetMask
+			if i+1 < len(tokens) && !ipv6 {
+				if m := net.ParseIP(tokens[i+1]).To4(); m != nil {
+					if _, b := net.IPMask(m).Size(); b != 0 {
+						mask = net.IPMask(m)
+					}
+				}
+			}
+			if mask == nil {
+				mask = net.CIDRMask(bits, bits)
+			}
+			dst = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+			break
+		}
+		if strings.Contains(line, "subnetted") {
+			subnetMask = nil
+			if dst != nil && !strings.Contains(line, "variably") {
+				subnetMask = dst.Mask
+			}
+			current = nil
+			continue
+		}
+		if dst != nil {
+			if (dst.IP.To4() == nil) != ipv6 {
+				current = nil
+				continue
+			}
+			current = &deviceRoute{IPNet: dst}
+			result = append(result, current)
+		}
+		if current == nil {
+			continue
+		}
+		for i, t := range tokens {
+			if t == "via" && i+1 < len(tokens) {
+				hop := tokens[i+1]
+				if ip := net.ParseIP(hop); ip != nil {
+					hop = ip.String()
+				}
+				current.hops.push(hop)
+			}
+		}
+		last := tokens[len(tokens)-1]
+		switch {
+		case strings.Contains(line, "directly connected"),
+			last == "direct", last == "local",
+			dst != nil && current.hops == nil &&
+				len(tokens) > 1 && tokens[1] == "dev":
+			current.connected = true
+		}
+	}
+
+	// Ignore routes without next hop, e.g. "Gateway of last resort".
+	j := 0
+	for _, r := range result {
+		if r.connected || r.hops != nil {
+			result[j] = r
+			j++
+		}
+	}
+	return result[:j]
+}
+
+// Find route with longest prefix, that matches whole network n.
+func findDeviceRoute(table []*deviceRoute, n *net.IPNet) *deviceRoute {
+	prefix, _ := n.Mask.Size()
+	var found *deviceRoute
+	max := -1
+	for _, r := range table {
+		p, _ := r.Mask.Size()
+		if p <= prefix && p > max && r.Contains(n.IP) {
+			found = r
+			max = p
+		}
+	}
+	return found
+}
+
+func (c *spoc) checkRouterRoutes(r *router, table []*deviceRoute) {
+	// Collect expected next hops of each network.
+	net2hops := make(map[*network]stringList)
+	var networks netList
+	for _, intf := range r.interfaces {
+		for hop, netMap := range intf.routes {
+			hopAddr := routeHopAddr(intf, hop)
+			for n := range netMap {
+				if n.hidden {
+					continue
+				}
+				if net2hops[n] == nil {
+					networks.push(n)
+				}
+				net2hops[n] = append(net2hops[n], hopAddr)
+			}
+		}
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		if cmp := bytes.Compare(networks[i].ip, networks[j].ip); cmp != 0 {
+			return cmp == -1
+		}
+		pi, _ := networks[i].mask.Size()
+		pj, _ := networks[j].mask.Size()
+		return pi < pj
+	})
+	for _, n := range networks {
+		hops := net2hops[n]
+		sort.Strings(hops)
+		ipNet := &net.IPNet{IP: n.ip, Mask: n.mask}
+		ctx := n.name + " at " + r.name
+		found := findDeviceRoute(table, ipNet)
+		if found == nil {
+			fmt.Printf("missing: %s, expected via %s\n",
+				ctx, strings.Join(hops, ", "))
+			continue
+		}
+		for _, hop := range hops {
+			ok := false
+			for _, h := range found.hops {
+				if h == hop {
+					ok = true
+				}
+			}
+			if !ok {
+				fmt.Printf("wrong next hop: %s, expected via %s, found %s via %s\n",
+					ctx, hop, found.IPNet, found.hopsString())
+			}
+		}
+
+		// More specific routes inside network must use same next hops.
+		prefix, _ := n.mask.Size()
+	ROUTE:
+		for _, rt := range table {
+			if p, _ := rt.Mask.Size(); p <= prefix || !ipNet.Contains(rt.IP) {
+				continue
+			}
+			if !rt.connected {
+				for _, h := range rt.hops {
+					for _, hop := range hops {
+						if h == hop {
+							continue ROUTE
+						}
+					}
+				}
+			}
+			fmt.Printf("shadowed: %s by %s via %s\n",
+				ctx, rt.IPNet, rt.hopsString())
+		}
+	}
+}
+
+func (c *spoc) checkRoutes(path, routeDir string) {
+	toplevel := parseFiles(path)
+	c.setupTopology(toplevel)
+	c.compile(path, "")
+	var routers []*router
+	routers = append(routers, c.managedRouters...)
+	routers = append(routers, c.routingOnlyRouters...)
+	sort.Slice(routers, func(i, j int) bool {
+		if routers[i].ipV6 != routers[j].ipV6 {
+			return routers[j].ipV6
+		}
+		return routers[i].name < routers[j].name
+	})
+	for _, r := range routers {
+		hasRoutes := false
+		for _, intf := range r.interfaces {
+			if len(intf.routes) != 0 {
+				hasRoutes = true
+			}
+		}
+		if !hasRoutes {
+			continue
+		}
+		file := r.name[len("router:"):]
+		if r.ipV6 {
+			file = filepath.Join("ipv6", file)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(routeDir, file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				c.warn("Missing routing table of %s", r)
+				continue
+			}
+			c.abort("Can't %v", err)
+		}
+		c.checkRouterRoutes(r, parseRouteTable(string(data), r.ipV6))
+	}
+}
+
+func CheckRoutesMain() int {
+	// Setup custom usage function.
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s [options] FILE|DIR ROUTE-DIR\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// Command line flags
+	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
+	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	pflag.Parse()
+
+	// Argument processing
+	args := pflag.Args()
+	if len(args) != 2 {
+		pflag.Usage()
+		os.Exit(1)
+	}
+	path := args[0]
+	routeDir := args[1]
+	dummyArgs := []string{
+		fmt.Sprintf("--verbose=%v", !*quiet),
+		fmt.Sprintf("--ipv6=%v", *ipv6),
+	}
+	conf.ConfigFromArgsAndFile(dummyArgs, path)
+	c := initSpoc()
+	go func() {
+		c.checkRoutes(path, routeDir)
+		close(c.msgChan)
+	}()
+	return c.printMessages()
+}
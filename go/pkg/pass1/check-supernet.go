@@ -5,6 +5,7 @@ import (
 	"github.com/hknutzen/Netspoc/go/pkg/conf"
 	"net"
 	"strings"
+	"sync"
 )
 
 // Two zones are zoneEq, if
@@ -105,8 +106,11 @@ func findZoneNetworks(zone *zone, ip net.IP, mask net.IPMask, natSet natSet, net
 	}
 
 	// Use cached result.
-	if net, found := zone.ipmask2net[key]; found {
-		return net
+	zoneNetLock.Lock()
+	cached, found := zone.ipmask2net[key]
+	zoneNetLock.Unlock()
+	if found {
+		return cached
 	}
 
 	// Real networks in zone without aggregates and without subnets.
@@ -126,13 +130,18 @@ func findZoneNetworks(zone *zone, ip net.IP, mask net.IPMask, natSet natSet, net
 			result = append(result, net)
 		}
 	}
+	zoneNetLock.Lock()
 	if zone.ipmask2net == nil {
 		zone.ipmask2net = make(map[ipmask]netList)
 	}
 	zone.ipmask2net[key] = result
+	zoneNetLock.Unlock()
 	return result
 }
 
+// Protects cache zone.ipmask2net, when rules are checked concurrently.
+var zoneNetLock sync.Mutex
+
 // rule: the rule to be checked
 // where: has value 'src' or 'dst'
 // interface: interface, where traffic reaches the device,
@@ -534,80 +543,83 @@ func (c *spoc) checkMissingSupernetRules(
 	rules ruleList, what string,
 	worker func(c *spoc, r *groupedRule, i, o *routerIntf, s map[*zone]bool)) {
 
-	for _, rule := range rules {
-		if rule.noCheckSupernetRules {
-			continue
-		}
-		var list []someObj
-		var oList []someObj
-		if what == "src" {
-			list = rule.src
-			oList = rule.dst
-		} else {
-			list = rule.dst
-			oList = rule.src
-		}
-		var supernets netList
-		for _, obj := range list {
-			if x, ok := obj.(*network); ok {
-				if x.hasOtherSubnet {
-					supernets = append(supernets, x)
+	// Rules are checked concurrently in parts.
+	c.inParallel(len(rules), func(c *spoc, _, from, to int) {
+		for _, rule := range rules[from:to] {
+			if rule.noCheckSupernetRules {
+				continue
+			}
+			var list []someObj
+			var oList []someObj
+			if what == "src" {
+				list = rule.src
+				oList = rule.dst
+			} else {
+				list = rule.dst
+				oList = rule.src
+			}
+			var supernets netList
+			for _, obj := range list {
+				if x, ok := obj.(*network); ok {
+					if x.hasOtherSubnet {
+						supernets = append(supernets, x)
+					}
 				}
 			}
-		}
-		if supernets == nil {
-			continue
-		}
+			if supernets == nil {
+				continue
+			}
 
-		// Build mapping from zone to hash of all src/dst networks and
-		// aggregates of current rule.
-		zone2netMap := make(map[*zone]map[*network]bool)
-		for _, obj := range list {
-			if x, ok := obj.(*network); ok {
-				zone := x.zone
-				netMap := zone2netMap[zone]
-				if netMap == nil {
-					netMap = make(map[*network]bool)
-					zone2netMap[zone] = netMap
+			// Build mapping from zone to hash of all src/dst networks and
+			// aggregates of current rule.
+			zone2netMap := make(map[*zone]map[*network]bool)
+			for _, obj := range list {
+				if x, ok := obj.(*network); ok {
+					zone := x.zone
+					netMap := zone2netMap[zone]
+					if netMap == nil {
+						netMap = make(map[*network]bool)
+						zone2netMap[zone] = netMap
+					}
+					netMap[x] = true
 				}
-				netMap[x] = true
 			}
-		}
-		rule.zone2netMap = zone2netMap
+			rule.zone2netMap = zone2netMap
 
-		groupInfo := splitRuleGroup(oList)
-		checkRule := new(groupedRule)
-		checkRule.serviceRule = rule.serviceRule
-		for _, supernet := range supernets {
-			seen := make(map[*zone]bool)
-			if what == "src" {
-				checkRule.src = []someObj{supernet}
-				checkRule.srcPath = supernet.zone
-			} else {
-				checkRule.dst = []someObj{supernet}
-				checkRule.dstPath = supernet.zone
-			}
-			for _, gi := range groupInfo {
-				otherZone := gi.path.getZone()
-				if zoneEq(supernet.zone, otherZone) {
-					continue
-				}
+			groupInfo := splitRuleGroup(oList)
+			checkRule := new(groupedRule)
+			checkRule.serviceRule = rule.serviceRule
+			for _, supernet := range supernets {
+				seen := make(map[*zone]bool)
 				if what == "src" {
-					checkRule.dstPath = gi.path
-					checkRule.dst = gi.group
+					checkRule.src = []someObj{supernet}
+					checkRule.srcPath = supernet.zone
 				} else {
-					checkRule.srcPath = gi.path
-					checkRule.src = gi.group
+					checkRule.dst = []someObj{supernet}
+					checkRule.dstPath = supernet.zone
+				}
+				for _, gi := range groupInfo {
+					otherZone := gi.path.getZone()
+					if zoneEq(supernet.zone, otherZone) {
+						continue
+					}
+					if what == "src" {
+						checkRule.dstPath = gi.path
+						checkRule.dst = gi.group
+					} else {
+						checkRule.srcPath = gi.path
+						checkRule.src = gi.group
+					}
+					c.pathWalk(checkRule,
+						func(r *groupedRule, i, o *routerIntf) {
+							worker(c, r, i, o, seen)
+						},
+						"Router")
 				}
-				c.pathWalk(checkRule,
-					func(r *groupedRule, i, o *routerIntf) {
-						worker(c, r, i, o, seen)
-					},
-					"Router")
 			}
+			rule.zone2netMap = nil
 		}
-		rule.zone2netMap = nil
-	}
+	})
 }
 
 func matchPrt(prt1, prt2 *proto) bool {
@@ -664,6 +676,35 @@ func matchPrtList(prtList1, prtList2 []*proto) bool {
 	return false
 }
 
+// Get protocols of rule with destination elements in dstList, as
+// seen in NAT domain with natSet. Port of protocol is changed for
+// elements with active port translation.
+func portNatPrtList(prtList []*proto, dstList []someObj, ns natSet) []*proto {
+	var result []*proto
+	plain := false
+	for _, obj := range dstList {
+		ports := activePortNat(obj, ns)
+		if ports == nil {
+			plain = true
+			continue
+		}
+		for _, prt := range prtList {
+			if p := translatePort(ports, prt); p != 0 {
+				result = append(result, natPrt(prt, p))
+			} else {
+				result = append(result, prt)
+			}
+		}
+	}
+	if result == nil {
+		return prtList
+	}
+	if plain {
+		result = append(result, prtList...)
+	}
+	return result
+}
+
 // Find those elements of list, with an IP address matching obj.
 // If element is aggregate that is supernet of obj,
 // than return all matching networks inside that aggregate.
@@ -978,9 +1019,6 @@ func (c *spoc) checkTransientSupernetRules(rules ruleList) {
 					}
 				}
 				for _, rule2 := range supernet2rules[obj2] {
-					if !matchPrtList(rule1.prt, rule2.prt) {
-						continue
-					}
 					getSrcRange := func(rule *groupedRule) *proto {
 						result := rule.srcRange
 						if result == nil {
@@ -1001,6 +1039,10 @@ func (c *spoc) checkTransientSupernetRules(rules ruleList) {
 							continue
 						}
 					}
+					prtList2 := portNatPrtList(rule2.prt, dstList2, natSet)
+					if !matchPrtList(rule1.prt, prtList2) {
+						continue
+					}
 					srcList2 := rule2.src
 
 					// Found transient rules rule1 and rule2.
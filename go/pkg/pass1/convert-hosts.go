@@ -87,8 +87,27 @@ func ipNATEqual(n1, n2 map[string]net.IP) bool {
 	return true
 }
 
+func portNATEqual(n1, n2 map[string][]*portNat) bool {
+	if len(n1) != len(n2) {
+		return false
+	}
+	for tag, l1 := range n1 {
+		l2 := n2[tag]
+		if len(l1) != len(l2) {
+			return false
+		}
+		for i, p := range l1 {
+			if *p != *l2[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (c *spoc) checkHostCompatibility(obj, other *netObj) {
-	if !ipNATEqual(obj.nat, other.nat) {
+	if !ipNATEqual(obj.nat, other.nat) ||
+		!portNATEqual(obj.portNat, other.portNat) {
 		c.err("Inconsistent NAT definition for %s and %s",
 			other.name, obj.name)
 	}
@@ -168,6 +187,7 @@ func (c *spoc) convertHosts() {
 					s.ip = net.IP
 					s.mask = net.Mask
 					s.nat = host.nat
+					s.portNat = host.portNat
 					s.owner = host.owner
 					s.id = id
 					s.ldapId = host.ldapId
@@ -323,7 +343,7 @@ func combineSubnets(list []someObj) []someObj {
 
 	// Find subnets in list.
 	for _, obj := range list {
-		if s, ok := obj.(*subnet); ok {
+		if s, ok := obj.(*subnet); ok && s.portNat == nil {
 			if s.neighbor != nil || s.hasNeighbor {
 				subnets = append(subnets, s)
 				m[s] = true
@@ -472,7 +492,7 @@ func (c *spoc) convertHostsInRules(sRules *serviceRules) (ruleList, ruleList) {
 		}
 		return cRules
 	}
-	return process(sRules.permit), process(sRules.deny)
+	return c.splitNat64Rules(process(sRules.permit), process(sRules.deny))
 }
 
 func (c *spoc) combineSubnetsInRules() {
@@ -259,9 +259,13 @@ func (c *spoc) markRulesPath(p pathRules) {
 }
 
 func (c *spoc) cutNetspoc(path string, names []string, keepOwner bool) {
-	toplevel := parseFiles(path)
-
-	if len(names) > 0 {
+	var toplevel []ast.Toplevel
+	var sRules *serviceRules
+	if len(names) == 0 {
+		// Model with all services may be taken from snapshot.
+		toplevel, sRules = c.readModelRules(path)
+	} else {
+		toplevel = parseFiles(path)
 		var copy []ast.Toplevel
 		retain := make(map[string]bool)
 		for i, name := range names {
@@ -287,20 +291,14 @@ func (c *spoc) cutNetspoc(path string, names []string, keepOwner bool) {
 				c.err("Unknown service:%s", name)
 			}
 		}
+		c.setupModel(toplevel)
+		sRules = c.normalizeServices()
 	}
-
-	c.setupTopology(toplevel)
 	for _, s := range symTable.service {
 		if !s.disabled {
 			isUsed[s.name] = true
 		}
 	}
-	c.markDisabled()
-	c.setZone()
-	c.setPath()
-	c.distributeNatInfo()
-	c.findSubnetsInZone()
-	sRules := c.normalizeServices()
 	permitRules, denyRules := c.convertHostsInRules(sRules)
 	c.groupPathRules(permitRules, denyRules)
 
@@ -663,9 +661,12 @@ func (c *spoc) cutNetspoc(path string, names []string, keepOwner bool) {
 			// already marked interfaces.
 			for _, crypto := range intf.hub {
 				isUsed[crypto.name] = true
-				typ := crypto.ipsec
-				isUsed[typ.name] = true
-				isUsed[typ.isakmp.name] = true
+				if typ := crypto.ipsec; typ != nil {
+					isUsed[typ.name] = true
+					isUsed[typ.isakmp.name] = true
+				} else {
+					isUsed[crypto.wireguard.name] = true
+				}
 			}
 
 			// Mark networks referenced by interfaces
@@ -970,6 +971,8 @@ func CutNetspocMain() int {
 	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
 	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
 	keepOwner := pflag.BoolP("owner", "o", false, "Keep referenced owners")
+	snapshot := pflag.String("snapshot", "",
+		"Read model from this file, if it matches input")
 	pflag.Parse()
 
 	// Argument processing
@@ -985,6 +988,7 @@ func CutNetspocMain() int {
 		fmt.Sprintf("--verbose=%v", !*quiet),
 		fmt.Sprintf("--ipv6=%v", *ipv6),
 		"--max_errors=9999",
+		"--snapshot=" + *snapshot,
 	}
 	conf.ConfigFromArgsAndFile(dummyArgs, path)
 
@@ -121,7 +121,10 @@ func distributeRule(rule *groupedRule, inIntf, outIntf *routerIntf) {
 			intf.rules = append(intf.rules, rule)
 		}
 	}
-	if inIntf.tunnel {
+	// Device with ACL for each pair of interfaces sees decrypted
+	// traffic at real interface. Hence rules are added to real
+	// interface below.
+	if inIntf.tunnel && !(model.noCryptoFilter && model.hasIoACL) {
 		noCryptoFilter := model.noCryptoFilter
 
 		// Rules for single software clients are stored individually.
@@ -164,6 +167,21 @@ func distributeRule(rule *groupedRule, inIntf, outIntf *routerIntf) {
 			}
 		}
 		addRule(inIntf, rule)
+	} else if inIntf.tunnel && inIntf.crypto.ipsec != nil {
+		// Traffic decrypted by IPsec is filtered in separate chains,
+		// that only match packets with IPsec policy.
+		hw := inIntf.hardware
+		if intfRules {
+			hw.ipsecIntfRules = append(hw.ipsecIntfRules, rule)
+		} else {
+			m := hw.ipsecIoRules
+			if m == nil {
+				m = make(map[string]ruleList)
+				hw.ipsecIoRules = m
+			}
+			n := outIntf.hardware.name
+			m[n] = append(m[n], rule)
+		}
 	} else if !intfRules && model.hasIoACL {
 		// Remember outgoing interface.
 		m := inIntf.hardware.ioRules
@@ -275,7 +293,9 @@ func (c *spoc) addRouterAcls() {
 
 						// Permit multicast packets from current network.
 						mcast := getMulticastObjects(routing.mcast, ipv6)
-						hardware.intfRules.push(newRule(netList, mcast, prtList))
+						if len(mcast) != 0 {
+							hardware.intfRules.push(newRule(netList, mcast, prtList))
+						}
 
 						// Additionally permit unicast packets.
 						// We use the network address as destination
@@ -283,9 +303,23 @@ func (c *spoc) addRouterAcls() {
 						// because we get fewer rules if the interface has
 						// multiple addresses.
 						hardware.intfRules.push(newRule(netList, netList, prtList))
+
+						// Permit packets from source port of protocol,
+						// e.g. answer from BGP peer.
+						if srcRange := routing.srcRange; srcRange != nil {
+							rule := newRule(netList, netList, []*proto{c.prt.TCP})
+							rule.srcRange = srcRange
+							hardware.intfRules.push(rule)
+						}
 					}
 				}
 
+				// Permit packets of bidirectional forwarding detection.
+				if intf.bfd {
+					netList := []someObj{intf.network}
+					hardware.intfRules.push(newRule(netList, netList, bfdPrtList))
+				}
+
 				// Handle multicast packets of redundancy protocols.
 				if typ := intf.redundancyType; typ != "" {
 					netList := []someObj{intf.network}
@@ -408,21 +442,69 @@ func (c *spoc) distributeGeneralPermit() {
 	}
 }
 
+// Distribute rules concurrently.
+// Paths of rules are walked in parts. Interface pairs found on path
+// are collected for each router in order of rules.
+// Afterwards rules are distributed to routers in parallel.
+// Order of rules at each router is identical to sequential processing.
+func (c *spoc) distributeRules(rules ruleList) {
+	if numParts(len(rules)) == 1 {
+		for _, rule := range rules {
+			c.pathWalk(rule, distributeRule, "Router")
+		}
+		return
+	}
+	type hop struct {
+		rule *groupedRule
+		in   *routerIntf
+		out  *routerIntf
+	}
+	parts := make([]map[*router][]hop, numParts(len(rules)))
+	c.inParallel(len(rules), func(c *spoc, i, from, to int) {
+		r2hops := make(map[*router][]hop)
+		collect := func(r *groupedRule, in, out *routerIntf) {
+			if in == nil {
+				return
+			}
+			r2hops[in.router] = append(r2hops[in.router], hop{r, in, out})
+		}
+		for _, rule := range rules[from:to] {
+			c.pathWalk(rule, collect, "Router")
+		}
+		parts[i] = r2hops
+	})
+	seen := make(map[*router]bool)
+	var routers []*router
+	for _, r2hops := range parts {
+		for r := range r2hops {
+			if !seen[r] {
+				seen[r] = true
+				routers = append(routers, r)
+			}
+		}
+	}
+	c.inParallel(len(routers), func(_ *spoc, _, from, to int) {
+		for _, r := range routers[from:to] {
+			for _, r2hops := range parts {
+				for _, h := range r2hops[r] {
+					distributeRule(h.rule, h.in, h.out)
+				}
+			}
+		}
+	})
+}
+
 func (c *spoc) rulesDistribution() {
 	c.progress("Distributing rules")
 
 	// Deny rules
-	for _, rule := range c.allPathRules.deny {
-		c.pathWalk(rule, distributeRule, "Router")
-	}
+	c.distributeRules(c.allPathRules.deny)
 
 	// Handle global permit after deny rules.
 	c.distributeGeneralPermit()
 
 	// Permit rules
-	for _, rule := range c.allPathRules.permit {
-		c.pathWalk(rule, distributeRule, "Router")
-	}
+	c.distributeRules(c.allPathRules.permit)
 
 	c.addRouterAcls()
 }
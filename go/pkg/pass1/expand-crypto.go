@@ -1,6 +1,10 @@
 package pass1
 
 import (
+	"github.com/hknutzen/Netspoc/go/pkg/fileop"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 )
@@ -162,7 +166,10 @@ func (c *spoc) verifyExtendedKeyUsage(s *subnet, r *router) {
 	}
 }
 
-func (c *spoc) verifyAsaTrustpoint(r *router, crypto *crypto) {
+func (c *spoc) verifyTrustpoint(r *router, crypto *crypto) {
+	if crypto.ipsec == nil {
+		return
+	}
 	isakmp := crypto.ipsec.isakmp
 	if isakmp.authentication == "rsasig" && isakmp.trustPoint == "" {
 		c.err("Missing attribute 'trust_point' in %s for %s",
@@ -171,9 +178,7 @@ func (c *spoc) verifyAsaTrustpoint(r *router, crypto *crypto) {
 }
 
 // Generate rules to permit crypto traffic between tunnel endpoints.
-func (c *spoc) genTunnelRules(intf1, intf2 *routerIntf, ipsec *ipsec) ruleList {
-	natTraversal := ipsec.isakmp.natTraversal
-
+func (c *spoc) genTunnelRules(intf1, intf2 *routerIntf, cr *crypto) ruleList {
 	var rules ruleList
 	template := groupedRule{
 		src:     []someObj{intf1},
@@ -181,6 +186,17 @@ func (c *spoc) genTunnelRules(intf1, intf2 *routerIntf, ipsec *ipsec) ruleList {
 		srcPath: intf1.getPathNode(),
 		dstPath: intf2.getPathNode(),
 	}
+
+	// WireGuard sends encrypted UDP packets to listen port of peer.
+	if wg := cr.wireguard; wg != nil {
+		rule := template
+		rule.serviceRule = new(serviceRule)
+		rule.prt = []*proto{wg.prt}
+		rules.push(&rule)
+		return rules
+	}
+	ipsec := cr.ipsec
+	natTraversal := ipsec.isakmp.natTraversal
 	if natTraversal == "" || natTraversal != "on" {
 		var prt []*proto
 		if ipsec.ah != "" {
@@ -225,8 +241,12 @@ func (c *spoc) expandCrypto() {
 		return sorted[i].name < sorted[j].name
 	})
 	for _, cr := range sorted {
-		isakmp := cr.ipsec.isakmp
-		needId := isakmp.authentication == "rsasig"
+		var isakmp *isakmp
+		needId := false
+		if cr.ipsec != nil {
+			isakmp = cr.ipsec.isakmp
+			needId = isakmp.authentication == "rsasig"
+		}
 
 		// Do consistency checks and
 		// add rules which allow encrypted traffic.
@@ -339,7 +359,11 @@ func (c *spoc) expandCrypto() {
 
 			doAuth := hubModel.doAuth
 			if id := spoke.id; id != "" {
-				if !needId {
+				if isakmp == nil {
+					c.err("Invalid attribute 'id' at %s.\n"+
+						" It isn't supported by %s",
+						spoke.name, cr.wireguard.name)
+				} else if !needId {
 					c.err("Invalid attribute 'id' at %s.\n"+
 						" Set authentication=rsasig at %s",
 						spoke.name, isakmp.name)
@@ -386,8 +410,8 @@ func (c *spoc) expandCrypto() {
 			}
 
 			if managed != "" {
-				if router.model.crypto == "ASA" {
-					c.verifyAsaTrustpoint(router, cr)
+				if t := router.model.crypto; t == "ASA" || t == "strongSwan" {
+					c.verifyTrustpoint(router, cr)
 				}
 				if cr.detailedCryptoAcl {
 					c.err("Attribute 'detailed_crypto_acl' is not"+
@@ -403,11 +427,14 @@ func (c *spoc) expandCrypto() {
 				realHub := hub.realIntf
 				gen := func(in, out *routerIntf) {
 					// Don't generate incoming ACL from unknown address.
-					if in.negotiated {
+					// But Linux hub drops all packets to device itself,
+					// that aren't permitted explicitly.
+					if in.negotiated && !(out.router.managed != "" &&
+						out.router.model.crypto == "strongSwan") {
 						return
 					}
 
-					rules := c.genTunnelRules(in, out, cr.ipsec)
+					rules := c.genTunnelRules(in, out, cr)
 					c.allPathRules.permit = append(c.allPathRules.permit, rules...)
 				}
 				gen(realSpoke, realHub)
@@ -465,12 +492,59 @@ func (c *spoc) expandCrypto() {
 			} else {
 				c.err("Missing 'trust-point' in radiusAttributes of %s", r.name)
 			}
-		} else if cryptoType == "ASA" {
+		} else if cryptoType == "ASA" || cryptoType == "strongSwan" {
+			seen := make(map[*crypto]bool)
 			for _, intf := range r.interfaces {
-				if crypto := intf.crypto; crypto != nil {
-					c.verifyAsaTrustpoint(r, crypto)
+				if crypto := intf.crypto; crypto != nil && !seen[crypto] {
+					seen[crypto] = true
+					c.verifyTrustpoint(r, crypto)
 				}
 			}
 		}
 	}
 }
+
+// File or directory "keys" at toplevel of policy isn't read as part
+// of topology, but is only used for public keys of WireGuard.
+func (c *spoc) checkKeysDir(inPath string) {
+	if inPath == "" || !fileop.IsDir(inPath) {
+		return
+	}
+	path := filepath.Join(inPath, "keys")
+	if _, err := os.Lstat(path); err != nil {
+		return
+	}
+	if fileop.IsDir(path) && len(symTable.wireguard) != 0 {
+		return
+	}
+	c.warn("Ignoring 'keys' at toplevel of policy;\n" +
+		" it is reserved for public keys of WireGuard")
+}
+
+// Read public keys of peers of WireGuard tunnels at managed routers.
+// Key of router:NAME is read from file "keys/KEY_DIR/NAME.pub" of
+// policy, where KEY_DIR is attribute 'key_dir' of wireguard definition.
+func (c *spoc) readWireguardKeys(inPath string) {
+	for _, r := range c.managedRouters {
+		for _, intf := range r.interfaces {
+			if !intf.tunnel || intf.crypto.wireguard == nil {
+				continue
+			}
+			wg := intf.crypto.wireguard
+			if wg.publicKey == nil {
+				wg.publicKey = make(map[string]string)
+			}
+			peer := intf.peer.router
+			if _, found := wg.publicKey[peer.name]; found {
+				continue
+			}
+			file := filepath.Join("keys", wg.keyDir,
+				peer.name[len("router:"):]+".pub")
+			data, err := ioutil.ReadFile(filepath.Join(inPath, file))
+			if err != nil {
+				c.err("Can't read public key of %s from %s", peer, file)
+			}
+			wg.publicKey[peer.name] = strings.TrimSpace(string(data))
+		}
+	}
+}
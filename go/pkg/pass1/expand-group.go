@@ -693,6 +693,12 @@ func (c *spoc) expandGroup1(
 
 func (c *spoc) checkV4V6CrossRef(obj ipVxGroupObj, ipv6 bool, ctx string) {
 	if ipv6 != obj.isIPv6() {
+		if ipv6 && c.allowNat64 {
+			switch obj.(type) {
+			case *network, *host:
+				return
+			}
+		}
 		expected := cond(ipv6, "6", "4")
 		found := cond(obj.isIPv6(), "6", "4")
 		c.err("Must not reference IPv%s %s in IPv%s context %s",
@@ -0,0 +1,288 @@
+package pass1
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hknutzen/Netspoc/go/pkg/fileop"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//###################################################################
+// Incremental export.
+// Only changed files are written to output directory.
+// Differences to previous export are written to file 'changes.json'.
+//###################################################################
+
+type exportFiles struct {
+	// Files written or found unchanged in current export.
+	seen map[string]bool
+	// Files written in current export.
+	changed stringList
+	// Previous and current content of files needed to
+	// find changed services and objects.
+	old map[string][]byte
+	new map[string][]byte
+	// Owners found in previous export.
+	oldOwners stringList
+}
+
+func (c *spoc) initIncrementalExport(outDir string) {
+	e := &exportFiles{
+		seen: make(map[string]bool),
+		old:  make(map[string][]byte),
+		new:  make(map[string][]byte),
+	}
+	if dir := filepath.Join(outDir, "owner"); fileop.IsDir(dir) {
+		e.oldOwners = fileop.Readdirnames(dir)
+	}
+	paths := stringList{"services", "objects"}
+	for _, owner := range e.oldOwners {
+		paths.push("owner/" + owner + "/service_lists")
+	}
+	for _, path := range paths {
+		if data, err := ioutil.ReadFile(filepath.Join(outDir, path)); err == nil {
+			e.old[path] = data
+		}
+	}
+	c.exportFiles = e
+}
+
+// Content of these files is needed to generate file 'changes.json'.
+func isChangesInput(path string) bool {
+	return path == "services" || path == "objects" ||
+		strings.HasPrefix(path, "owner/") &&
+			strings.HasSuffix(path, "/service_lists")
+}
+
+// Write file in incremental mode, but only if content has changed.
+func (c *spoc) writeExportFile(dir, path string, data []byte) {
+	file := dir + "/" + path
+	e := c.exportFiles
+	e.seen[path] = true
+	if isChangesInput(path) {
+		e.new[path] = data
+	}
+	if old, err := ioutil.ReadFile(file); err == nil {
+		if bytes.Equal(old, data) {
+			return
+		}
+	}
+	e.changed.push(path)
+	if err := ioutil.WriteFile(file, data, 0666); err != nil {
+		c.abort("Can't %v", err)
+	}
+}
+
+// Remove files of owners, that were written in previous export,
+// but are no longer current.
+func (c *spoc) removeStaleFiles(outDir string) {
+	e := c.exportFiles
+	for _, owner := range e.oldOwners {
+		dir := "owner/" + owner
+		for _, name := range fileop.Readdirnames(filepath.Join(outDir, dir)) {
+			path := dir + "/" + name
+			if e.seen[path] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(outDir, path)); err != nil {
+				c.abort("Can't %v", err)
+			}
+		}
+		if _, found := symTable.owner[owner]; !found {
+			if err := os.Remove(filepath.Join(outDir, dir)); err != nil {
+				c.abort("Can't %v", err)
+			}
+		}
+	}
+}
+
+// Parse previous or current content of file as map from name to
+// arbitrary JSON value.
+func (c *spoc) parseExportMap(data []byte) map[string]interface{} {
+	m := make(map[string]interface{})
+	if data != nil {
+		if err := json.Unmarshal(data, &m); err != nil {
+			c.abort("Can't parse previous export: %v", err)
+		}
+	}
+	return m
+}
+
+func sortedKeys(m map[string]bool) stringList {
+	l := make(stringList, 0, len(m))
+	for k := range m {
+		l.push(k)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// Compare previous and current content of file, that maps names to values.
+// Returns JSON with sorted lists of added, removed and modified names
+// and a map with changed names.
+func (c *spoc) diffExportMap(path string) (jsonMap, map[string]bool) {
+	e := c.exportFiles
+	old := c.parseExportMap(e.old[path])
+	cur := c.parseExportMap(e.new[path])
+	added := make(map[string]bool)
+	removed := make(map[string]bool)
+	modified := make(map[string]bool)
+	changed := make(map[string]bool)
+	for name, v := range cur {
+		if o, found := old[name]; !found {
+			added[name] = true
+			changed[name] = true
+		} else if !reflect.DeepEqual(o, v) {
+			modified[name] = true
+			changed[name] = true
+		}
+	}
+	for name := range old {
+		if _, found := cur[name]; !found {
+			removed[name] = true
+			changed[name] = true
+		}
+	}
+	result := jsonMap{
+		"added":    sortedKeys(added),
+		"removed":  sortedKeys(removed),
+		"modified": sortedKeys(modified),
+	}
+	return result, changed
+}
+
+// Get owner of object or list of owners of service
+// from previous or current export.
+func getExportedOwners(path string, v interface{}) stringList {
+	l := make(stringList, 0)
+	m, _ := v.(map[string]interface{})
+	switch path {
+	case "objects":
+		if o, ok := m["owner"].(string); ok {
+			l.push(o)
+		}
+	case "services":
+		d, _ := m["details"].(map[string]interface{})
+		owners, _ := d["owner"].([]interface{})
+		for _, o := range owners {
+			if s, ok := o.(string); ok {
+				l.push(s)
+			}
+		}
+	}
+	return l
+}
+
+func (c *spoc) exportChanges(outDir string) {
+	c.progress("Export changes")
+	e := c.exportFiles
+	result := make(jsonMap)
+	owner2changes := make(map[string]map[string]map[string]bool)
+	addOwnerChange := func(owner, typ, name string) {
+		t2n := owner2changes[owner]
+		if t2n == nil {
+			t2n = make(map[string]map[string]bool)
+			owner2changes[owner] = t2n
+		}
+		if t2n[typ] == nil {
+			t2n[typ] = make(map[string]bool)
+		}
+		t2n[typ][name] = true
+	}
+
+	// Find changed services and objects.
+	// Changed owner of modified service or object is shown separately.
+	assignments := make(jsonMap)
+	var svcChanged map[string]bool
+	for _, path := range []string{"services", "objects"} {
+		diff, changed := c.diffExportMap(path)
+		result[path] = diff
+		if path == "services" {
+			svcChanged = changed
+		}
+		old := c.parseExportMap(e.old[path])
+		cur := c.parseExportMap(e.new[path])
+		l := make([]jsonMap, 0)
+		for _, name := range sortedKeys(changed) {
+			oldOwners := getExportedOwners(path, old[name])
+			newOwners := getExportedOwners(path, cur[name])
+			if path == "objects" {
+				for _, o := range append(oldOwners, newOwners...) {
+					addOwnerChange(o, path, name)
+				}
+			}
+			// Added and removed objects are already shown above.
+			_, inOld := old[name]
+			_, inCur := cur[name]
+			if !inOld || !inCur || reflect.DeepEqual(oldOwners, newOwners) {
+				continue
+			}
+			l = append(l, jsonMap{"name": name, "old": oldOwners, "new": newOwners})
+		}
+		assignments[path] = l
+	}
+	result["owner_assignments"] = assignments
+
+	// Find added and removed owners.
+	oldOwners := make(map[string]bool)
+	for _, name := range e.oldOwners {
+		oldOwners[name] = true
+	}
+	added := make(map[string]bool)
+	removed := make(map[string]bool)
+	for name := range symTable.owner {
+		if !oldOwners[name] {
+			added[name] = true
+		}
+	}
+	for name := range oldOwners {
+		if _, found := symTable.owner[name]; !found {
+			removed[name] = true
+		}
+	}
+	result["owners"] = jsonMap{
+		"added":   sortedKeys(added),
+		"removed": sortedKeys(removed),
+	}
+
+	// Changed services are relevant for each owner that has this
+	// service in its previous or current service lists.
+	addSvcChanges := func(path string, data []byte) {
+		if data == nil || !strings.HasPrefix(path, "owner/") {
+			return
+		}
+		owner := strings.Split(path, "/")[1]
+		var type2snames map[string]stringList
+		if err := json.Unmarshal(data, &type2snames); err != nil {
+			c.abort("Can't parse previous export: %v", err)
+		}
+		for _, l := range type2snames {
+			for _, name := range l {
+				if svcChanged[name] {
+					addOwnerChange(owner, "services", name)
+				}
+			}
+		}
+	}
+	for path, data := range e.old {
+		addSvcChanges(path, data)
+	}
+	for path, data := range e.new {
+		addSvcChanges(path, data)
+	}
+	byOwner := make(map[string]map[string]stringList)
+	for owner, t2n := range owner2changes {
+		m := make(map[string]stringList)
+		for typ, names := range t2n {
+			m[typ] = sortedKeys(names)
+		}
+		byOwner[owner] = m
+	}
+	result["by_owner"] = byOwner
+	c.exportJson(outDir, "changes.json", result)
+}
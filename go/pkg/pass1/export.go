@@ -5,6 +5,21 @@ package pass1
 
     export-netspoc - Export data from Netspoc for use in Netspoc-Web
 
+=head1 OPTIONS
+
+=over 4
+
+=item B<-incremental>
+
+    Compare with previous export in out-directory and write only
+    changed files. Files of removed owners are deleted.
+    Added, removed and modified services, objects and owners
+    are written to file changes.json.
+    Attribute by_owner lists changed services and objects
+    for each affected owner.
+
+=back
+
 =head1 COPYRIGHT AND DISCLAIMER
 
     (c) 2020 by Heinz Knutzen <heinz.knutzengmail.com>
@@ -27,6 +42,7 @@ https://github.com/hknutzen/Netspoc-Web
 */
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
@@ -34,6 +50,7 @@ import (
 	"github.com/hknutzen/Netspoc/go/pkg/conf"
 	"github.com/hknutzen/Netspoc/go/pkg/fileop"
 	"github.com/spf13/pflag"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -52,15 +69,29 @@ func (c *spoc) createDirs(dir, path string) {
 }
 
 func (c *spoc) exportJson(dir, path string, data interface{}) {
+	newEncoder := func(w io.Writer) *json.Encoder {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", " ")
+		return enc
+	}
+
+	// In incremental mode, content must be compared with previous
+	// export before writing.
+	if c.exportFiles != nil {
+		var b bytes.Buffer
+		if err := newEncoder(&b).Encode(data); err != nil {
+			c.abort("%v", err)
+		}
+		c.writeExportFile(dir, path, b.Bytes())
+		return
+	}
 	path = dir + "/" + path
 	fd, err := os.Create(path)
 	if err != nil {
 		c.abort("Can't %v", err)
 	}
-	enc := json.NewEncoder(fd)
-	enc.SetEscapeHTML(false)
-	enc.SetIndent("", " ")
-	if err := enc.Encode(data); err != nil {
+	if err := newEncoder(fd).Encode(data); err != nil {
 		c.abort("%v", err)
 	}
 	if err := fd.Close(); err != nil {
@@ -432,6 +463,7 @@ func (c *spoc) normalizeServicesForExport() []*exportedSvc {
 		ipv6 := s.ipV6
 		sname := s.name
 		ctx := sname
+		c.allowNat64 = ipv6 && c.nat64Networks != nil
 		user := c.expandGroup(s.user, "user of "+ctx, ipv6, false)
 		foreach := s.foreach
 
@@ -598,6 +630,7 @@ func (c *spoc) normalizeServicesForExport() []*exportedSvc {
 			result = append(result, newService)
 		}
 	}
+	c.allowNat64 = false
 	return result
 }
 
@@ -1443,27 +1476,37 @@ func (c *spoc) copyPolicyFile(inPath, outDir string) {
 	// This allows import to RCS even for old versions of netspoc data.
 	policyFile := filepath.Join(inPath, "POLICY")
 	if fileop.IsRegular(policyFile) {
-		cmd := exec.Command(
-			"find", outDir, "-type", "f",
-			"-exec", "touch", "-r", policyFile, "{}", ";")
-		if out, err := cmd.CombinedOutput(); err != nil {
-			c.abort("executing \"%v\": %v\n%s", cmd, err, out)
+
+		// Only touch changed files in incremental mode.
+		if e := c.exportFiles; e != nil {
+			info, err := os.Stat(policyFile)
+			if err != nil {
+				c.abort("Can't %v", err)
+			}
+			t := info.ModTime()
+			for _, path := range e.changed {
+				if err := os.Chtimes(filepath.Join(outDir, path), t, t); err != nil {
+					c.abort("Can't %v", err)
+				}
+			}
+		} else {
+			cmd := exec.Command(
+				"find", outDir, "-type", "f",
+				"-exec", "touch", "-r", policyFile, "{}", ";")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				c.abort("executing \"%v\": %v\n%s", cmd, err, out)
+			}
 		}
 
-		cmd = exec.Command("cp", "-pf", policyFile, outDir)
+		cmd := exec.Command("cp", "-pf", policyFile, outDir)
 		if out, err := cmd.CombinedOutput(); err != nil {
 			c.abort("executing \"%v\": %v\n%s", cmd, err, out)
 		}
 	}
 }
 
-func (c *spoc) exportNetspoc(inDir, outDir string) {
-	c.readNetspoc(inDir)
-	c.markDisabled()
-	c.setZone()
-	c.setPath()
-	natDomains, natTag2natType, multiNAT := c.distributeNatInfo()
-	c.findSubnetsInZone()
+func (c *spoc) exportNetspoc(inDir, outDir string, incremental bool) {
+	natDomains, natTag2natType, multiNAT := c.readModel(inDir)
 	adaptOwnerNames()
 
 	// Copy of services with those services split, that have different 'user'.
@@ -1476,6 +1519,9 @@ func (c *spoc) exportNetspoc(inDir, outDir string) {
 
 	// Export data
 	c.createDirs(outDir, "")
+	if incremental {
+		c.initIncrementalExport(outDir)
+	}
 	c.exportOwners(outDir, eInfo)
 	c.exportMasterOwner(outDir, masterOwner)
 	c.exportAssets(outDir, pInfo, oInfo)
@@ -1484,6 +1530,10 @@ func (c *spoc) exportNetspoc(inDir, outDir string) {
 	c.exportObjects(outDir)
 	c.exportZone2Areas(outDir)
 	c.exportNatSet(outDir, multiNAT, natTag2natType, pInfo, oInfo)
+	if incremental {
+		c.exportChanges(outDir)
+		c.removeStaleFiles(outDir)
+	}
 	c.copyPolicyFile(inDir, outDir)
 	c.progress("Ready")
 }
@@ -1499,6 +1549,10 @@ func ExportMain() int {
 	// Command line flags
 	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
 	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	snapshot := pflag.String("snapshot", "",
+		"Read model from this file, if it matches input")
+	incremental := pflag.BoolP("incremental", "i", false,
+		"Write only changed files and add file changes.json")
 	pflag.Parse()
 
 	// Argument processing
@@ -1512,12 +1566,13 @@ func ExportMain() int {
 	dummyArgs := []string{
 		fmt.Sprintf("--verbose=%v", !*quiet),
 		fmt.Sprintf("--ipv6=%v", *ipv6),
+		"--snapshot=" + *snapshot,
 	}
 	conf.ConfigFromArgsAndFile(dummyArgs, path)
 
 	c := initSpoc()
 	go func() {
-		c.exportNetspoc(path, out)
+		c.exportNetspoc(path, out, *incremental)
 		close(c.msgChan)
 	}()
 	return c.printMessages()
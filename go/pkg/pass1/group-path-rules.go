@@ -270,8 +270,17 @@ func (c *spoc) groupPathRules(p, d ruleList) {
 
 	// Split grouped rules such, that all elements of src and dst
 	// have identical srcPath/dstPath.
+	// Rules are split concurrently in parts, that are joined in
+	// original order afterwards.
 	process := func(sRules ruleList) ruleList {
-		gRules := splitRulesByPath(sRules)
+		parts := make([]ruleList, numParts(len(sRules)))
+		c.inParallel(len(sRules), func(_ *spoc, i, from, to int) {
+			parts[i] = splitRulesByPath(sRules[from:to])
+		})
+		var gRules ruleList
+		for _, l := range parts {
+			gRules = append(gRules, l...)
+		}
 		gRules = removeUnenforceableRules(gRules)
 		return gRules
 	}
@@ -279,6 +288,9 @@ func (c *spoc) groupPathRules(p, d ruleList) {
 	c.allPathRules.deny = process(d)
 	count := len(c.allPathRules.permit) + len(c.allPathRules.deny)
 	c.info("Grouped rule count: %d", count)
+	if s := c.stats; s != nil {
+		s.Rules.Grouped = count
+	}
 
 	c.showUnenforceable()
 }
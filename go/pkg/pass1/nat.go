@@ -889,6 +889,14 @@ func (c *spoc) checkNatNetworkLocation(doms []*natDomain) {
 // Comment: A NAT definition for a single host/interface is only allowed,
 //          if network has a dynamic NAT definition.
 func (c *spoc) checkNatCompatibility() {
+	// Translated port of IP address must be used only once.
+	type portKey struct {
+		tag   string
+		ip    string
+		proto string
+		port  int
+	}
+	port2obj := make(map[portKey]string)
 	for _, n := range c.allNetworks {
 		check := func(obj netObj) {
 			nat := obj.nat
@@ -908,6 +916,15 @@ func (c *spoc) checkNatCompatibility() {
 						c.err("nat:%s: IP of %s doesn't match IP/mask of %s",
 							tag, obj, n)
 					}
+					for _, p := range obj.portNat[tag] {
+						k := portKey{tag, objIP.String(), p.proto, p.natPort}
+						if other := port2obj[k]; other != "" {
+							c.err("nat:%s: %s and %s use same translated port %s %d",
+								tag, other, obj, p.proto, p.natPort)
+						} else {
+							port2obj[k] = obj.String()
+						}
+					}
 				} else {
 					c.warn(
 						"Ignoring nat:%s at %s because %s has static NAT definition",
@@ -987,7 +1004,8 @@ func distributeNatSetsToInterfaces(doms []*natDomain) {
 
 				// debug("%s: NAT %s", d.name, intf)
 				intf.natSet = natSet
-				if (r.managed != "" || r.routingOnly) && !intf.tunnel {
+				if (r.managed != "" || r.routingOnly) &&
+					(!intf.tunnel || intf.crypto.wireguard != nil) {
 					intf.hardware.natSet = natSet
 				}
 			}
@@ -0,0 +1,168 @@
+package pass1
+
+import (
+	"net"
+)
+
+//###################################################################
+// NAT64 translates IPv6 clients to IPv4 servers.
+// IPv6 network with attribute 'nat64' holds IPv4 addresses embedded
+// into last 32 bits of its IPv6 prefix. Attribute 'nat64' references
+// IPv4 network, that IPv6 clients are translated to.
+// Both networks are connected to IPv4 and IPv6 definition of same
+// router, which is the translator.
+//###################################################################
+
+func (c *spoc) checkNat64Networks() {
+	for _, n := range c.nat64Networks {
+		if size, _ := n.mask.Size(); size != 96 {
+			c.err("%s with attribute 'nat64' must have prefix length 96", n)
+		}
+		pool := n.nat64
+		seen := make(map[string]bool)
+		for _, intf := range n.interfaces {
+			seen[intf.router.name] = true
+		}
+		found := false
+		for _, intf := range pool.interfaces {
+			if seen[intf.router.name] {
+				found = true
+			}
+		}
+		if !found {
+			c.err("%s and %s of 'nat64' must be connected to same router",
+				n, pool)
+		}
+	}
+}
+
+// Get IPv6 representation of IPv4 object in NAT64 network n.
+// IPv4 address is taken from NAT domain of IPv4 pool network.
+// Equal addresses result in identical objects.
+func (c *spoc) getNat64Obj(n *network, obj someObj,
+	cache map[*network]map[string]someObj) someObj {
+
+	pool := n.nat64
+	natSet := pool.zone.natDomain.natSet
+	addr := obj.address(natSet)
+	key := addr.String()
+	m := cache[n]
+	if m == nil {
+		m = make(map[string]someObj)
+		cache[n] = m
+	}
+	if result := m[key]; result != nil {
+		return result
+	}
+	var result someObj
+	size, _ := addr.Mask.Size()
+	if size == 0 {
+		result = n
+	} else {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, n.ip)
+		copy(ip[12:], addr.IP.To4())
+		s := new(subnet)
+		s.name = n.name + "[" + key + "]"
+		s.ip = ip
+		s.mask = net.CIDRMask(96+size, 128)
+		s.ipV6 = true
+		s.network = n
+		s.up = n
+		result = s
+	}
+	m[key] = result
+	return result
+}
+
+// IPv4 objects in dst of rule from IPv6 service are reached by NAT64.
+// For each NAT64 network, that is located in same partition of
+// topology as some objects of src, rule is split into an IPv6 rule
+// from these objects to IPv6 representation of IPv4 objects and
+// an IPv4 rule from pool network to IPv4 objects.
+func (c *spoc) splitNat64Rules(permit, deny ruleList) (ruleList, ruleList) {
+	if c.nat64Networks == nil {
+		return permit, deny
+	}
+	cache := make(map[*network]map[string]someObj)
+	process := func(rules ruleList) ruleList {
+		var result ruleList
+		for _, r := range rules {
+			if !r.rule.service.ipV6 {
+				result.push(r)
+				continue
+			}
+			var src []someObj
+			for _, obj := range r.src {
+				if obj.getNetwork().ipV6 {
+					src = append(src, obj)
+				} else {
+					c.err("IPv4 %s must not be used as source in rule\n %s",
+						obj, r.print())
+				}
+			}
+			var dst6, dst4 []someObj
+			for _, obj := range r.dst {
+				if obj.getNetwork().ipV6 {
+					dst6 = append(dst6, obj)
+				} else {
+					dst4 = append(dst4, obj)
+				}
+			}
+			if dst4 == nil {
+				if len(src) != len(r.src) {
+					r6 := *r
+					r6.src = src
+					r = &r6
+				}
+				result.push(r)
+				continue
+			}
+			if dst6 != nil {
+				r6 := *r
+				r6.src = src
+				r6.dst = dst6
+				result.push(&r6)
+			}
+			if src == nil {
+				continue
+			}
+			for _, n := range c.nat64Networks {
+				if n.disabled || n.nat64.disabled {
+					continue
+				}
+
+				// Translator must be reachable from src.
+				part := findZone1(n.zone)
+				var srcN []someObj
+				for _, obj := range src {
+					if findZone1(obj.getPathNode()) == part {
+						srcN = append(srcN, obj)
+					}
+				}
+				if srcN == nil {
+					continue
+				}
+				var proxies []someObj
+				seen := make(map[someObj]bool)
+				for _, obj := range dst4 {
+					p := c.getNat64Obj(n, obj, cache)
+					if !seen[p] {
+						seen[p] = true
+						proxies = append(proxies, p)
+					}
+				}
+				r6 := *r
+				r6.src = srcN
+				r6.dst = proxies
+				result.push(&r6)
+				r4 := *r
+				r4.src = []someObj{n.nat64}
+				r4.dst = dst4
+				result.push(&r4)
+			}
+		}
+		return result
+	}
+	return process(permit), process(deny)
+}
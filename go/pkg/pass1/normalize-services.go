@@ -204,6 +204,10 @@ func (c *spoc) normalizeSrcDstList(
 func (c *spoc) normalizeServiceRules(s *service, sRules *serviceRules) {
 	ipv6 := s.ipV6
 	ctx := s.name
+
+	// IPv4 objects are split off later into rules of IPv4 topology.
+	c.allowNat64 = ipv6 && c.nat64Networks != nil
+	defer func() { c.allowNat64 = false }()
 	user := c.expandGroup(s.user, "user of "+ctx, ipv6, false)
 	s.expandedUser = user
 	ruleCount := 0
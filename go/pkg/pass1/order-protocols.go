@@ -13,6 +13,7 @@ type stdProto struct {
 	IP      *proto
 	Ike     *proto
 	Natt    *proto
+	TCP     *proto
 	UDP     *proto
 	TCPEsta *proto
 }
@@ -62,6 +63,7 @@ func (c *spoc) initStdProtocols(sym *symbolTable) {
 	c.prt = prt
 	prt.IP = define("ip")
 	prtTCP := define("tcp")
+	prt.TCP = prtTCP
 	prt.UDP = define("udp")
 	prt.Ike = defineX("udp 500 : 500")
 	prt.Natt = defineX("udp 4500 : 4500")
@@ -0,0 +1,53 @@
+package pass1
+
+import (
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+)
+
+// Get number of parts, that n elements are split into
+// for concurrent processing.
+func numParts(n int) int {
+	k := conf.Conf.ConcurrencyPass1
+	if k > n {
+		k = n
+	}
+	if k < 1 {
+		k = 1
+	}
+	return k
+}
+
+// Process elements with index 0 .. n-1 concurrently.
+// Elements are split into numParts(n) parts of consecutive indexes.
+// Function f is called for each part with index of part and range
+// of elements from..to-1.
+// Each call gets its own copy of spoc with separate message channel.
+// Messages are shown in order of parts, hence output is identical
+// to sequential processing.
+// Function f must not call stopOnErr.
+func (c *spoc) inParallel(n int, f func(c *spoc, part, from, to int)) {
+	k := numParts(n)
+	if k == 1 {
+		f(c, 0, 0, n)
+		return
+	}
+	chans := make([]chan spocMsg, k)
+	for i := 0; i < k; i++ {
+		i := i
+		c2 := *c
+		// If buffer is full, job will wait until messages of
+		// preceding parts have been collected.
+		ch := make(chan spocMsg, 1000)
+		c2.msgChan = ch
+		chans[i] = ch
+		go func() {
+			defer close(ch)
+			f(&c2, i, i*n/k, (i+1)*n/k)
+		}()
+	}
+	for _, ch := range chans {
+		for m := range ch {
+			c.msgChan <- m
+		}
+	}
+}
@@ -2,6 +2,8 @@ package pass1
 
 import (
 	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"sync"
 )
 
 func findZone1(store pathStore) *zone {
@@ -1180,16 +1182,6 @@ func (c *spoc) pathWalk(rule *groupedRule,
 
 	atZone := where == "Zone"
 
-	// Extract path store objects (zone/router/pathrestricted interface).
-	// These are typically zone or router objects:
-	// - zone object for network or host,
-	// - router object for interface without pathrestriction.
-	// But for interface with pathrestriction, we may get different
-	// paths for interfaces of the same router.
-	// Hence we can't use the router but use interface object for
-	// interface with pathrestriction.
-	fromStore, toStore := rule.srcPath, rule.dstPath
-
 	/*	debug(rule.print());
 		debug(" start: %s, %s at %s",fromStore.String(), toStore.String(), where)
 		fun2 := fun
@@ -1206,18 +1198,70 @@ func (c *spoc) pathWalk(rule *groupedRule,
 		}
 	*/
 	// Identify path from source to destination if not known.
-	if _, found := fromStore.getPath1()[toStore]; !found {
-		if !pathMark(fromStore, toStore) {
-			// No need to show error message when finding static routes,
-			// because this will be shown again when distributing rules.
-			if !atZone {
-				c.showErrNoValidPath(fromStore, toStore, "for rule "+rule.print())
-			}
+	if !c.markRulePath(rule, atZone) {
+		return
+	}
+	if conf.Conf.ConcurrencyPass1 <= 1 {
+		walkPath(rule, fun, atZone)
+		return
+	}
 
-			// Abort, if path does not exist.
-			return
+	// Path marks may be added concurrently by other goroutine.
+	// Hence collect pairs of interfaces while holding read lock and
+	// call fun afterwards, because fun may itself walk some path.
+	var pairs intfPairs
+	collect := func(_ *groupedRule, in, out *routerIntf) {
+		pairs.push(intfPair{in, out})
+	}
+	pathLock.RLock()
+	walkPath(rule, collect, atZone)
+	pathLock.RUnlock()
+	for _, p := range pairs {
+		fun(rule, p[0], p[1])
+	}
+}
+
+// Protects path marks, that are added by pathMark,
+// when paths are walked concurrently.
+var pathLock sync.RWMutex
+
+// Mark path of rule if not already known.
+// Returns false and shows error, if no valid path exists.
+func (c *spoc) markRulePath(rule *groupedRule, atZone bool) bool {
+	fromStore, toStore := rule.srcPath, rule.dstPath
+	pathLock.RLock()
+	_, found := fromStore.getPath1()[toStore]
+	pathLock.RUnlock()
+	if found {
+		return true
+	}
+	pathLock.Lock()
+	_, found = fromStore.getPath1()[toStore]
+	ok := found || pathMark(fromStore, toStore)
+	pathLock.Unlock()
+	if !ok {
+		// No need to show error message when finding static routes,
+		// because this will be shown again when distributing rules.
+		if !atZone {
+			c.showErrNoValidPath(fromStore, toStore, "for rule "+rule.print())
 		}
 	}
+	return ok
+}
+
+// Walk already marked path of rule.
+func walkPath(rule *groupedRule,
+	fun func(r *groupedRule, i, o *routerIntf), atZone bool) {
+
+	// Extract path store objects (zone/router/pathrestricted interface).
+	// These are typically zone or router objects:
+	// - zone object for network or host,
+	// - router object for interface without pathrestriction.
+	// But for interface with pathrestriction, we may get different
+	// paths for interfaces of the same router.
+	// Hence we can't use the router but use interface object for
+	// interface with pathrestriction.
+	fromStore, toStore := rule.srcPath, rule.dstPath
 
 	// If path store is a pathrestricted interface, handle like router.
 	isRouter := false
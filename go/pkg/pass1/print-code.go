@@ -10,6 +10,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -70,7 +71,235 @@ func iosRouteCode(n *net.IPNet) string {
 	return ipCode + " " + maskCode
 }
 
-func printRoutes(fh *os.File, router *router) {
+type netInfo struct {
+	*net.IPNet
+	noOpt bool
+}
+
+// Combine networks with same interface and hop into smallest
+// covering prefixes. A covering prefix must not overlap with any
+// network reachable via other interface or hop. It must also not
+// overlap with any other network of NAT domain, as given by function
+// 'domainNets', that isn't contained in some network of same
+// interface and hop.
+// Networks marked as noOpt and networks of interfaces where
+// function 'keep' returns true are left unchanged.
+func summarizeRoutes(intf2hop2netInfos map[*routerIntf]map[*routerIntf][]netInfo,
+	bitstrLen int, keep func(*routerIntf) bool,
+	domainNets func(*routerIntf) []*net.IPNet) {
+
+	type hopKey struct {
+		intf *routerIntf
+		hop  *routerIntf
+	}
+	type route struct {
+		start net.IP
+		end   net.IP
+		key   hopKey
+	}
+	type prefixKey struct {
+		ip     string
+		prefix int
+	}
+	lastIP := func(n *net.IPNet) net.IP {
+		ip := make(net.IP, len(n.IP))
+		for i := range ip {
+			ip[i] = n.IP[i] | ^n.Mask[i]
+		}
+		return ip
+	}
+
+	// Collect all routes sorted by start address.
+	var all []route
+	prefix2key := make(map[prefixKey]hopKey)
+	for intf, hop2nets := range intf2hop2netInfos {
+		for hop, nets := range hop2nets {
+			key := hopKey{intf, hop}
+			for _, n := range nets {
+				all = append(all, route{n.IP, lastIP(n.IPNet), key})
+				prefix, _ := n.Mask.Size()
+				pk := prefixKey{string(n.IP), prefix}
+
+				// Network reached via multiple hops
+				// overlaps with network of each hop.
+				if k, found := prefix2key[pk]; found && k != key {
+					prefix2key[pk] = hopKey{}
+				} else {
+					prefix2key[pk] = key
+				}
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return bytes.Compare(all[i].start, all[j].start) == -1
+	})
+
+	// Networks of NAT domain sorted by address
+	// and indexed by address and prefix.
+	type domain struct {
+		nets   []*net.IPNet
+		prefix map[prefixKey]bool
+	}
+	natSet2domain := make(map[natSet]*domain)
+	getDomain := func(intf *routerIntf) *domain {
+		if d := natSet2domain[intf.natSet]; d != nil {
+			return d
+		}
+		d := &domain{prefix: make(map[prefixKey]bool)}
+		for _, n := range domainNets(intf) {
+			prefix, _ := n.Mask.Size()
+			d.prefix[prefixKey{string(n.IP), prefix}] = true
+			d.nets = append(d.nets, n)
+		}
+		sort.Slice(d.nets, func(i, j int) bool {
+			return bytes.Compare(d.nets[i].IP, d.nets[j].IP) == -1
+		})
+		natSet2domain[intf.natSet] = d
+		return d
+	}
+
+	// Check if network n is contained in some network
+	// that is reachable via hop of key.
+	isRouted := func(n *net.IPNet, key hopKey) bool {
+		prefix, _ := n.Mask.Size()
+		for p := 0; p <= prefix; p++ {
+			ip := n.IP.Mask(net.CIDRMask(p, bitstrLen))
+			if prefix2key[prefixKey{string(ip), p}] == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Check if network n overlaps with some network
+	// that is reachable via other hop or not routed at all.
+	overlaps := func(n *net.IPNet, key hopKey) bool {
+		prefix, _ := n.Mask.Size()
+
+		// Some network of other hop contains n.
+		for p := 0; p < prefix; p++ {
+			ip := n.IP.Mask(net.CIDRMask(p, bitstrLen))
+			if k, found := prefix2key[prefixKey{string(ip), p}]; found &&
+				k != key {
+				return true
+			}
+		}
+
+		// n contains some network of other hop.
+		end := lastIP(n)
+		i := sort.Search(len(all), func(i int) bool {
+			return bytes.Compare(all[i].start, n.IP) != -1
+		})
+		for ; i < len(all) && bytes.Compare(all[i].start, end) != 1; i++ {
+			if all[i].key != key {
+				return true
+			}
+		}
+
+		// Some other network of NAT domain contains n.
+		d := getDomain(key.intf)
+		for p := 0; p < prefix; p++ {
+			mask := net.CIDRMask(p, bitstrLen)
+			ip := n.IP.Mask(mask)
+			if d.prefix[prefixKey{string(ip), p}] &&
+				!isRouted(&net.IPNet{IP: ip, Mask: mask}, key) {
+				return true
+			}
+		}
+
+		// n contains some other network of NAT domain.
+		i = sort.Search(len(d.nets), func(i int) bool {
+			return bytes.Compare(d.nets[i].IP, n.IP) != -1
+		})
+		for ; i < len(d.nets) && bytes.Compare(d.nets[i].IP, end) != 1; i++ {
+			if !isRouted(d.nets[i], key) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Get smallest prefix containing both networks.
+	combine := func(a, b *net.IPNet) *net.IPNet {
+		pa, _ := a.Mask.Size()
+		pb, _ := b.Mask.Size()
+		p := pa
+		if pb < p {
+			p = pb
+		}
+		for ; p > 0; p-- {
+			mask := net.CIDRMask(p, bitstrLen)
+			if a.IP.Mask(mask).Equal(b.IP.Mask(mask)) {
+				break
+			}
+		}
+		mask := net.CIDRMask(p, bitstrLen)
+		return &net.IPNet{IP: a.IP.Mask(mask), Mask: mask}
+	}
+
+	for intf, hop2nets := range intf2hop2netInfos {
+		if keep(intf) {
+			continue
+		}
+		for hop, nets := range hop2nets {
+			key := hopKey{intf, hop}
+			var result, list []netInfo
+			for _, n := range nets {
+				if n.noOpt {
+					result = append(result, n)
+				} else {
+					list = append(list, n)
+				}
+			}
+			sort.Slice(list, func(i, j int) bool {
+				return bytes.Compare(list[i].IP, list[j].IP) == -1
+			})
+
+			// Combine adjacent networks of sorted list as long as
+			// combined network is valid. Networks contained in
+			// combined network are adjacent as well and get
+			// combined in next step.
+			// Never generate a default route.
+			var stack []netInfo
+			for _, n := range list {
+				for len(stack) != 0 {
+					top := stack[len(stack)-1]
+					c := combine(top.IPNet, n.IPNet)
+					if p, _ := c.Mask.Size(); p == 0 || overlaps(c, key) {
+						break
+					}
+					stack = stack[:len(stack)-1]
+					n = netInfo{c, false}
+				}
+				stack = append(stack, n)
+			}
+			result = append(result, stack...)
+
+			// Order from small to large networks as before.
+			sort.SliceStable(result, func(i, j int) bool {
+				pi, _ := result[i].Mask.Size()
+				pj, _ := result[j].Mask.Size()
+				if pi != pj {
+					return pi > pj
+				}
+				return bytes.Compare(result[i].IP, result[j].IP) == -1
+			})
+			hop2nets[hop] = result
+		}
+	}
+}
+
+// Get address of next hop in static route.
+// For unnumbered and negotiated interfaces use interface name
+// as next hop.
+func routeHopAddr(intf, hop *routerIntf) string {
+	if intf.unnumbered || intf.negotiated || intf.tunnel {
+		return intf.hardware.name
+	}
+	return hop.ip.String()
+}
+
+func printRoutes(fh *os.File, router *router, allNetworks netList) {
 	ipv6 := router.ipV6
 	model := router.model
 	vrf := router.vrf
@@ -85,6 +314,10 @@ func printRoutes(fh *os.File, router *router) {
 	}
 	net2hopInfo := make(map[*network]hopInfo)
 
+	// Networks reached via more than one hop,
+	// if router has attribute 'multi_hop_routes'.
+	net2multiHop := make(map[*network][]hopInfo)
+
 	for _, intf := range router.interfaces {
 
 		// Must not combine static routes to default route if any
@@ -125,6 +358,16 @@ func printRoutes(fh *os.File, router *router) {
 
 				// This is unambiguous, because only a single static
 				// route is allowed for each network.
+				// Only router with attribute 'multi_hop_routes'
+				// may reach network via multiple hops.
+				if info2, found := net2hopInfo[natNetwork]; found {
+					l := net2multiHop[natNetwork]
+					if l == nil {
+						l = []hopInfo{info2}
+					}
+					net2multiHop[natNetwork] = append(l, info)
+					continue
+				}
 				net2hopInfo[natNetwork] = info
 			}
 		}
@@ -157,6 +400,11 @@ func printRoutes(fh *os.File, router *router) {
 		for _, ip := range keys {
 			net := ip2net[string(ip)]
 
+			// Don't combine networks reached via multiple hops.
+			if net2multiHop[net] != nil {
+				continue
+			}
+
 			// Don't combine peers of ASA with site-to-site VPN.
 			if asaCrypto {
 				hopInfo := net2hopInfo[net]
@@ -261,10 +509,6 @@ func printRoutes(fh *os.File, router *router) {
 		prefixes = append(prefixes, k)
 	}
 	sort.Sort(sort.Reverse(sort.IntSlice(prefixes)))
-	type netInfo struct {
-		*net.IPNet
-		noOpt bool
-	}
 	intf2hop2netInfos := make(map[*routerIntf]map[*routerIntf][]netInfo)
 	for len(prefixes) != 0 {
 		prefix := prefixes[0]
@@ -283,6 +527,24 @@ func printRoutes(fh *os.File, router *router) {
 			hopInfo := net2hopInfo[small]
 			noOpt := false
 
+			// Add route for each hop of network reached via multiple hops.
+			// These routes must not be removed by default route later.
+			if l := net2multiHop[small]; l != nil {
+				for _, hopInfo := range l {
+					m := intf2hop2netInfos[hopInfo.intf]
+					if m == nil {
+						m = make(map[*routerIntf][]netInfo)
+						intf2hop2netInfos[hopInfo.intf] = m
+					}
+					info := netInfo{
+						&net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, bitstrLen)},
+						true,
+					}
+					m[hopInfo.hop] = append(m[hopInfo.hop], info)
+				}
+				continue
+			}
+
 			// ASA with site-to-site VPN needs individual routes for each peer.
 			if !(asaCrypto && hopInfo.intf.hub != nil) {
 
@@ -324,6 +586,31 @@ func printRoutes(fh *os.File, router *router) {
 		}
 	}
 
+	if conf.Conf.SummarizeRoutes {
+		summarizeRoutes(intf2hop2netInfos, bitstrLen, func(intf *routerIntf) bool {
+
+			// ASA with site-to-site VPN needs individual routes for each peer.
+			return asaCrypto && intf.hub != nil
+		}, func(intf *routerIntf) []*net.IPNet {
+
+			// All networks with NAT address as seen at interface,
+			// including directly connected networks.
+			var result []*net.IPNet
+			for _, n := range allNetworks {
+				if n.isAggregate || n.ipV6 != ipv6 {
+					continue
+				}
+				natNet := getNatNetwork(n, intf.natSet)
+				if natNet.hidden || natNet.unnumbered || natNet.tunnel {
+					continue
+				}
+				result = append(result,
+					&net.IPNet{IP: natNet.ip, Mask: natNet.mask})
+			}
+			return result
+		})
+	}
+
 	if doAutoDefaultRoute {
 
 		// Find interface and hop with largest number of routing entries.
@@ -375,6 +662,15 @@ func printRoutes(fh *os.File, router *router) {
 	}
 	nxosPrefix := ""
 
+	// Routes of iproute to same network with same metric are
+	// combined into one multipath route.
+	type ipRoute struct {
+		adr    string
+		metric string
+	}
+	var ipRoutes []ipRoute
+	ipRoute2hops := make(map[ipRoute][]string)
+
 	for _, intf := range router.interfaces {
 		hop2nets := intf2hop2netInfos[intf]
 		hops := make([]*routerIntf, 0, len(hop2nets))
@@ -385,14 +681,25 @@ func printRoutes(fh *os.File, router *router) {
 			return hops[i].name < hops[j].name
 		})
 		for _, hop := range hops {
+			hopAddr := routeHopAddr(intf, hop)
+			if model.routing == "iproute" {
+				// Interface name is given as device, not as gateway.
+				if intf.unnumbered || intf.negotiated || intf.tunnel {
+					hopAddr = "dev " + hopAddr
+				} else {
+					hopAddr = "via " + hopAddr
+				}
+			}
 
-			// For unnumbered and negotiated interfaces use interface name
-			// as next hop.
-			var hopAddr string
-			if intf.unnumbered || intf.negotiated || intf.tunnel {
-				hopAddr = intf.hardware.name
-			} else {
-				hopAddr = hop.ip.String()
+			// Administrative distance of floating static route.
+			metric := ""
+			if router.multiHopRoutes && hop.routePreference != 0 {
+				d := strconv.Itoa(hop.routePreference)
+				if model.routing == "iproute" {
+					metric = " metric " + d
+				} else {
+					hopAddr += " " + d
+				}
 			}
 
 			for _, netinfo := range hop2nets[hop] {
@@ -432,14 +739,29 @@ func printRoutes(fh *os.File, router *router) {
 					}
 					fmt.Fprintln(fh, ip+"route", intf.hardware.name, adr, hopAddr)
 				case "iproute":
-					adr := prefixCode(netinfo.IPNet)
-					fmt.Fprintln(fh, "ip route add", adr, "via", hopAddr)
+					r := ipRoute{prefixCode(netinfo.IPNet), metric}
+					if ipRoute2hops[r] == nil {
+						ipRoutes = append(ipRoutes, r)
+					}
+					ipRoute2hops[r] = append(ipRoute2hops[r], hopAddr)
 				case "none":
 					// Do nothing.
 				}
 			}
 		}
 	}
+	for _, r := range ipRoutes {
+		hops := ipRoute2hops[r]
+		if len(hops) == 1 {
+			fmt.Fprintln(fh, "ip route add", r.adr, hops[0]+r.metric)
+			continue
+		}
+		fmt.Fprint(fh, "ip route add ", r.adr, r.metric)
+		for _, hop := range hops {
+			fmt.Fprint(fh, " nexthop ", hop)
+		}
+		fmt.Fprintln(fh)
+	}
 }
 
 func printAclPlaceholder(fh *os.File, router *router, aclName string) {
@@ -1100,6 +1422,42 @@ func printIptablesAcls(fh *os.File, router *router) {
 		}
 		hardware.ioRules = nil
 
+		// Collect rules for traffic decrypted by IPsec.
+		// Chains only match packets with IPsec policy.
+		ipsecMatch := "-m policy --pol ipsec --dir in"
+		if rules := hardware.ipsecIntfRules; rules != nil {
+			aclName := intfAclName + "_ipsec"
+			info := &aclInfo{
+				name:    aclName,
+				rules:   rules,
+				addDeny: true,
+				natSet:  natSet,
+			}
+			router.aclList = append(router.aclList, info)
+			printAclPlaceholder(fh, router, aclName)
+			fmt.Fprintln(fh, "-A INPUT -j", aclName, "-i", inHw, ipsecMatch)
+		}
+		hardware.ipsecIntfRules = nil
+		keys = keys[:0]
+		for k, _ := range hardware.ipsecIoRules {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, outHw := range keys {
+			aclName := inHw + "_" + outHw + "_ipsec"
+			info := &aclInfo{
+				name:    aclName,
+				rules:   hardware.ipsecIoRules[outHw],
+				addDeny: true,
+				natSet:  natSet,
+			}
+			router.aclList = append(router.aclList, info)
+			printAclPlaceholder(fh, router, aclName)
+			fmt.Fprintln(fh, "-A FORWARD -j", aclName, "-i", inHw, "-o", outHw,
+				ipsecMatch)
+		}
+		hardware.ipsecIoRules = nil
+
 		// Empty line after each chain.
 		fmt.Fprintln(fh)
 	}
@@ -1374,14 +1732,10 @@ func (c *spoc) printEzvpn(fh *os.File, router *router) {
 	fmt.Fprintln(fh, prefix, cryptoFilterName, "in")
 }
 
-// Print crypto ACL.
-// It controls which traffic needs to be encrypted.
-func (c *spoc) printCryptoAcl(fh *os.File, intf *routerIntf, suffix string, crypto *crypto) string {
-	cryptoAclName := "crypto-" + suffix
-
-	// Generate crypto ACL entries.
-	// - either generic from remote network to any or
-	// - detailed to all networks which are used in rules.
+// Get local and remote networks of crypto tunnel at intf.
+// Networks at hub are either generic network 0/0 or,
+// with detailed_crypto_acl, all networks which are used in rules.
+func getCryptoNets(intf *routerIntf, crypto *crypto) ([]*network, []*network) {
 	isHub := intf.isHub
 	var hub *routerIntf
 	if isHub {
@@ -1400,6 +1754,15 @@ func (c *spoc) printCryptoAcl(fh *os.File, intf *routerIntf, suffix string, cryp
 	if !isHub {
 		local, remote = remote, local
 	}
+	return local, remote
+}
+
+// Print crypto ACL.
+// It controls which traffic needs to be encrypted.
+func (c *spoc) printCryptoAcl(fh *os.File, intf *routerIntf, suffix string, crypto *crypto) string {
+	cryptoAclName := "crypto-" + suffix
+	router := intf.router
+	local, remote := getCryptoNets(intf, crypto)
 	cryptoRules := c.genCryptoRules(local, remote)
 	aclInfo := &aclInfo{
 		name:        cryptoAclName,
@@ -1636,8 +1999,13 @@ func (c *spoc) printCrypto(fh *os.File, router *router) {
 	// List of ipsec definitions used at current router.
 	var ipsecList []*ipsec
 	seenIpsec := make(map[*ipsec]bool)
+	hasWireguard := false
 	for _, intf := range router.interfaces {
 		if intf.tunnel {
+			if intf.crypto.wireguard != nil {
+				hasWireguard = true
+				continue
+			}
 			i := intf.crypto.ipsec
 			if seenIpsec[i] {
 				continue
@@ -1648,7 +2016,7 @@ func (c *spoc) printCrypto(fh *os.File, router *router) {
 	}
 
 	// Return if no crypto is used at current router.
-	if ipsecList == nil {
+	if ipsecList == nil && !hasWireguard {
 		return
 	}
 
@@ -1672,6 +2040,13 @@ func (c *spoc) printCrypto(fh *os.File, router *router) {
 
 	printHeader(fh, router, "Crypto")
 
+	if hasWireguard {
+		printWireguard(fh, router)
+		if ipsecList == nil {
+			return
+		}
+	}
+
 	if cryptoType == "EZVPN" {
 		c.printEzvpn(fh, router)
 		return
@@ -1690,6 +2065,11 @@ func (c *spoc) printCrypto(fh *os.File, router *router) {
 		return
 	}
 
+	if cryptoType == "strongSwan" {
+		printSwanctl(fh, router)
+		return
+	}
+
 	// Crypto config for ASA as EZVPN client is configured manually once.
 	// No config is generated by netspoc.
 	if cryptoType == "ASA_EZVPN" {
@@ -1834,6 +2214,207 @@ func (c *spoc) printCrypto(fh *os.File, router *router) {
 	}
 }
 
+// Names of Diffie-Hellman groups in strongSwan proposals.
+var swanGroup = map[string]string{
+	"1":  "modp768",
+	"2":  "modp1024",
+	"5":  "modp1536",
+	"14": "modp2048",
+	"15": "modp3072",
+	"16": "modp4096",
+	"19": "ecp256",
+	"20": "ecp384",
+	"21": "ecp521",
+	"24": "modp2048s256",
+}
+
+func swanEncryption(e string) string {
+	switch e {
+	case "":
+		return "null"
+	case "aes":
+		return "aes128"
+	}
+	return e
+}
+
+func swanHash(h string) string {
+	if h == "sha" {
+		return "sha1"
+	}
+	return h
+}
+
+// Print crypto configuration of Linux device as snippet of swanctl.conf,
+// that is written by shell command.
+// Each tunnel interface becomes a connection with a single child SA.
+// Secrets and certificates are installed manually at device.
+func printSwanctl(fh *os.File, router *router) {
+	var tunnels []*routerIntf
+	for _, intf := range router.interfaces {
+		if intf.tunnel && intf.crypto.ipsec != nil {
+			tunnels = append(tunnels, intf)
+		}
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].peer.router.name < tunnels[j].peer.router.name
+	})
+	fmt.Fprintln(fh, "cat <<EOF > /etc/swanctl/conf.d/netspoc.conf")
+	fmt.Fprintln(fh, "connections {")
+	for _, intf := range tunnels {
+		peer := intf.peer
+		crypto := intf.crypto
+		ipsec := crypto.ipsec
+		isakmp := ipsec.isakmp
+
+		// Peer IP must obey NAT.
+		natSet := intf.hardware.natSet
+		connName := peer.router.name[len("router:"):]
+		fmt.Fprintln(fh, " "+connName+" {")
+		fmt.Fprintln(fh, "  version =", isakmp.ikeVersion)
+		dynamic := func(real *routerIntf) bool {
+			return real.negotiated || real.short || real.unnumbered
+		}
+		if real := intf.realIntf; !dynamic(real) {
+			fmt.Fprintln(fh, "  local_addrs =", prefixCode(real.address(natSet)))
+		}
+		remoteAddr := "%any"
+		if real := peer.realIntf; !dynamic(real) {
+			remoteAddr = prefixCode(real.address(natSet))
+		}
+		fmt.Fprintln(fh, "  remote_addrs =", remoteAddr)
+		fmt.Fprintln(fh, "  proposals =", swanEncryption(isakmp.encryption)+
+			"-"+swanHash(isakmp.hash)+"-"+swanGroup[isakmp.group])
+		fmt.Fprintf(fh, "  rekey_time = %ds\n", isakmp.lifetime)
+		if isakmp.natTraversal == "on" {
+			fmt.Fprintln(fh, "  encap = yes")
+		}
+		auth := "psk"
+		if isakmp.authentication == "rsasig" {
+			auth = "pubkey"
+		}
+		fmt.Fprintln(fh, "  local {")
+		fmt.Fprintln(fh, "   auth =", auth)
+		if auth == "pubkey" {
+			fmt.Fprintln(fh, "   certs =", isakmp.trustPoint+".pem")
+		}
+		if id := intf.realIntf.id; id != "" {
+			fmt.Fprintln(fh, "   id =", id)
+		}
+		fmt.Fprintln(fh, "  }")
+		fmt.Fprintln(fh, "  remote {")
+		fmt.Fprintln(fh, "   auth =", auth)
+		if id := peer.id; id != "" {
+			fmt.Fprintln(fh, "   id =", id)
+		}
+		fmt.Fprintln(fh, "  }")
+
+		// Traffic selectors must obey NAT of tunnel interface.
+		tsList := func(l []*network) string {
+			var result stringList
+			for _, n := range l {
+				result.push(prefixCode(n.address(intf.natSet)))
+			}
+			return strings.Join(result, ", ")
+		}
+		local, remote := getCryptoNets(intf, crypto)
+		pfs := ""
+		if g := ipsec.pfsGroup; g != "" {
+			pfs = "-" + swanGroup[g]
+		}
+		fmt.Fprintln(fh, "  children {")
+		fmt.Fprintln(fh, "   "+connName+" {")
+		fmt.Fprintln(fh, "    local_ts =", tsList(local))
+		fmt.Fprintln(fh, "    remote_ts =", tsList(remote))
+		if ah := ipsec.ah; ah != "" {
+			fmt.Fprintln(fh, "    ah_proposals =", swanHash(ah)+pfs)
+		}
+		if ipsec.espEncryption != "" || ipsec.espAuthentication != "" ||
+			ipsec.ah == "" {
+			esp := swanEncryption(ipsec.espEncryption)
+			if h := ipsec.espAuthentication; h != "" {
+				esp += "-" + swanHash(h)
+			}
+			fmt.Fprintln(fh, "    esp_proposals =", esp+pfs)
+		}
+		if sec := ipsec.lifetime[0]; sec != -1 {
+			fmt.Fprintf(fh, "    rekey_time = %ds\n", sec)
+		}
+		if kb := ipsec.lifetime[1]; kb != -1 {
+			fmt.Fprintf(fh, "    rekey_bytes = %d\n", kb*1024)
+		}
+
+		// Spoke installs trap policies and initiates tunnel on demand.
+		if !intf.isHub {
+			fmt.Fprintln(fh, "    start_action = trap")
+		}
+		fmt.Fprintln(fh, "   }")
+		fmt.Fprintln(fh, "  }")
+		fmt.Fprintln(fh, " }")
+	}
+	fmt.Fprintln(fh, "}")
+	fmt.Fprintln(fh, "EOF")
+}
+
+// Print configuration of each WireGuard device of Linux device.
+// Device is created, configuration file is written by shell command
+// and is loaded into device. Private key is installed manually at device.
+func printWireguard(fh *os.File, router *router) {
+	for _, hw := range router.hardware {
+		var tunnels []*routerIntf
+		for _, intf := range hw.interfaces {
+			if intf.tunnel && intf.crypto.wireguard != nil {
+				tunnels = append(tunnels, intf)
+			}
+		}
+		if tunnels == nil {
+			continue
+		}
+		sort.Slice(tunnels, func(i, j int) bool {
+			return tunnels[i].peer.router.name < tunnels[j].peer.router.name
+		})
+		wg := tunnels[0].crypto.wireguard
+		dev := hw.name
+		file := "/etc/wireguard/" + dev + ".conf"
+		fmt.Fprintln(fh, "ip link add", dev, "type wireguard")
+		fmt.Fprintln(fh, "cat <<EOF >", file)
+		fmt.Fprintln(fh, "[Interface]")
+		fmt.Fprintln(fh, "ListenPort =", wg.listenPort)
+		for _, intf := range tunnels {
+			peer := intf.peer
+			fmt.Fprintln(fh, "[Peer]")
+			fmt.Fprintln(fh, "PublicKey =", wg.publicKey[peer.router.name])
+
+			// Peer IP must obey NAT.
+			real := peer.realIntf
+			if !(real.negotiated || real.short || real.unnumbered) {
+				ip := real.address(intf.realIntf.natSet).IP.String()
+				if router.ipV6 {
+					ip = "[" + ip + "]"
+				}
+				fmt.Fprintf(fh, "Endpoint = %s:%d\n", ip, wg.listenPort)
+			}
+
+			// Allow networks behind peer with NAT of tunnel interface.
+			_, remote := getCryptoNets(intf, intf.crypto)
+			var allowed stringList
+			for _, n := range remote {
+				allowed.push(fullPrefixCode(n.address(intf.natSet)))
+			}
+			fmt.Fprintln(fh, "AllowedIPs =", strings.Join(allowed, ", "))
+
+			// Spoke keeps tunnel open, if located behind NAT device.
+			if wg.keepalive != 0 && !intf.isHub {
+				fmt.Fprintln(fh, "PersistentKeepalive =", wg.keepalive)
+			}
+		}
+		fmt.Fprintln(fh, "EOF")
+		fmt.Fprintln(fh, "wg setconf", dev, file)
+		fmt.Fprintln(fh, "wg set", dev, "private-key /etc/wireguard/private.key")
+		fmt.Fprintln(fh, "ip link set", dev, "up")
+	}
+}
+
 func printRouterIntf(fh *os.File, router *router) {
 	model := router.model
 	if !model.printRouterIntf {
@@ -1952,22 +2533,132 @@ func getNeedProtect(r *router) []*routerIntf {
 	return list
 }
 
+// Get protocol with translated port.
+func natPrt(prt *proto, port int) *proto {
+	return &proto{
+		name:  jcode.GenPortName(prt.proto, port, port),
+		proto: prt.proto,
+		ports: [2]int{port, port},
+	}
+}
+
+// Split rules, where some src or dst object has active port translation.
+// This object gets a separate rule with translated ports. Translated
+// port of dst object is used in prt, of src object in srcRange.
+func splitPortNatRules(rules []*groupedRule, srcNat, dstNat natSet,
+) []*groupedRule {
+
+	// Returns nil, if rule needs not to be split.
+	split := func(r *groupedRule, isSrc bool) []*groupedRule {
+		objList, ns := r.dst, dstNat
+		if isSrc {
+			objList, ns = r.src, srcNat
+		}
+		var plain []someObj
+		var result []*groupedRule
+		for _, obj := range objList {
+			ports := activePortNat(obj, ns)
+			if ports == nil {
+				plain = append(plain, obj)
+				continue
+			}
+			sr := *r.serviceRule
+			changed := false
+			if isSrc {
+				if sr.srcRange != nil {
+					if p := translatePort(ports, sr.srcRange); p != 0 {
+						sr.srcRange = natPrt(sr.srcRange, p)
+						changed = true
+					}
+				}
+			} else {
+				prtList := make([]*proto, len(sr.prt))
+				for i, prt := range sr.prt {
+					prtList[i] = prt
+					if p := translatePort(ports, prt); p != 0 {
+						prtList[i] = natPrt(prt, p)
+						changed = true
+					}
+				}
+				sr.prt = prtList
+			}
+			if !changed {
+				plain = append(plain, obj)
+				continue
+			}
+			rule := *r
+			rule.serviceRule = &sr
+			if isSrc {
+				rule.src = []someObj{obj}
+			} else {
+				rule.dst = []someObj{obj}
+			}
+			result = append(result, &rule)
+		}
+		if result == nil {
+			return nil
+		}
+		if plain != nil {
+			rule := *r
+			if isSrc {
+				rule.src = plain
+			} else {
+				rule.dst = plain
+			}
+			result = append([]*groupedRule{&rule}, result...)
+		}
+		return result
+	}
+	var result []*groupedRule
+	changed := false
+	for i, r := range rules {
+		l := split(r, true)
+		if l == nil {
+			l = []*groupedRule{r}
+		}
+		var l2 []*groupedRule
+		for _, r2 := range l {
+			if l3 := split(r2, false); l3 != nil {
+				l2 = append(l2, l3...)
+			} else {
+				l2 = append(l2, r2)
+			}
+		}
+		if len(l2) == 1 && l2[0] == r {
+			if changed {
+				result = append(result, r)
+			}
+			continue
+		}
+		if !changed {
+			changed = true
+			result = append(result, rules[:i]...)
+		}
+		result = append(result, l2...)
+	}
+	if !changed {
+		return rules
+	}
+	return result
+}
+
 type natCache struct {
 	nat   natSet
 	cache map[someObj]string
 }
 
-var nat2Cache = make(map[natSet]*natCache)
+// Each goroutine, that prints code, uses its own caches.
+type natCaches map[natSet]*natCache
 
-func getAddrCache(n natSet) *natCache {
-	if nc, ok := nat2Cache[n]; ok {
+func (m natCaches) getAddrCache(n natSet) *natCache {
+	if nc, ok := m[n]; ok {
 		return nc
 	}
 	nc := natCache{
 		nat:   n,
 		cache: make(map[someObj]string),
 	}
-	nat2Cache[n] = &nc
+	m[n] = &nc
 	return &nc
 }
 
@@ -1988,7 +2679,8 @@ func getCachedAddrList(l []someObj, nc *natCache) []string {
 	return result
 }
 
-func printAcls(fh *os.File, vrfMembers []*router) {
+// Returns number of printed rules.
+func printAcls(fh *os.File, vrfMembers []*router, caches natCaches) int {
 	var aclList []*jcode.ACLInfo
 	for _, router := range vrfMembers {
 		managed := router.managed
@@ -2024,12 +2716,12 @@ func printAcls(fh *os.File, vrfMembers []*router) {
 			// cache for address calculation.
 			noOptAddrs := make(map[someObj]*natCache)
 			natSet := acl.natSet
-			addrCache := getAddrCache(natSet)
+			addrCache := caches.getAddrCache(natSet)
 			dstNatSet := acl.dstNatSet
 			if dstNatSet == nil {
 				dstNatSet = natSet
 			}
-			dstAddrCache := getAddrCache(dstNatSet)
+			dstAddrCache := caches.getAddrCache(dstNatSet)
 
 			// Set attribute NeedProtect in jACL.
 			// Value is list of IP addresses of to be protected interfaces.
@@ -2055,6 +2747,7 @@ func printAcls(fh *os.File, vrfMembers []*router) {
 			}
 
 			optRules := func(rules []*groupedRule) []*jcode.Rule {
+				rules = splitPortNatRules(rules, natSet, dstNatSet)
 				jRules := make([]*jcode.Rule, len(rules))
 				for i, rule := range rules {
 					newRule := new(jcode.Rule)
@@ -2126,6 +2819,14 @@ func printAcls(fh *os.File, vrfMembers []*router) {
 								var subst *network
 								switch o := obj.(type) {
 								case *subnet, *routerIntf:
+
+									// Don't change protocol of translated
+									// port, because IP address is shared
+									// with other hosts.
+									if activePortNat(obj, useCache.nat) != nil {
+										noOptAddrs[obj] = useCache
+										continue
+									}
 									net := obj.getNetwork()
 									if net.hasOtherSubnet {
 										continue
@@ -2256,6 +2957,11 @@ func printAcls(fh *os.File, vrfMembers []*router) {
 	if err != nil {
 		panic(err)
 	}
+	count := 0
+	for _, acl := range aclList {
+		count += len(acl.IntfRules) + len(acl.Rules)
+	}
+	return count
 }
 
 // Make output directory available.
@@ -2272,7 +2978,14 @@ func (c *spoc) checkOutputDir(dir string) {
 		prev := dir + "/.prev"
 		if !fileop.IsDir(prev) {
 			oldFiles := fileop.Readdirnames(dir)
-			if count := len(oldFiles); count > 0 {
+			if len(oldFiles) > 0 {
+				// Don't count manifests and other hidden files.
+				count := 0
+				for _, name := range oldFiles {
+					if !strings.HasPrefix(name, ".") {
+						count++
+					}
+				}
 				if fileop.IsDir(dir + "/ipv6") {
 					v6files := fileop.Readdirnames(dir + "/ipv6")
 					count += len(v6files) - 1
@@ -2314,9 +3027,19 @@ func (c *spoc) printCode1(dir string) {
 		}
 	}
 
+	// Collect devices to be printed.
+	type device struct {
+		path       string
+		deviceName string
+		router     *router
+		vrfMembers []*router
+		hashes     jcode.Hashes
+		aclLines   int
+	}
+	var devices []*device
 	checkedV6Dir := false
 	seen := make(map[*router]bool)
-	printRouter := func(routers []*router) {
+	collect := func(routers []*router) {
 		for _, r := range routers {
 			if seen[r] {
 				continue
@@ -2341,15 +3064,6 @@ func (c *spoc) printCode1(dir string) {
 				}
 			}
 
-			// File for router config without ACLs.
-			configFile := dir + "/" + path + ".config"
-			fd, err := os.Create(configFile)
-			if err != nil {
-				c.abort("Can't %v", err)
-			}
-			model := r.model
-			commentChar := model.commentChar
-
 			// Restore interfaces of split router.
 			if orig := r.origIntfs; orig != nil {
 				r.interfaces = orig
@@ -2361,64 +3075,149 @@ func (c *spoc) printCode1(dir string) {
 			if vrfMembers == nil {
 				vrfMembers = []*router{r}
 			}
-
-			// Print version header.
-			fmt.Fprintln(fd, commentChar, "Generated by", program+", version", version)
-			fmt.Fprintln(fd)
-
-			header := func(key, val string) {
-				fmt.Fprintf(fd, "%s [ %s %s ]\n", commentChar, key, val)
-			}
-			header("BEGIN", deviceName)
-			header("Model =", model.class)
-			ips := make([]string, 0, len(vrfMembers))
-			for _, r := range vrfMembers {
-				if r.adminIP != nil {
-					ips = append(ips, r.adminIP...)
-				}
-			}
-			if len(ips) != 0 {
-				header("IP =", strings.Join(ips, ","))
-			}
-
 			for _, vrouter := range vrfMembers {
 				seen[vrouter] = true
-				printRoutes(fd, vrouter)
-				if vrouter.managed == "" {
-					continue
-				}
-				c.printCrypto(fd, vrouter)
-				printAclPrefix(fd, vrouter)
-				generateAcls(fd, vrouter)
-				printAclSuffix(fd, vrouter)
-				printRouterIntf(fd, vrouter)
 			}
+			devices = append(devices,
+				&device{path: path, deviceName: deviceName,
+					router: r, vrfMembers: vrfMembers})
+		}
+	}
+	collect(c.managedRouters)
+	collect(c.routingOnlyRouters)
+
+	printDevice := func(d *device, caches natCaches) {
+		path, deviceName, r, vrfMembers :=
+			d.path, d.deviceName, d.router, d.vrfMembers
+
+		// File for router config without ACLs.
+		configFile := dir + "/" + path + ".config"
+		fd, err := os.Create(configFile)
+		if err != nil {
+			c.abort("Can't %v", err)
+		}
+		model := r.model
+		commentChar := model.commentChar
 
-			header("END", deviceName)
-			fmt.Fprintln(fd)
-			if err := fd.Close(); err != nil {
-				c.abort("Can't %v", err)
+		// Print version header.
+		fmt.Fprintln(fd, commentChar, "Generated by", program+", version", version)
+		fmt.Fprintln(fd)
+
+		header := func(key, val string) {
+			fmt.Fprintf(fd, "%s [ %s %s ]\n", commentChar, key, val)
+		}
+		header("BEGIN", deviceName)
+		header("Model =", model.class)
+		ips := make([]string, 0, len(vrfMembers))
+		for _, r := range vrfMembers {
+			if r.adminIP != nil {
+				ips = append(ips, r.adminIP...)
 			}
+		}
+		if len(ips) != 0 {
+			header("IP =", strings.Join(ips, ","))
+		}
 
-			// Print ACLs in machine independent format into separate file.
-			// Collect ACLs from VRF parts.
-			aclFile := dir + "/" + path + ".rules"
-			aclFd, err := os.Create(aclFile)
-			if err != nil {
-				c.abort("Can't %v", err)
+		for _, vrouter := range vrfMembers {
+			printRoutes(fd, vrouter, c.allNetworks)
+			if vrouter.managed == "" {
+				continue
 			}
-			printAcls(aclFd, vrfMembers)
-			if err := aclFd.Close(); err != nil {
-				c.abort("Can't %v", err)
+			c.printCrypto(fd, vrouter)
+			printAclPrefix(fd, vrouter)
+			generateAcls(fd, vrouter)
+			printAclSuffix(fd, vrouter)
+			printRouterIntf(fd, vrouter)
+		}
+
+		header("END", deviceName)
+		fmt.Fprintln(fd)
+		if err := fd.Close(); err != nil {
+			c.abort("Can't %v", err)
+		}
+
+		// Print ACLs in machine independent format into separate file.
+		// Collect ACLs from VRF parts.
+		aclFile := dir + "/" + path + ".rules"
+		aclFd, err := os.Create(aclFile)
+		if err != nil {
+			c.abort("Can't %v", err)
+		}
+		d.aclLines = printAcls(aclFd, vrfMembers, caches)
+		if err := aclFd.Close(); err != nil {
+			c.abort("Can't %v", err)
+		}
+
+		// Record hashes of both files, used by pass 2 to find
+		// unchanged devices.
+		if d.hashes.Config, err = fileop.Hash(configFile); err != nil {
+			c.abort("Can't %v", err)
+		}
+		if d.hashes.Rules, err = fileop.Hash(aclFile); err != nil {
+			c.abort("Can't %v", err)
+		}
+	}
+
+	// Write hashes and collect statistics of all devices,
+	// after code has been printed.
+	finish := func() {
+		m := make(jcode.Manifest)
+		for _, d := range devices {
+			m[d.path] = &d.hashes
+			if s := c.stats; s != nil {
+				s.ACLLines[d.path] = d.aclLines
 			}
+		}
+		if err := m.Write(dir + "/" + jcode.ManifestFile); err != nil {
+			c.abort("Can't write manifest: %v", err)
+		}
+	}
+
+	// Send device name to pass 2, showing that processing for this
+	// device can be started.
+	n := conf.Conf.ConcurrencyPass1
+	if n <= 1 {
+		caches := make(natCaches)
+		for _, d := range devices {
+			printDevice(d, caches)
+			fmt.Fprintln(toPass2, d.path)
+		}
+		finish()
+		return
+	}
 
-			// Send device name to pass 2, showing that processing for this
-			// device can be started.
-			fmt.Fprintln(toPass2, path)
+	// Print devices concurrently.
+	// Device names are sent to pass 2 in original order.
+	done := make([]chan bool, len(devices))
+	for i := range done {
+		done[i] = make(chan bool)
+	}
+	next := make(chan int)
+	for j := 0; j < n; j++ {
+		go func() {
+			caches := make(natCaches)
+			for i := range next {
+				printDevice(devices[i], caches)
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range devices {
+			next <- i
+		}
+		close(next)
+	}()
+	for i, d := range devices {
+		select {
+		case <-done[i]:
+		case <-c.quit:
+			// Some worker was aborted in watch mode.
+			runtime.Goexit()
 		}
+		fmt.Fprintln(toPass2, d.path)
 	}
-	printRouter(c.managedRouters)
-	printRouter(c.routingOnlyRouters)
+	finish()
 }
 
 func (c *spoc) printCode(dir string) {
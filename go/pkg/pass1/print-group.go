@@ -47,6 +47,12 @@ Show owner of elements.
 
 Show admins of elements as comma separated list.
 
+=item B<-json>
+
+Show all attributes of elements in JSON format:
+name, ip, nat_ip, owner, admins, zone and enclosing areas.
+Options -name, -ip, -owner, -admins are ignored.
+
 =item B<-ipv6>
 
 Expect IPv6 definitions everywhere except in subdirectory "ipv4/".
@@ -88,6 +94,7 @@ with this program; if !, write to the Free Software Foundation, Inc.,
 */
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/hknutzen/Netspoc/go/pkg/abort"
 	"github.com/hknutzen/Netspoc/go/pkg/ast"
@@ -170,6 +177,57 @@ func printAddress(obj groupObj, ns natSet) string {
 	return ""
 }
 
+// Collect attributes of element for JSON output.
+// ip is the real address, natIP the address in NAT domain
+// given by option '-nat'.
+func objectInfo(obj fmt.Stringer, ip, natIP string) jsonMap {
+	m := jsonMap{"name": obj.String()}
+	if ip != "" {
+		m["ip"] = ip
+		m["nat_ip"] = natIP
+	}
+	var ow *owner
+	var z *zone
+	var a *area
+	switch x := obj.(type) {
+	case *network:
+		ow, z = x.owner, x.zone
+	case *subnet:
+		ow, z = x.owner, x.network.zone
+	case *host:
+		ow, z = x.owner, x.network.zone
+	case *routerIntf:
+		ow, z = x.owner, x.network.zone
+	case *area:
+		ow, a = x.owner, x.inArea
+	}
+	if ow != nil {
+		m["owner"] = ow.name
+		m["admins"] = ow.admins
+	}
+	if z != nil {
+		m["zone"] = getZoneName(z)
+		a = z.inArea
+	}
+	var areas stringList
+	for ; a != nil; a = a.inArea {
+		areas.push(a.name)
+	}
+	if areas != nil {
+		m["areas"] = areas
+	}
+	return m
+}
+
+func printJSON(data interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(data); err != nil {
+		abort.Msg("%v", err)
+	}
+}
+
 // Try to expand group as IPv4 or IPv6, but don't abort on error.
 func (c *spoc) tryExpand(parsed []ast.Element, ipv6 bool) groupObjList {
 	c2 := *c
@@ -203,19 +261,19 @@ func (c *spoc) tryExpand(parsed []ast.Element, ipv6 bool) groupObjList {
 }
 
 func (c *spoc) printGroup(path, group, natNet string,
-	showIP, showName, showOwner, showAdmins, showUnused bool) {
+	showIP, showName, showOwner, showAdmins, showUnused, showJSON bool) {
 
 	if !(showIP || showName) {
 		showIP = true
 		showName = true
 	}
 	parsed := parser.ParseUnion([]byte(group))
-	c.readNetspoc(path)
-	c.markDisabled()
-	c.setZone()
-	c.setPath()
-	c.distributeNatInfo()
-	c.findSubnetsInZone()
+	var sRules *serviceRules
+	if showUnused {
+		_, sRules = c.readModelRules(path)
+	} else {
+		c.readModel(path)
+	}
 	c.stopOnErr()
 
 	// Find network for resolving NAT addresses.
@@ -237,8 +295,6 @@ func (c *spoc) printGroup(path, group, natNet string,
 	// Prepare finding unused objects by marking used objects.
 	used := make(map[groupObj]bool)
 	if showUnused {
-		sRules := c.normalizeServices()
-		c.stopOnErr()
 		process := func(rules []*serviceRule) {
 			for _, rule := range rules {
 				processObjects := func(group []srvObj) {
@@ -268,7 +324,7 @@ func (c *spoc) printGroup(path, group, natNet string,
 		process(sRules.deny)
 	}
 
-	if showOwner || showAdmins {
+	if showOwner || showAdmins || showJSON {
 		c.propagateOwners()
 	}
 
@@ -290,6 +346,18 @@ func (c *spoc) printGroup(path, group, natNet string,
 		elements = elements[:j]
 	}
 
+	if showJSON {
+		// Empty NAT set to get real address.
+		var noNat map[string]bool
+		result := make([]jsonMap, 0, len(elements))
+		for _, ob := range elements {
+			result = append(result, objectInfo(
+				ob, printAddress(ob, &noNat), printAddress(ob, natSet)))
+		}
+		printJSON(result)
+		return
+	}
+
 	// Print IP address, name, owner, admins.
 	for _, ob := range elements {
 		var result stringList
@@ -335,6 +403,8 @@ func PrintGroupMain() int {
 	// Command line flags
 	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
 	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	snapshot := pflag.String("snapshot", "",
+		"Read model from this file, if it matches input")
 
 	nat := pflag.String("nat", "",
 		"Use network:name as reference when resolving IP address")
@@ -345,6 +415,8 @@ func PrintGroupMain() int {
 	owner := pflag.BoolP("owner", "o", false, "Show owner of elements")
 	admins := pflag.BoolP("admins", "a", false,
 		"Show admins of elements as comma separated list")
+	showJSON := pflag.Bool("json", false,
+		"Show all attributes of elements in JSON format")
 	pflag.Parse()
 
 	// Argument processing
@@ -358,11 +430,13 @@ func PrintGroupMain() int {
 	dummyArgs := []string{
 		fmt.Sprintf("--verbose=%v", !*quiet),
 		fmt.Sprintf("--ipv6=%v", *ipv6),
+		"--snapshot=" + *snapshot,
 	}
 	conf.ConfigFromArgsAndFile(dummyArgs, path)
 	c := initSpoc()
 	go func() {
-		c.printGroup(path, group, *nat, *ip, *name, *owner, *admins, *unused)
+		c.printGroup(
+			path, group, *nat, *ip, *name, *owner, *admins, *unused, *showJSON)
 		close(c.msgChan)
 	}()
 	return c.printMessages()
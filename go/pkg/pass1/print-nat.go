@@ -0,0 +1,271 @@
+package pass1
+
+/*
+=head1 NAME
+
+print-nat - Show NAT domains and translated addresses of objects
+
+=head1 SYNOPSIS
+
+print-nat [options] FILE|DIR ["group:name,..."]
+
+=head1 DESCRIPTION
+
+This program prints each NAT domain of the topology together with
+its border interfaces and NAT tags, that are active in this domain.
+Multi NAT definitions, i.e. NAT tags that are defined together at
+some network, are shown after the NAT domains.
+
+If some group or object is given, the address of each element is
+shown as seen from each NAT domain. Each line has NAT domain,
+address, type of translation and name of element separated by TAB
+character. Type of translation is one of real, static, dynamic or
+hidden.
+
+=head1 OPTIONS
+
+=over 4
+
+=item B<-json>
+
+Show NAT domains, multi NAT definitions and addresses of elements
+in JSON format.
+
+=item B<-ipv6>
+
+Expect IPv6 definitions everywhere except in subdirectory "ipv4/".
+
+=item B<-quiet>
+
+Don't print progress messages.
+
+=item B<-help>
+
+Prints a brief help message && exits.
+
+=item B<-man>
+
+Prints the manual page && exits.
+
+=back
+
+=head1 COPYRIGHT AND DISCLAIMER
+
+(c) 2020 by Heinz Knutzen <heinz.knutzengooglemail.com>
+
+This program uses modules of Netspoc, a Network Security Policy Compiler.
+http://hknutzen.github.com/Netspoc
+
+This program is free software; you can redistribute it &&/|| modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, ||
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY || FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along
+with this program; if !, write to the Free Software Foundation, Inc.,
+51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+*/
+
+import (
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/ast"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/parser"
+	"github.com/spf13/pflag"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Get type of translation of obj in context of natSet.
+func natTranslation(obj groupObj, ns natSet) string {
+	var n *network
+	var static map[string]net.IP
+	switch x := obj.(type) {
+	case *network:
+		n = x
+	case *host:
+		n, static = x.network, x.nat
+	case *routerIntf:
+		n, static = x.network, x.nat
+	default:
+		return ""
+	}
+	natNet := getNatNetwork(n, ns)
+	switch {
+	case natNet == n || natNet.identity:
+		return "real"
+	case natNet.hidden:
+		return "hidden"
+	case natNet.dynamic && static[natNet.natTag] == nil:
+		return "dynamic"
+	}
+	return "static"
+}
+
+// Get border interfaces of NAT domain.
+func natDomainInterfaces(d *natDomain) stringList {
+	result := make(stringList, 0)
+	for _, r := range d.routers {
+		for _, intf := range r.interfaces {
+			if intf.zone != nil && intf.zone.natDomain == d {
+				result.push(intf.name)
+			}
+		}
+	}
+	return result
+}
+
+// Get NAT tags, that are active in NAT domain.
+func natDomainTags(d *natDomain) stringList {
+	tags := make(stringList, 0)
+	for tag, active := range *d.natSet {
+		if active {
+			tags.push(tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Get different sets of NAT tags, that are defined together at
+// some network.
+func multiNatSets(multi map[string][]natMap) [][]string {
+	seen := make(map[string]bool)
+	result := make([][]string, 0)
+	for _, list := range multi {
+		for _, m := range list {
+			tags := make(stringList, 0, len(m))
+			for tag := range m {
+				tags.push(tag)
+			}
+			sort.Strings(tags)
+			key := strings.Join(tags, ",")
+			if !seen[key] {
+				seen[key] = true
+				result = append(result, tags)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Join(result[i], ",") < strings.Join(result[j], ",")
+	})
+	return result
+}
+
+func (c *spoc) printNat(path, group string, showJSON bool) {
+	var parsed []ast.Element
+	if group != "" {
+		parsed = parser.ParseUnion([]byte(group))
+	}
+	natDomains, _, multi := c.readModel(path)
+	c.stopOnErr()
+	var elements groupObjList
+	if parsed != nil {
+		conf.Conf.MaxErrors = 9999
+		elements = c.tryExpand(parsed, conf.Conf.IPV6)
+	}
+
+	if showJSON {
+		domains := make([]jsonMap, 0, len(natDomains))
+		for _, d := range natDomains {
+			domains = append(domains, jsonMap{
+				"name":       d.name,
+				"interfaces": natDomainInterfaces(d),
+				"nat":        natDomainTags(d),
+			})
+		}
+		result := jsonMap{
+			"nat_domains": domains,
+			"multi_nat":   multiNatSets(multi),
+		}
+		if parsed != nil {
+			objects := make([]jsonMap, 0, len(elements))
+			for _, ob := range elements {
+				addresses := make([]jsonMap, 0, len(natDomains))
+				for _, d := range natDomains {
+					addresses = append(addresses, jsonMap{
+						"nat_domain": d.name,
+						"ip":         printAddress(ob, d.natSet),
+						"type":       natTranslation(ob, d.natSet),
+					})
+				}
+				objects = append(objects,
+					jsonMap{"name": ob.String(), "addresses": addresses})
+			}
+			result["objects"] = objects
+		}
+		printJSON(result)
+		return
+	}
+
+	if parsed != nil {
+		for _, ob := range elements {
+			for _, d := range natDomains {
+				fmt.Println(strings.Join([]string{
+					d.name, printAddress(ob, d.natSet), natTranslation(ob, d.natSet),
+					ob.String()}, "\t"))
+			}
+		}
+		return
+	}
+	for _, d := range natDomains {
+		fmt.Println(d.name)
+		if l := natDomainInterfaces(d); len(l) != 0 {
+			fmt.Println(" interfaces: " + strings.Join(l, ", "))
+		}
+		if l := natDomainTags(d); len(l) != 0 {
+			fmt.Println(" nat: " + strings.Join(l, ", "))
+		}
+	}
+	for _, tags := range multiNatSets(multi) {
+		fmt.Println("multi_nat: " + strings.Join(tags, ", "))
+	}
+}
+
+func PrintNatMain() int {
+	// Setup custom usage function.
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: %s [options] FILE|DIR ['group:name,...']\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// Command line flags
+	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
+	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	snapshot := pflag.String("snapshot", "",
+		"Read model from this file, if it matches input")
+	showJSON := pflag.Bool("json", false, "Show result in JSON format")
+	pflag.Parse()
+
+	// Argument processing
+	args := pflag.Args()
+	if len(args) < 1 || len(args) > 2 {
+		pflag.Usage()
+		os.Exit(1)
+	}
+	path := args[0]
+	group := ""
+	if len(args) == 2 {
+		group = args[1]
+	}
+	dummyArgs := []string{
+		fmt.Sprintf("--verbose=%v", !*quiet),
+		fmt.Sprintf("--ipv6=%v", *ipv6),
+		"--snapshot=" + *snapshot,
+	}
+	conf.ConfigFromArgsAndFile(dummyArgs, path)
+	c := initSpoc()
+	go func() {
+		c.printNat(path, group, *showJSON)
+		close(c.msgChan)
+	}()
+	return c.printMessages()
+}
@@ -16,6 +16,9 @@ service definition.
 Output format is
 service-name:permit|deny src-ip dst-ip protocol-description
 
+Shown rules can be restricted by options -src, -dst, -prt and -owner.
+If multiple options are given, a rule must match all of them.
+
 =head1 OPTIONS
 
 =over 4
@@ -28,6 +31,44 @@ Uses network:name as reference when resolving IP address in a NAT environment.
 
 Show name, ! IP of elements.
 
+=item B<-src> object|address
+
+Show only rules, where source address contains or is contained
+in given address.
+Either an IP address or IP/prefixlen is given or
+a list of Netspoc objects, e.g. "host:h1, network:n2".
+Addresses of objects are resolved as given in option -nat.
+
+=item B<-dst> object|address
+
+Like option -src, but for destination of rules.
+
+=item B<-prt> protocol
+
+Show only rules, where protocol contains or is contained
+in given protocol.
+Protocol is given in Netspoc syntax, e.g. "tcp 80", "udp 1024 - 2048"
+or protocol:name. "tcp:80" is accepted as abbreviation for "tcp 80".
+
+=item B<-owner> name
+
+Show only rules, where source or destination has owner:name.
+
+=item B<-unenforceable>
+
+Show also rules, that aren't enforced by any managed router,
+i.e. rules, where source and destination are located in the same
+security zone. By default these rules are left out and a warning
+is shown.
+
+=item B<-json>
+
+Show rules in JSON format. For each service a list of rules is shown.
+Each rule has attributes action, src, dst and prt.
+Src and dst show the same attributes of an element
+as program print-group with option -json.
+Prt shows protocol, ports, ICMP type and code and modifiers.
+
 =item B<-ipv6>
 
 Expect IPv6 definitions everywhere except in subdirectory "ipv4/".
@@ -72,8 +113,11 @@ import (
 	"fmt"
 	"github.com/hknutzen/Netspoc/go/pkg/abort"
 	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/parser"
 	"github.com/spf13/pflag"
+	"net"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -117,15 +161,119 @@ func prtInfo(srcRange, prt *proto) string {
 	return desc
 }
 
-func (c *spoc) printService(
-	path string, srvNames []string, natNet string, showName bool) {
+// Get attributes of protocol for JSON output.
+func prtJSON(r *groupedRule, prt *proto) jsonMap {
+	m := jsonMap{"name": prtInfo(r.srcRange, prt), "protocol": prt.proto}
+	switch prt.proto {
+	case "tcp", "udp":
+		m["ports"] = prt.ports
+		if p := r.srcRange; p != nil {
+			m["src_ports"] = p.ports
+		}
+	case "icmp":
+		if t := prt.icmpType; t != -1 {
+			m["type"] = t
+			if c := prt.icmpCode; c != -1 {
+				m["code"] = c
+			}
+		}
+	}
+	var modifiers stringList
+	add := func(set bool, name string) {
+		if set {
+			modifiers.push(name)
+		}
+	}
+	add(r.reversed, "reversed")
+	add(r.stateless, "stateless")
+	add(r.oneway, "oneway")
+	add(r.srcNet, "src_net")
+	add(r.dstNet, "dst_net")
+	add(r.overlaps, "overlaps")
+	add(r.noCheckSupernetRules, "no_check_supernet_rules")
+	if modifiers != nil {
+		m["modifiers"] = modifiers
+	}
+	return m
+}
+
+// Filter for expanded rules given by command line options.
+type ruleFilter struct {
+	src           string
+	dst           string
+	prt           string
+	owner         string
+	unenforceable bool
+}
+
+// Get addresses of IP address, IP/prefixlen or list of Netspoc objects.
+func (c *spoc) getFilterAddr(spec, opt string, natSet natSet) []*net.IPNet {
+	if _, n, err := net.ParseCIDR(spec); err == nil {
+		return []*net.IPNet{n}
+	}
+	if ip := net.ParseIP(spec); ip != nil {
+		ipv6 := ip.To4() == nil
+		if !ipv6 {
+			ip = ip.To4()
+		}
+		return []*net.IPNet{{IP: ip, Mask: getHostMask(ipv6)}}
+	}
+	parsed := parser.ParseUnion([]byte(spec))
+	ctx := "option '--" + opt + "'"
+	var result []*net.IPNet
+	for _, obj := range c.expandGroupInRule(parsed, ctx, conf.Conf.IPV6) {
+		switch x := obj.(type) {
+		case *network:
+			result = append(result, x.address(natSet))
+		case *routerIntf:
+			result = append(result, x.address(natSet))
+		case *host:
+			for _, s := range x.subnets {
+				result = append(result, s.address(natSet))
+			}
+		}
+	}
+	return result
+}
+
+var portRangeRegex = regexp.MustCompile(`\b(\d+)\s*-\s*(\d+)\b`)
 
-	c.readNetspoc(path)
-	c.markDisabled()
-	c.setZone()
-	c.setPath()
-	c.distributeNatInfo()
-	c.findSubnetsInZone()
+// Get protocols given in Netspoc syntax.
+func (c *spoc) getFilterPrt(spec string) protoList {
+	// Accept "tcp:80" as abbreviation for "tcp 80".
+	if i := strings.Index(spec, ":"); i != -1 {
+		switch spec[:i] {
+		case "tcp", "udp", "icmp", "icmpv6", "proto":
+			spec = spec[:i] + " " + spec[i+1:]
+		}
+	}
+	// Split port range "80-90" into separate words "80 - 90".
+	spec = portRangeRegex.ReplaceAllString(spec, "$1 - $2")
+	return c.expandProtocols(
+		stringList{spec}, symTable, conf.Conf.IPV6, "option '--prt'")
+}
+
+// Check if one address contains the other one.
+func matchAddr(l []*net.IPNet, a *net.IPNet) bool {
+	for _, n := range l {
+		if n.Contains(a.IP) || a.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func getObjOwner(obj someObj) *owner {
+	if o, ok := obj.(ownerer); ok {
+		return o.getOwner()
+	}
+	return nil
+}
+
+func (c *spoc) printService(path string, srvNames []string, natNet string,
+	filter ruleFilter, showName, showJSON bool) {
+
+	_, sRules := c.readModelRules(path)
 	c.stopOnErr()
 
 	// Find network for resolving NAT addresses.
@@ -144,10 +292,56 @@ func (c *spoc) printService(
 		natSet = &m
 	}
 
-	sRules := c.normalizeServices()
+	if showJSON || filter.owner != "" {
+		c.propagateOwners()
+	}
 	permitRules, denyRules := c.convertHostsInRules(sRules)
-	c.groupPathRules(permitRules, denyRules)
+
+	if filter.unenforceable {
+		// Split rules such, that all elements of src and dst
+		// have identical srcPath/dstPath.
+		// Keep rules that are not enforced by any managed router.
+		c.allPathRules.permit = splitRulesByPath(permitRules)
+		c.allPathRules.deny = splitRulesByPath(denyRules)
+	} else {
+		c.groupPathRules(permitRules, denyRules)
+	}
+
+	var srcList, dstList []*net.IPNet
+	var prtList protoList
+	var filterOwner *owner
+	if filter.src != "" {
+		srcList = c.getFilterAddr(filter.src, "src", natSet)
+	}
+	if filter.dst != "" {
+		dstList = c.getFilterAddr(filter.dst, "dst", natSet)
+	}
+	if filter.prt != "" {
+		prtList = c.getFilterPrt(filter.prt)
+	}
+	if filter.owner != "" {
+		name := strings.TrimPrefix(filter.owner, "owner:")
+		if filterOwner = symTable.owner[name]; filterOwner == nil {
+			abort.Msg("Unknown owner:%s of option '--owner'", name)
+		}
+	}
 	c.stopOnErr()
+	match := func(src, dst someObj, prt *proto) bool {
+		if filter.src != "" && !matchAddr(srcList, src.address(natSet)) {
+			return false
+		}
+		if filter.dst != "" && !matchAddr(dstList, dst.address(natSet)) {
+			return false
+		}
+		if filter.prt != "" && !matchPrtList(prtList, []*proto{prt}) {
+			return false
+		}
+		if filterOwner != nil &&
+			getObjOwner(src) != filterOwner && getObjOwner(dst) != filterOwner {
+			return false
+		}
+		return true
+	}
 
 	nameMap := make(map[string]bool)
 	for _, name := range srvNames {
@@ -163,11 +357,10 @@ func (c *spoc) printService(
 
 	// Collect expanded rules.
 	type rule struct {
-		deny     bool
-		src      someObj
-		dst      someObj
-		srcRange *proto
-		prt      *proto
+		*groupedRule
+		src someObj
+		dst someObj
+		prt *proto
 	}
 	s2rules := make(map[string][]rule)
 	collect := func(rules ruleList) {
@@ -180,14 +373,12 @@ func (c *spoc) printService(
 			for _, src := range r.src {
 				for _, dst := range r.dst {
 					for _, prt := range r.prt {
+						if !match(src, dst, prt) {
+							continue
+						}
 						s2rules[sName] = append(
 							s2rules[sName],
-							rule{
-								deny:     r.deny,
-								src:      src,
-								dst:      dst,
-								srcRange: r.srcRange,
-								prt:      prt})
+							rule{groupedRule: r, src: src, dst: dst, prt: prt})
 					}
 				}
 			}
@@ -203,6 +394,33 @@ func (c *spoc) printService(
 		return prefixCode(obj.address(natSet))
 	}
 
+	if showJSON {
+		var noNat map[string]bool
+		info := func(obj someObj) jsonMap {
+			return objectInfo(obj,
+				prefixCode(obj.address(&noNat)), prefixCode(obj.address(natSet)))
+		}
+		result := make(map[string][]jsonMap)
+		for name, rules := range s2rules {
+			l := make([]jsonMap, len(rules))
+			for i, r := range rules {
+				action := "permit"
+				if r.deny {
+					action = "deny"
+				}
+				l[i] = jsonMap{
+					"action": action,
+					"src":    info(r.src),
+					"dst":    info(r.dst),
+					"prt":    prtJSON(r.groupedRule, r.prt),
+				}
+			}
+			result[name] = l
+		}
+		printJSON(result)
+		return
+	}
+
 	names := make(stringList, 0, len(s2rules))
 	for name, _ := range s2rules {
 		names.push(name)
@@ -234,10 +452,24 @@ func PrintServiceMain() int {
 	// Command line flags
 	quiet := pflag.BoolP("quiet", "q", false, "Don't print progress messages")
 	ipv6 := pflag.BoolP("ipv6", "6", false, "Expect IPv6 definitions")
+	snapshot := pflag.String("snapshot", "",
+		"Read model from this file, if it matches input")
 
 	nat := pflag.String("nat", "",
 		"Use network:name as reference when resolving IP address")
 	name := pflag.BoolP("name", "n", false, "Show name, not IP of elements")
+	var filter ruleFilter
+	pflag.StringVar(&filter.src, "src", "",
+		"Show only rules with matching source address or object")
+	pflag.StringVar(&filter.dst, "dst", "",
+		"Show only rules with matching destination address or object")
+	pflag.StringVar(&filter.prt, "prt", "",
+		"Show only rules with matching protocol")
+	pflag.StringVar(&filter.owner, "owner", "",
+		"Show only rules where source or destination has owner:name")
+	pflag.BoolVar(&filter.unenforceable, "unenforceable", false,
+		"Show also rules that aren't enforced by any managed router")
+	showJSON := pflag.Bool("json", false, "Show rules in JSON format")
 	pflag.Parse()
 
 	// Argument processing
@@ -252,11 +484,12 @@ func PrintServiceMain() int {
 	dummyArgs := []string{
 		fmt.Sprintf("--verbose=%v", !*quiet),
 		fmt.Sprintf("--ipv6=%v", *ipv6),
+		"--snapshot=" + *snapshot,
 	}
 	conf.ConfigFromArgsAndFile(dummyArgs, path)
 	c := initSpoc()
 	go func() {
-		c.printService(path, names, *nat, *name)
+		c.printService(path, names, *nat, filter, *name, *showJSON)
 		close(c.msgChan)
 	}()
 	return c.printMessages()
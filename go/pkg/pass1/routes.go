@@ -630,38 +630,54 @@ func (c *spoc) generateRoutingTree() routingTree {
 //              for the rules of original ruleset requiring a path passing the
 //              interface.
 func (c *spoc) generateRoutingInfo(tree routingTree) {
-
-	// Process every pseudo rule. Within its {path} attribute....
+	pRules := make([]*pseudoRule, 0, len(tree))
 	for _, pRule := range tree {
+		pRules = append(pRules, pRule)
+	}
 
-		// Collect data for every passed zone.
-		var path [][2]*routerIntf
-		var pathEntries []*routerIntf
-		var pathExits []*routerIntf
-		getRoutePath := func(r *groupedRule, inIntf, outIntf *routerIntf) {
-			/*			debug("collect: %s -> %s", r.srcPath.getName(), r.dstPath.getName())
-						info := ""
-						if inIntf != nil {
-							info += inIntf.name
-						}
-						info += " -> "
-						if outIntf != nil {
-							info += outIntf.name
-						}
-						debug(info)
-			*/
-			if inIntf != nil && outIntf != nil {
-				// Packets traverse the zone.
-				path = append(path, [2]*routerIntf{inIntf, outIntf})
-			} else if inIntf == nil {
-				// Zone contains rule source.
-				pathEntries = append(pathEntries, outIntf)
-			} else {
-				// Zone contains rule destination.
-				pathExits = append(pathExits, inIntf)
+	// Collect data for every passed zone.
+	// Paths of pseudo rules are walked concurrently in parts.
+	type zonePath struct {
+		path    [][2]*routerIntf
+		entries []*routerIntf
+		exits   []*routerIntf
+	}
+	paths := make([]zonePath, len(pRules))
+	c.inParallel(len(pRules), func(c *spoc, _, from, to int) {
+		for i := from; i < to; i++ {
+			p := &paths[i]
+			getRoutePath := func(r *groupedRule, inIntf, outIntf *routerIntf) {
+				/*			debug("collect: %s -> %s", r.srcPath.getName(), r.dstPath.getName())
+							info := ""
+							if inIntf != nil {
+								info += inIntf.name
+							}
+							info += " -> "
+							if outIntf != nil {
+								info += outIntf.name
+							}
+							debug(info)
+				*/
+				if inIntf != nil && outIntf != nil {
+					// Packets traverse the zone.
+					p.path = append(p.path, [2]*routerIntf{inIntf, outIntf})
+				} else if inIntf == nil {
+					// Zone contains rule source.
+					p.entries = append(p.entries, outIntf)
+				} else {
+					// Zone contains rule destination.
+					p.exits = append(p.exits, inIntf)
+				}
 			}
+			c.pathWalk(&pRules[i].groupedRule, getRoutePath, "Zone")
 		}
-		c.pathWalk(&pRule.groupedRule, getRoutePath, "Zone")
+	})
+
+	// Process every pseudo rule. Within its {path} attribute....
+	for i, pRule := range pRules {
+		path := paths[i].path
+		pathEntries := paths[i].entries
+		pathExits := paths[i].exits
 
 		// Determine routing information for every interface pair.
 		for _, tuple := range path {
@@ -840,7 +856,14 @@ func (c *spoc) checkAndConvertRoutes() {
 					continue
 				}
 				tunnelRoutes := intf.routes
-				intf.routes = nil
+
+				// Tunneled networks are reached via WireGuard device.
+				// Only route to peer is added to cleartext interface.
+				if intf.crypto.wireguard != nil {
+					tunnelRoutes = nil
+				} else {
+					intf.routes = nil
+				}
 				realNet := realIntf.network
 				peer := intf.peer
 				realPeer := peer.realIntf
@@ -1002,8 +1025,10 @@ func (c *spoc) checkAndConvertRoutes() {
 
 						// Check if network is reached via two different
 						// local interfaces.
+						// Router with attribute 'multi_hop_routes' gets
+						// static route for each hop.
 						if intf2, ok := net2intf[network]; ok {
-							if intf2 != intf {
+							if intf2 != intf && !router.multiHopRoutes {
 								errors.push(
 									fmt.Sprintf(
 										"Two static routes for %s\n via %s and %s",
@@ -1014,7 +1039,8 @@ func (c *spoc) checkAndConvertRoutes() {
 						}
 
 						// Check whether network is reached via different hops.
-						// Abort, if these do not belong to same redundancy group.
+						// Abort, if these do not belong to same redundancy group
+						// and multiple hops are not allowed.
 						group := hop.redundancyIntfs
 						if hop2, ok := net2hop[network]; ok {
 
@@ -1025,7 +1051,7 @@ func (c *spoc) checkAndConvertRoutes() {
 								delete(intf.routes[hop], network)
 								net2extraHops[network] =
 									append(net2extraHops[network], hop)
-							} else {
+							} else if !router.multiHopRoutes {
 								errors.push(
 									fmt.Sprintf(
 										"Two static routes for %s\n at %s via %s and %s",
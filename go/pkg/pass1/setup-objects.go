@@ -4,45 +4,65 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/hknutzen/Netspoc/go/pkg/ast"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
 	"github.com/hknutzen/Netspoc/go/pkg/filetree"
 	"github.com/hknutzen/Netspoc/go/pkg/jcode"
 	"github.com/hknutzen/Netspoc/go/pkg/parser"
 	"net"
+	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var symTable *symbolTable
 
-func (c *spoc) readNetspoc(path string) {
-	toplevel := parseFiles(path)
-	c.setupTopology(toplevel)
-}
-
 func (c *spoc) showReadStatistics() {
 	r := len(symTable.router) + len(symTable.router6)
 	n := len(symTable.network)
 	h := len(symTable.host)
 	s := len(symTable.service)
 	c.info("Read: %d routers, %d networks, %d hosts, %d services", r, n, h, s)
+	if st := c.stats; st != nil {
+		st.Read.Routers, st.Read.Networks, st.Read.Hosts, st.Read.Services =
+			r, n, h, s
+	}
 }
 
+// Files are parsed concurrently, but result is kept in order of files.
+// If syntax errors occur, only the error of the first file in this
+// order is shown, independent of timing of concurrent parsers.
 func parseFiles(path string) []ast.Toplevel {
-	var result []ast.Toplevel
+	var mutex sync.Mutex
+	fileNodes := make(map[int][]ast.Toplevel)
+	fileErrs := make(map[int]error)
 	process := func(input *filetree.Context) {
 		source := []byte(input.Data)
-		nodes := parser.ParseFile(source, input.Path)
+		nodes, err := parser.ParseFileErr(source, input.Path)
 		if input.IPV6 {
 			for _, n := range nodes {
 				n.SetIPV6()
 			}
 		}
-		result = append(result, nodes...)
+		mutex.Lock()
+		fileNodes[input.Index] = nodes
+		if err != nil {
+			fileErrs[input.Index] = err
+		}
+		mutex.Unlock()
+	}
+	count := filetree.WalkParallel(path, conf.Conf.ConcurrencyPass1, process)
+	var result []ast.Toplevel
+	for i := 0; i < count; i++ {
+		if err := fileErrs[i]; err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		result = append(result, fileNodes[i]...)
 	}
-	filetree.Walk(path, process)
 	return result
 }
 
@@ -55,6 +75,7 @@ func (c *spoc) setupTopology(toplevel []ast.Toplevel) {
 	c.stopOnErr()
 	c.linkTunnels(sym)
 	c.linkVirtualInterfaces()
+	c.checkNat64Networks()
 	c.splitSemiManagedRouter()
 }
 
@@ -88,7 +109,9 @@ type symbolTable struct {
 	pathrestriction map[string]*ast.TopList
 	// References isakmp
 	ipsec map[string]*ipsec
-	// References ipsec
+	// References protocol
+	wireguard map[string]*wireguard
+	// References ipsec, wireguard
 	crypto map[string]*crypto
 	// Log tags of routers
 	knownLog map[string]bool
@@ -112,6 +135,7 @@ func createSymbolTable() *symbolTable {
 	s.owner = make(map[string]*owner)
 	s.crypto = make(map[string]*crypto)
 	s.ipsec = make(map[string]*ipsec)
+	s.wireguard = make(map[string]*wireguard)
 	s.isakmp = make(map[string]*isakmp)
 	s.knownLog = make(map[string]bool)
 
@@ -163,6 +187,8 @@ func (c *spoc) setupObjects(l []ast.Toplevel, s *symbolTable) {
 				c.setupIsakmp(x, s)
 			case "ipsec":
 				ipsec = append(ipsec, x)
+			case "wireguard":
+				c.setupWireguard(x, s)
 			case "crypto":
 				crypto = append(crypto, x)
 			case "any":
@@ -633,6 +659,31 @@ func (c *spoc) setupIpsec(v *ast.TopStruct, s *symbolTable) {
 	}
 }
 
+func (c *spoc) setupWireguard(v *ast.TopStruct, s *symbolTable) {
+	name := v.Name
+	wg := new(wireguard)
+	wg.name = name
+	wgName := name[len("wireguard:"):]
+	s.wireguard[wgName] = wg
+	wg.listenPort = 51820
+	for _, a := range v.Attributes {
+		switch a.Name {
+		case "listen_port":
+			wg.listenPort = c.getPort(c.getSingleValue(a, name),
+				"'listen_port' of "+name)
+		case "keepalive":
+			wg.keepalive = c.getTimeVal(a, name)
+		case "key_dir":
+			wg.keyDir = c.getSingleValue(a, name)
+		default:
+			c.err("Unexpected attribute in %s: %s", name, a.Name)
+		}
+	}
+	c.checkDuplAttr(v.Attributes, name)
+	wg.prt = c.getSimpleProtocol("udp "+strconv.Itoa(wg.listenPort), s,
+		false, name)
+}
+
 func (c *spoc) setupCrypto(v *ast.TopStruct, s *symbolTable) {
 	name := v.Name
 	cr := new(crypto)
@@ -646,15 +697,24 @@ func (c *spoc) setupCrypto(v *ast.TopStruct, s *symbolTable) {
 		case "detailed_crypto_acl":
 			cr.detailedCryptoAcl = c.getFlag(a, name)
 		case "type":
-			cr.ipsec = c.getIpsecRef(a, s, name)
+			cr.ipsec, cr.wireguard = c.getCryptoTypeRef(a, s, name)
 		default:
 			c.err("Unexpected attribute in %s: %s", name, a.Name)
 		}
 	}
 	c.checkDuplAttr(v.Attributes, name)
-	if cr.ipsec == nil {
+	if cr.ipsec == nil && cr.wireguard == nil {
 		c.err("Missing 'type' for %s", name)
 	}
+
+	// Name of WireGuard device is derived from name of crypto
+	// definition. Name of network device is limited to 15 characters.
+	if cr.wireguard != nil {
+		if hw := "wg-" + crName; len(hw) > 15 {
+			c.err("Name of %s is too long for WireGuard device '%s',"+
+				" max. 15 characters are allowed", name, hw)
+		}
+	}
 }
 
 func (c *spoc) setupNetwork(v *ast.Network, s *symbolTable) {
@@ -695,6 +755,8 @@ func (c *spoc) setupNetwork(v *ast.Network, s *symbolTable) {
 			n.radiusAttributes = c.getRadiusAttributes(a, name)
 		case "partition":
 			n.partition = c.getIdentifier(a, name)
+		case "nat64":
+			n.nat64 = c.getNetworkRef(a, s, false, name)
 		case "overlaps", "unknown_owner", "multi_owner", "has_unenforceable":
 			n.attr = c.addAttr(a, n.attr, name)
 		default:
@@ -706,6 +768,13 @@ func (c *spoc) setupNetwork(v *ast.Network, s *symbolTable) {
 		}
 	}
 	c.checkDuplAttr(v.Attributes, name)
+	if n.nat64 != nil {
+		if !n.ipV6 {
+			c.err("Attribute 'nat64' must only be used at IPv6 %s", name)
+		} else {
+			c.nat64Networks.push(n)
+		}
+	}
 	for _, a := range v.Hosts {
 		h := c.setupHost(a, s, n)
 		if h.ldapId != "" {
@@ -875,9 +944,7 @@ func (c *spoc) setupHost(v *ast.Attribute, s *symbolTable, n *network) *host {
 		case "radius_attributes":
 			h.radiusAttributes = c.getRadiusAttributes(a, name)
 		default:
-			if nat := c.addIPNat(a, h.nat, v6, name); nat != nil {
-				h.nat = nat
-			} else {
+			if !c.addIPNat(a, &h.netObj, v6, name) {
 				c.err("Unexpected attribute in %s: %s", name, a.Name)
 			}
 		}
@@ -1081,6 +1148,7 @@ func (c *spoc) setupRouter(v *ast.Router, s *symbolTable) {
 	}
 	noProtectSelf := false
 	var routingDefault *routing
+	bfdDefault := false
 	for _, a := range v.Attributes {
 		switch a.Name {
 		case "managed":
@@ -1095,10 +1163,14 @@ func (c *spoc) setupRouter(v *ast.Router, s *symbolTable) {
 			noProtectSelf = c.getFlag(a, name)
 		case "log_deny":
 			r.logDeny = c.getFlag(a, name)
+		case "multi_hop_routes":
+			r.multiHopRoutes = c.getFlag(a, name)
 		case "acl_use_real_ip":
 			r.aclUseRealIp = c.getFlag(a, name)
 		case "routing":
 			routingDefault = c.getRouting(a, name)
+		case "bfd":
+			bfdDefault = c.getFlag(a, name)
 		case "owner":
 			r.owner = c.getRealOwnerRef(a, s, name)
 		case "radius_attributes":
@@ -1197,12 +1269,26 @@ func (c *spoc) setupRouter(v *ast.Router, s *symbolTable) {
 			}
 			if rt := intf.routing; rt != nil && intf.unnumbered {
 				switch rt.name {
-				case "manual", "dynamic":
+				case "manual", "dynamic", "IS-IS":
 				default:
 					c.err("Routing '%s' not supported for unnumbered %s",
 						rt.name, intf.name)
 				}
 			}
+
+			// Inherit attribute 'bfd' to interfaces with dynamic routing.
+			if bfdDefault && intf.routing != nil && !intf.unnumbered {
+				intf.bfd = true
+			}
+			if intf.bfd {
+				if rt := intf.routing; rt == nil || rt.name == "manual" {
+					c.err("Attribute 'bfd' needs dynamic routing at %s",
+						intf.name)
+				} else if intf.unnumbered {
+					c.err("Attribute 'bfd' not supported for unnumbered %s",
+						intf.name)
+				}
+			}
 		}
 	}
 
@@ -1286,7 +1372,17 @@ func (c *spoc) setupRouter(v *ast.Router, s *symbolTable) {
 		for _, intf := range r.interfaces {
 			if intf.hub != nil || intf.spoke != nil {
 				hasCrypto = true
-				if r.model.crypto == "" {
+				wg := false
+				for _, cr := range intf.hub {
+					wg = wg || cr.wireguard != nil
+				}
+				if cr := intf.spoke; cr != nil && cr.wireguard != nil {
+					wg = true
+				}
+				if wg && !r.model.canWireguard {
+					c.err("WireGuard not supported for %s of model %s",
+						name, r.model.name)
+				} else if !wg && r.model.crypto == "" {
 					c.err("Crypto not supported for %s of model %s",
 						name, r.model.name)
 				}
@@ -1377,11 +1473,15 @@ func (c *spoc) setupRouter(v *ast.Router, s *symbolTable) {
 			tIntf.network = tNet
 			tIntf.realIntf = intf
 			tIntf.routing = intf.routing
+			tIntf.bfd = intf.bfd
 			tIntf.bindNat = intf.bindNat
 			tIntf.id = intf.id
 			tIntf.ipV6 = v6
 			if r.managed != "" {
 				hw := intf.hardware
+				if cr.wireguard != nil {
+					hw = getWireguardHw(r, cr)
+				}
 				tIntf.hardware = hw
 				hw.interfaces.push(tIntf)
 			}
@@ -1473,8 +1573,13 @@ func (c *spoc) setupInterface(v *ast.Attribute, s *symbolTable,
 			intf.bindNat = c.getBindNat(a, name)
 		case "routing":
 			intf.routing = c.getRouting(a, name)
+		case "bfd":
+			intf.bfd = c.getFlag(a, name)
 		case "reroute_permit":
 			intf.reroutePermit = c.tryNetworkRefList(a, s, v6, name)
+		case "route_preference":
+			intf.routePreference = c.getNum256(c.getSingleValue(a, name),
+				"'route_preference' of "+name)
 		case "disabled":
 			intf.disabled = c.getFlag(a, name)
 		case "no_check":
@@ -1630,6 +1735,9 @@ func (c *spoc) setupInterface(v *ast.Attribute, s *symbolTable,
 		if intf.routing != nil {
 			c.err("Attribute 'routing' not supported for %s %s", typ, name)
 		}
+		if intf.bfd {
+			c.err("Attribute 'bfd' not supported for %s %s", typ, name)
+		}
 		if intf.reroutePermit != nil {
 			c.err("Attribute 'reroute_permit' not supported for %s %s", typ, name)
 		}
@@ -1853,6 +1961,7 @@ func (c *spoc) setupInterface(v *ast.Attribute, s *symbolTable,
 					c.err("Only 'ip' allowed in nat:%s of %s", tag, intf)
 				} else {
 					intf.nat[tag] = info.ip
+					c.addPortNat(&intf.netObj, tag, info.portNat, info.descr)
 				}
 			}
 		}
@@ -2376,10 +2485,14 @@ var routerInfo = map[string]*model{
 		needACL:          true,
 	},
 	"Linux": {
-		routing:     "iproute",
-		filter:      "iptables",
-		hasIoACL:    true,
-		commentChar: "#",
+		routing:        "iproute",
+		filter:         "iptables",
+		hasIoACL:       true,
+		canDynCrypto:   true,
+		canWireguard:   true,
+		crypto:         "strongSwan",
+		noCryptoFilter: true,
+		commentChar:    "#",
 	},
 }
 
@@ -2461,6 +2574,17 @@ var routingInfo = map[string]*routing{
 		mcast: mcastInfo{v4: []string{"224.0.0.9"},
 			v6: []string{"ff02::9"}},
 	},
+	"BGP": &routing{
+		name: "BGP",
+		prt:  &proto{proto: "tcp", ports: [2]int{179, 179}, name: "tcp 179"},
+
+		// Permit answer to connection, that was initiated by this router.
+		srcRange: &proto{proto: "tcp", ports: [2]int{179, 179}, name: "tcp 179"},
+	},
+
+	// IS-IS doesn't use IP packets, hence no rules are needed.
+	"IS-IS": &routing{name: "IS-IS"},
+
 	"dynamic": &routing{name: "dynamic"},
 
 	// Identical to 'dynamic', but must only be applied to router, not
@@ -2468,6 +2592,13 @@ var routingInfo = map[string]*routing{
 	"manual": &routing{name: "manual"},
 }
 
+// Protocols of bidirectional forwarding detection.
+// Control packets and echo packets.
+var bfdPrtList = []*proto{
+	&proto{proto: "udp", ports: [2]int{3784, 3784}, name: "udp 3784"},
+	&proto{proto: "udp", ports: [2]int{3785, 3785}, name: "udp 3785"},
+}
+
 func (c *spoc) getRouting(a *ast.Attribute, ctx string) *routing {
 	v := c.getSingleValue(a, ctx)
 	r := routingInfo[v]
@@ -2795,17 +2926,27 @@ func (c *spoc) getIsakmpRef(a *ast.Attribute, s *symbolTable, ctx string) *isakm
 	return is
 }
 
-func (c *spoc) getIpsecRef(a *ast.Attribute, s *symbolTable, ctx string) *ipsec {
+// Type of crypto references either ipsec or wireguard definition.
+func (c *spoc) getCryptoTypeRef(
+	a *ast.Attribute, s *symbolTable, ctx string) (*ipsec, *wireguard) {
+
 	typ, name := c.getTypedName(a, ctx)
-	if typ != "ipsec" {
-		c.err("Must only use ipsec type in '%s' of %s", a.Name, ctx)
-		return nil
-	}
-	is := s.ipsec[name]
-	if is == nil {
-		c.err("Can't resolve reference to ipsec:%s in %s", name, ctx)
+	switch typ {
+	case "ipsec":
+		is := s.ipsec[name]
+		if is == nil {
+			c.err("Can't resolve reference to ipsec:%s in %s", name, ctx)
+		}
+		return is, nil
+	case "wireguard":
+		wg := s.wireguard[name]
+		if wg == nil {
+			c.err("Can't resolve reference to wireguard:%s in %s", name, ctx)
+		}
+		return nil, wg
 	}
-	return is
+	c.err("Must only use ipsec or wireguard type in '%s' of %s", a.Name, ctx)
+	return nil, nil
 }
 
 func (c *spoc) getCryptoRef(a *ast.Attribute, s *symbolTable, ctx string) *crypto {
@@ -3060,7 +3201,7 @@ func (c *spoc) addAttr(
 func (c *spoc) addNetNat(a *ast.Attribute, m map[string]*network, v6 bool,
 	s *symbolTable, ctx string) map[string]*network {
 
-	return c.addXNat(a, m, v6, s, ctx, c.getIpPrefix)
+	return c.addXNat(a, m, v6, s, ctx, c.getIpPrefix, false)
 }
 func (c *spoc) addIntfNat(a *ast.Attribute, m map[string]*network, v6 bool,
 	s *symbolTable, ctx string) map[string]*network {
@@ -3069,12 +3210,13 @@ func (c *spoc) addIntfNat(a *ast.Attribute, m map[string]*network, v6 bool,
 		func(a *ast.Attribute, v6 bool, ctx string) (net.IP, net.IPMask) {
 			ip := c.getSingleValue(a, ctx)
 			return c.convIP(ip, v6, a.Name, ctx), getHostMask(v6)
-		})
+		}, true)
 }
 
 func (c *spoc) addXNat(
 	a *ast.Attribute, m map[string]*network, v6 bool, s *symbolTable, ctx string,
 	getIpX func(*ast.Attribute, bool, string) (net.IP, net.IPMask),
+	withPorts bool,
 ) map[string]*network {
 
 	if !strings.HasPrefix(a.Name, "nat:") {
@@ -3096,6 +3238,12 @@ func (c *spoc) addXNat(
 			nat.dynamic = c.getFlag(a2, natCtx)
 		case "subnet_of":
 			nat.subnetOf = c.tryNetworkRef(a2, s, v6, natCtx)
+		case "tcp", "udp":
+			if withPorts {
+				nat.portNat = append(nat.portNat, c.getPortNat(a2, natCtx)...)
+			} else {
+				c.err("Unexpected attribute in %s: %s", natCtx, a2.Name)
+			}
 		default:
 			c.err("Unexpected attribute in %s: %s", natCtx, a2.Name)
 		}
@@ -3139,29 +3287,90 @@ func (c *spoc) addXNat(
 	return m
 }
 
-func (c *spoc) addIPNat(a *ast.Attribute, m map[string]net.IP, v6 bool,
-	ctx string) map[string]net.IP {
+// Add static NAT of host with optional port translation.
+// Returns false, if attribute isn't a NAT definition.
+func (c *spoc) addIPNat(a *ast.Attribute, obj *netObj, v6 bool,
+	ctx string) bool {
 
 	if !strings.HasPrefix(a.Name, "nat:") {
-		return nil
+		return false
 	}
 	_, name := c.splitCheckTypedName(a.Name)
 	var ip net.IP
+	var ports []*portNat
 	natCtx := a.Name + " of " + ctx
 	l := c.getComplexValue(a, ctx)
 	for _, a2 := range l {
 		switch a2.Name {
 		case "ip":
 			ip = c.getIp(a2, v6, natCtx)
+		case "tcp", "udp":
+			ports = append(ports, c.getPortNat(a2, natCtx)...)
 		default:
 			c.err("Unexpected attribute in %s: %s", natCtx, a2.Name)
 		}
 	}
-	if m == nil {
-		m = make(map[string]net.IP)
+	if obj.nat == nil {
+		obj.nat = make(map[string]net.IP)
 	}
-	m[name] = ip
-	return m
+	obj.nat[name] = ip
+	c.addPortNat(obj, name, ports, natCtx)
+	return true
+}
+
+// Get port translations from attribute like "tcp = 443 -> 8443, 80 -> 8080".
+func (c *spoc) getPortNat(a *ast.Attribute, ctx string) []*portNat {
+	var result []*portNat
+	for _, v := range c.getValueList(a, ctx) {
+		l := strings.Split(v, " -> ")
+		var nums [2]int
+		ok := len(l) == 2
+		if ok {
+			for i, p := range l {
+				n, err := strconv.Atoi(p)
+				if err != nil || n < 1 || n > 65535 {
+					ok = false
+					break
+				}
+				nums[i] = n
+			}
+		}
+		if !ok {
+			c.err("Expected 'PORT -> PORT' in '%s' of %s", a.Name, ctx)
+			continue
+		}
+		result = append(result,
+			&portNat{proto: a.Name, natPort: nums[0], realPort: nums[1]})
+	}
+	return result
+}
+
+// Add port translations of NAT tag to host or interface.
+// Each translated and each real port must be used only once.
+func (c *spoc) addPortNat(obj *netObj, tag string, l []*portNat, ctx string) {
+	if len(l) == 0 {
+		return
+	}
+	type key struct {
+		proto string
+		port  int
+		real  bool
+	}
+	seen := make(map[key]bool)
+	for _, p := range l {
+		for _, k := range []key{
+			{p.proto, p.natPort, false}, {p.proto, p.realPort, true},
+		} {
+			if seen[k] {
+				c.err("Duplicate port %s %d in %s", k.proto, k.port, ctx)
+			}
+			seen[k] = true
+		}
+	}
+	if obj.portNat == nil {
+		obj.portNat = make(map[string][]*portNat)
+	}
+	obj.portNat[tag] = l
 }
 
 func (c *spoc) checkInterfaceIp(intf *routerIntf, n *network) {
@@ -3356,6 +3565,26 @@ func (c *spoc) moveLockedIntf(intf *routerIntf) {
 	}
 }
 
+// WireGuard tunnels don't use hardware of real interface,
+// but a separate device, that is shared by all tunnels of crypto
+// definition at router.
+func getWireguardHw(r *router, cr *crypto) *hardware {
+	name := "wg-" + cr.name[len("crypto:"):]
+	for _, hw := range r.hardware {
+		if hw.name == name {
+			return hw
+		}
+	}
+	hw := &hardware{name: name}
+	r.hardware = append(r.hardware, hw)
+
+	// We need hardware also be available in origHardware.
+	if r.origHardware != nil {
+		r.origHardware = append(r.origHardware, hw)
+	}
+	return hw
+}
+
 // Link tunnel networks with tunnel hubs.
 func (c *spoc) linkTunnels(s *symbolTable) {
 	// ToDo: Check if sorting is only needed for deterministic error messages.
@@ -3378,9 +3607,6 @@ func (c *spoc) linkTunnels(s *symbolTable) {
 			c.warn("No spokes have been defined for %s", cr.name)
 		}
 
-		isakmp := cr.ipsec.isakmp
-		needId := isakmp.authentication == "rsasig"
-
 		// Note: Crypto router is split internally into two nodes.
 		// Typically we get get a node with only a single crypto interface.
 		// Take original router with cleartext interface(s).
@@ -3393,8 +3619,10 @@ func (c *spoc) linkTunnels(s *symbolTable) {
 
 		// Router of type 'doAuth' can only check certificates,
 		// not pre-shared keys.
-		if model.doAuth && !needId {
-			c.err("%s needs authentication=rsasig in %s", r, isakmp.name)
+		if is := cr.ipsec; is != nil && model.doAuth {
+			if isakmp := is.isakmp; isakmp.authentication != "rsasig" {
+				c.err("%s needs authentication=rsasig in %s", r, isakmp.name)
+			}
 		}
 
 		if model.crypto == "EZVPN" {
@@ -3408,6 +3636,9 @@ func (c *spoc) linkTunnels(s *symbolTable) {
 			realSpoke := spoke.realIntf
 
 			hw := realHub.hardware
+			if cr.wireguard != nil {
+				hw = getWireguardHw(r, cr)
+			}
 			hub := new(routerIntf)
 			hub.name = "interface:" + rName + "." + netName
 			hub.tunnel = true
@@ -3424,6 +3655,7 @@ func (c *spoc) linkTunnels(s *symbolTable) {
 				hub.bindNat = realHub.bindNat
 			}
 			hub.routing = realHub.routing
+			hub.bfd = realHub.bfd
 			hub.peer = spoke
 			spoke.peer = hub
 			r.interfaces.push(hub)
@@ -0,0 +1,439 @@
+package pass1
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+//###################################################################
+// Binary encoding of graph of objects.
+// Values are encoded by reflection, including unexported fields of
+// structs. Identity of pointers, maps and slices is preserved.
+// Fields of type func and chan are left out and decoded as nil.
+//###################################################################
+
+// Identity of referenced value.
+type refKey struct {
+	addr uintptr
+	len  int
+	typ  reflect.Type
+}
+
+type graphCodec struct {
+	// Concrete types, that may be stored in interfaces.
+	name2type map[string]reflect.Type
+	// Values, that are known in advance and aren't encoded.
+	known []reflect.Value
+}
+
+func newGraphCodec(samples []interface{}, known []interface{}) *graphCodec {
+	g := &graphCodec{name2type: make(map[string]reflect.Type)}
+	for _, t := range reachableTypes(samples) {
+		g.name2type[t.String()] = t
+	}
+	for _, k := range known {
+		g.known = append(g.known, reflect.ValueOf(k))
+	}
+	return g
+}
+
+// Collect all types, that are reachable from types of samples.
+func reachableTypes(samples []interface{}) []reflect.Type {
+	seen := make(map[reflect.Type]bool)
+	var result []reflect.Type
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		result = append(result, t)
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			walk(t.Elem())
+		case reflect.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case reflect.Struct:
+			for i := 0; i < t.NumField(); i++ {
+				walk(t.Field(i).Type)
+			}
+		}
+	}
+	for _, s := range samples {
+		walk(reflect.TypeOf(s))
+	}
+	return result
+}
+
+// Fingerprint changes, if structure of some reachable type changes.
+func (g *graphCodec) fingerprint() string {
+	var l []string
+	for name, t := range g.name2type {
+		desc := name
+		if t.Kind() == reflect.Struct {
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				desc += " " + f.Name + ":" + f.Type.String()
+			}
+		}
+		l = append(l, desc)
+	}
+	sort.Strings(l)
+	return strings.Join(l, "\n")
+}
+
+// Get unnamed type of value of kind Ptr, Map or Slice.
+// Named types like natSet and *map[string]bool share same values.
+func unnamedType(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return reflect.PtrTo(t.Elem())
+	case reflect.Slice:
+		return reflect.SliceOf(t.Elem())
+	default:
+		return reflect.MapOf(t.Key(), t.Elem())
+	}
+}
+
+// Get identity of value of kind Ptr, Map or Slice.
+func getRefKey(v reflect.Value) refKey {
+	k := refKey{addr: v.Pointer(), typ: unnamedType(v.Type())}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
+// Make unexported field of addressable struct accessible.
+func accessible(v reflect.Value) reflect.Value {
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+}
+
+// Get addressable copy of value.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
+
+// Errors are raised by panic while encoding or decoding.
+type codecError struct{ err error }
+
+//###################################################################
+// Encoder
+//###################################################################
+
+type graphEncoder struct {
+	g     *graphCodec
+	w     *bufio.Writer
+	refs  map[refKey]int
+	types map[reflect.Type]int
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (g *graphCodec) encode(w io.Writer, root interface{}) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			e, ok := x.(codecError)
+			if !ok {
+				panic(x)
+			}
+			err = e.err
+		}
+	}()
+	e := &graphEncoder{
+		g:     g,
+		w:     bufio.NewWriter(w),
+		refs:  make(map[refKey]int),
+		types: make(map[reflect.Type]int),
+	}
+	for _, v := range g.known {
+		e.refs[getRefKey(v)] = len(e.refs)
+	}
+	v := reflect.ValueOf(root)
+	if v.Kind() != reflect.Ptr {
+		return errors.New("root of graph must be pointer")
+	}
+	e.value(v)
+	return e.w.Flush()
+}
+
+func (e *graphEncoder) uint(x uint64) {
+	n := binary.PutUvarint(e.buf[:], x)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *graphEncoder) int(x int64) {
+	n := binary.PutVarint(e.buf[:], x)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *graphEncoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+// Encode reference to value of kind Ptr, Map or Slice.
+// Returns true, if value is new and its content must be encoded.
+// 0: nil, 1: new value, n+2: reference to n-th value.
+func (e *graphEncoder) ref(v reflect.Value) bool {
+	if v.IsNil() {
+		e.uint(0)
+		return false
+	}
+	k := getRefKey(v)
+	if i, found := e.refs[k]; found {
+		e.uint(uint64(i) + 2)
+		return false
+	}
+	e.refs[k] = len(e.refs)
+	e.uint(1)
+	return true
+}
+
+func (e *graphEncoder) value(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.uint(1)
+		} else {
+			e.uint(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.uint(math.Float64bits(v.Float()))
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Ptr:
+		if e.ref(v) {
+			e.value(v.Elem())
+		}
+	case reflect.Slice:
+		if e.ref(v) {
+			e.uint(uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				e.value(v.Index(i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			e.value(v.Index(i))
+		}
+	case reflect.Map:
+		if e.ref(v) {
+			e.uint(uint64(v.Len()))
+			iter := v.MapRange()
+			for iter.Next() {
+				e.value(iter.Key())
+				e.value(iter.Value())
+			}
+		}
+	case reflect.Struct:
+		v = addressable(v)
+		for i := 0; i < v.NumField(); i++ {
+			e.value(accessible(v.Field(i)))
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			e.uint(0)
+			return
+		}
+		v = v.Elem()
+		t := v.Type()
+		if i, found := e.types[t]; found {
+			e.uint(uint64(i) + 2)
+		} else {
+			name := t.String()
+			if e.g.name2type[name] != t {
+				panic(codecError{fmt.Errorf("unknown type %s", name)})
+			}
+			e.types[t] = len(e.types)
+			e.uint(1)
+			e.string(name)
+		}
+		e.value(v)
+	}
+}
+
+//###################################################################
+// Decoder
+//###################################################################
+
+type graphDecoder struct {
+	g     *graphCodec
+	r     *bufio.Reader
+	refs  []reflect.Value
+	types []reflect.Type
+}
+
+func (g *graphCodec) decode(r io.Reader, root interface{}) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			e, ok := x.(codecError)
+			if !ok {
+				panic(x)
+			}
+			err = e.err
+		}
+	}()
+	d := &graphDecoder{g: g, r: bufio.NewReader(r)}
+	d.refs = append(d.refs, g.known...)
+	v := reflect.ValueOf(root)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("root of graph must be non nil pointer")
+	}
+	d.value(v.Elem())
+	return nil
+}
+
+func (d *graphDecoder) fail(err error) {
+	panic(codecError{err})
+}
+
+func (d *graphDecoder) uint() uint64 {
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return x
+}
+
+func (d *graphDecoder) int() int64 {
+	x, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return x
+}
+
+func (d *graphDecoder) string() string {
+	n := d.uint()
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.fail(err)
+	}
+	return string(b)
+}
+
+// Decode reference. Returns true, if new value must be decoded.
+func (d *graphDecoder) ref(v reflect.Value) bool {
+	switch i := d.uint(); i {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		i -= 2
+		if i >= uint64(len(d.refs)) {
+			d.fail(fmt.Errorf("invalid reference %d", i))
+		}
+		r := d.refs[i]
+		if unnamedType(r.Type()) != unnamedType(v.Type()) {
+			d.fail(fmt.Errorf("reference of type %v expected, got %v",
+				v.Type(), r.Type()))
+		}
+		v.Set(r.Convert(v.Type()))
+		return false
+	}
+}
+
+// Value v must be settable.
+func (d *graphDecoder) value(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(d.uint() != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		v.SetInt(d.int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		v.SetUint(d.uint())
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(math.Float64frombits(d.uint()))
+	case reflect.String:
+		v.SetString(d.string())
+	case reflect.Ptr:
+		if d.ref(v) {
+			p := reflect.New(v.Type().Elem())
+			d.refs = append(d.refs, p)
+			v.Set(p)
+			d.value(p.Elem())
+		}
+	case reflect.Slice:
+		if d.ref(v) {
+			// Register slice before elements are decoded,
+			// because elements may reference this slice.
+			i := len(d.refs)
+			d.refs = append(d.refs, v)
+			n := int(d.uint())
+			s := reflect.MakeSlice(v.Type(), n, n)
+			d.refs[i] = s
+			for j := 0; j < n; j++ {
+				d.value(s.Index(j))
+			}
+			v.Set(s)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			d.value(v.Index(i))
+		}
+	case reflect.Map:
+		if d.ref(v) {
+			t := v.Type()
+			m := reflect.MakeMap(t)
+			d.refs = append(d.refs, m)
+			v.Set(m)
+			n := int(d.uint())
+			for j := 0; j < n; j++ {
+				key := reflect.New(t.Key()).Elem()
+				d.value(key)
+				val := reflect.New(t.Elem()).Elem()
+				d.value(val)
+				m.SetMapIndex(key, val)
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			d.value(accessible(v.Field(i)))
+		}
+	case reflect.Interface:
+		var t reflect.Type
+		switch i := d.uint(); i {
+		case 0:
+			return
+		case 1:
+			name := d.string()
+			t = d.g.name2type[name]
+			if t == nil {
+				d.fail(fmt.Errorf("unknown type %s", name))
+			}
+			d.types = append(d.types, t)
+		default:
+			i -= 2
+			if i >= uint64(len(d.types)) {
+				d.fail(fmt.Errorf("invalid type reference %d", i))
+			}
+			t = d.types[i]
+		}
+		c := reflect.New(t).Elem()
+		d.value(c)
+		v.Set(c)
+	}
+}
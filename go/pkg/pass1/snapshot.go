@@ -0,0 +1,387 @@
+package pass1
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/ast"
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/filetree"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+//###################################################################
+// Snapshot of model.
+// Pass 1 optionally saves the model with resolved topology, zones,
+// areas, NAT domains and owners to a binary file.
+// First part of file holds the model as soon as it has been set up
+// by pass 1. Second part holds the model after services have been
+// normalized, together with parsed input and normalized rules.
+// Both parts are encoded separately, because normalizing of services
+// changes the model.
+// Query tools load one part of this file instead of processing input
+// again, if file matches current input, configuration and program.
+//###################################################################
+
+const snapshotMagic = "Netspoc snapshot 2\n"
+
+// Message of pass 1, that is shown again by query tools.
+type savedMsg struct {
+	Type int
+	Text string
+}
+
+// Data of model, that is stored in snapshot file.
+type snapshotData struct {
+	spoc           *spoc
+	symTable       *symbolTable
+	natDomains     []*natDomain
+	natTag2natType map[string]string
+	multiNAT       map[string][]natMap
+	// Messages of processing, without info and progress messages.
+	messages []savedMsg
+	// Parsed input and normalized rules of services.
+	// Only stored in second part of snapshot.
+	toplevel []ast.Toplevel
+	rules    *serviceRules
+}
+
+func newSnapshotCodec() *graphCodec {
+	// Types of values, that may be stored in interfaces.
+	samples := []interface{}{
+		&snapshotData{}, &network{}, &host{}, &subnet{}, &routerIntf{},
+		&autoIntf{}, &router{}, &zone{}, &area{}, &objGroup{},
+		&proto{}, &protoGroup{}, &service{}, &owner{}, &crypto{},
+		&ipsec{}, &isakmp{}, &wireguard{}, &pathRestriction{}, &loop{}, &natDomain{},
+		&ast.User{}, &ast.TypedElt{}, &ast.NamedRef{}, &ast.IntfRef{},
+		&ast.SimpleAuto{}, &ast.AggAuto{}, &ast.IntfAuto{},
+		&ast.Complement{}, &ast.Intersection{}, &ast.TopList{},
+		&ast.Protocolgroup{}, &ast.Protocol{}, &ast.TopStruct{},
+		&ast.Service{}, &ast.Network{}, &ast.Router{}, &ast.Area{},
+	}
+	// Global values, that are referenced, but not changed by model.
+	known := []interface{}{network00, network00v6}
+	addSorted := func(keys []string, get func(string) interface{}) {
+		sort.Strings(keys)
+		for _, k := range keys {
+			known = append(known, get(k))
+		}
+	}
+	var keys []string
+	for k := range routerInfo {
+		keys = append(keys, k)
+	}
+	addSorted(keys, func(k string) interface{} { return routerInfo[k] })
+	keys = nil
+	for k := range routingInfo {
+		keys = append(keys, k)
+	}
+	addSorted(keys, func(k string) interface{} { return routingInfo[k] })
+	keys = nil
+	for k := range xxrpInfo {
+		keys = append(keys, k)
+	}
+	addSorted(keys, func(k string) interface{} { return xxrpInfo[k] })
+	return newGraphCodec(samples, known)
+}
+
+// Key of snapshot changes, if program, configuration,
+// some input file or some public key file has changed.
+func getSnapshotKey(path string, g *graphCodec) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, version+"\n")
+	io.WriteString(h, g.fingerprint()+"\n")
+
+	// Options, that don't change model, are ignored.
+	cfg := *conf.Conf
+	cfg.MaxErrors = 0
+	cfg.Verbose = false
+	cfg.TimeStamps = false
+	cfg.StartTime = 0
+	cfg.ConcurrencyPass1 = 0
+	cfg.ConcurrencyPass2 = 0
+	cfg.Stats = ""
+	cfg.Snapshot = ""
+	cfg.Watch = false
+	cfg.Pipe = false
+	ignore := ""
+	if cfg.IgnoreFiles != nil {
+		ignore = cfg.IgnoreFiles.String()
+	}
+	cfg.IgnoreFiles = nil
+	fmt.Fprintf(h, "%+v %s\n", cfg, ignore)
+
+	for _, input := range filetree.Files(path) {
+		data, err := ioutil.ReadFile(input.Path)
+		if err != nil {
+			return "", err
+		}
+		rel, _ := filepath.Rel(path, input.Path)
+		fmt.Fprintf(h, "%s %v %d\n", rel, input.IPV6, len(data))
+		h.Write(data)
+	}
+	hashKeyFiles(h, path)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Add name and content of public key files to hash.
+// Unreadable file is ignored here; it is reported when keys are read.
+func hashKeyFiles(h io.Writer, inPath string) {
+	for _, path := range filetree.KeyFiles(inPath) {
+		data, _ := ioutil.ReadFile(path)
+		rel, _ := filepath.Rel(inPath, path)
+		fmt.Fprintf(h, "%s %d\n", rel, len(data))
+		h.Write(data)
+	}
+}
+
+// Process topology until NAT domains and subnet relations are known.
+// This is the common part of pass 1 and all query tools.
+func (c *spoc) setupModel(toplevel []ast.Toplevel) (
+	[]*natDomain, map[string]string, map[string][]natMap) {
+
+	c.setupTopology(toplevel)
+	c.markDisabled()
+	c.setZone()
+	c.setPath()
+	natDomains, natTag2natType, multiNAT := c.distributeNatInfo()
+	c.findSubnetsInZone()
+	return natDomains, natTag2natType, multiNAT
+}
+
+// Read model from snapshot file or from input files.
+func (c *spoc) readModel(path string) (
+	[]*natDomain, map[string]string, map[string][]natMap) {
+
+	if file := conf.Conf.Snapshot; file != "" {
+		if d := c.loadSnapshot(path, file, false); d != nil {
+			return d.natDomains, d.natTag2natType, d.multiNAT
+		}
+	}
+	return c.setupModel(parseFiles(path))
+}
+
+// Read model together with parsed input and normalized rules of
+// services from snapshot file or from input files.
+func (c *spoc) readModelRules(path string) ([]ast.Toplevel, *serviceRules) {
+	if file := conf.Conf.Snapshot; file != "" {
+		if d := c.loadSnapshot(path, file, true); d != nil {
+			return d.toplevel, d.rules
+		}
+	}
+	toplevel := parseFiles(path)
+	c.setupModel(toplevel)
+	return toplevel, c.normalizeServices()
+}
+
+// Load first or second part of snapshot file.
+// Returns nil, if snapshot can't be used.
+func (c *spoc) loadSnapshot(path, file string, rules bool) *snapshotData {
+	fh, err := os.Open(file)
+	if err != nil {
+		c.progress(fmt.Sprintf("Ignoring snapshot: %v", err))
+		return nil
+	}
+	defer fh.Close()
+	g := newSnapshotCodec()
+	key, err := getSnapshotKey(path, g)
+	if err != nil {
+		c.abort("Can't %v", err)
+	}
+	r := bufio.NewReader(fh)
+	header, _ := r.ReadString('\n')
+	prevKey, _ := r.ReadString('\n')
+	if header != snapshotMagic || prevKey != key+"\n" {
+		c.progress("Ignoring outdated snapshot")
+		return nil
+	}
+	var size int
+	if _, err := fmt.Fscanf(r, "%d\n", &size); err != nil {
+		c.progress(fmt.Sprintf("Ignoring snapshot: %v", err))
+		return nil
+	}
+	if rules {
+		if _, err := r.Discard(size); err != nil {
+			c.progress(fmt.Sprintf("Ignoring snapshot: %v", err))
+			return nil
+		}
+	}
+	d := new(snapshotData)
+	if err := g.decode(r, d); err != nil {
+		c.progress(fmt.Sprintf("Ignoring snapshot: %v", err))
+		return nil
+	}
+	c.progress("Using snapshot of model")
+
+	// Take state of compiler from snapshot, but keep channels.
+	s := d.spoc
+	s.msgChan, s.ready, s.quit = c.msgChan, c.ready, c.quit
+	*c = *s
+	symTable = d.symTable
+	for _, m := range d.messages {
+		c.msgChan <- spocMsg{typ: m.Type, text: m.Text}
+	}
+	return d
+}
+
+// Messages and encoded model of pass 1, that are stored in snapshot.
+type snapshotRec struct {
+	recording bool
+	hasErr    bool
+	messages  []savedMsg
+	// Parsed input, that is stored in second part.
+	toplevel []ast.Toplevel
+	// Key and encoded parts of model; nil if model can't be saved.
+	key       string
+	data      []byte
+	rulesData []byte
+}
+
+func (c *spoc) initSnapshot() {
+	if conf.Conf.Snapshot != "" {
+		c.snapshot = &snapshotRec{recording: true}
+	}
+}
+
+// Control recording of messages.
+// Returns true, if sender waits for acknowledgement.
+func (r *snapshotRec) control(cmd string) bool {
+	switch cmd {
+	case "pause":
+		r.recording = false
+	case "resume":
+		r.recording = true
+	case "sync":
+		return true
+	case "stop":
+		r.recording = false
+		return true
+	}
+	return false
+}
+
+// Record message of pass 1 while model is set up.
+func (r *snapshotRec) record(m spocMsg) {
+	if !r.recording {
+		return
+	}
+	switch m.typ {
+	case infoM, progressM, checkErrM:
+		return
+	case errM, abortM:
+		r.hasErr = true
+	}
+	r.messages = append(r.messages, savedMsg{m.typ, m.text})
+}
+
+// Pause or resume recording of messages.
+func (c *spoc) pauseSnapshot(pause bool) {
+	if c.snapshot == nil {
+		return
+	}
+	text := "resume"
+	if pause {
+		text = "pause"
+	}
+	c.msgChan <- spocMsg{typ: snapshotM, text: text}
+}
+
+// Send control message to recording of messages and wait until
+// all previous messages are recorded.
+// Returns nil, if model can't be saved.
+func (c *spoc) syncSnapshot(cmd string) *snapshotRec {
+	r := c.snapshot
+	if r == nil {
+		return nil
+	}
+	c.msgChan <- spocMsg{typ: snapshotM, text: cmd}
+	select {
+	case <-c.ready:
+	case <-c.quit:
+		runtime.Goexit()
+	}
+	if r.hasErr {
+		r.data = nil
+		return nil
+	}
+	return r
+}
+
+// Encode current state of pass 1 as part of snapshot.
+func (c *spoc) encodeSnapshotData(r *snapshotRec, d *snapshotData) []byte {
+	s := *c
+	s.stats, s.snapshot = nil, nil
+	d.spoc = &s
+	d.symTable = symTable
+	d.messages = r.messages
+	var b bytes.Buffer
+	if err := newSnapshotCodec().encode(&b, d); err != nil {
+		c.abort("Can't encode snapshot: %v", err)
+	}
+	return b.Bytes()
+}
+
+// Encode model of pass 1 as soon as it has been set up,
+// because later phases of pass 1 change the model.
+// Model isn't saved, if some error was found.
+func (c *spoc) encodeSnapshot(path string, natDomains []*natDomain,
+	natTag2natType map[string]string, multiNAT map[string][]natMap) {
+
+	// Continue recording messages for second part.
+	r := c.syncSnapshot("sync")
+	if r == nil {
+		return
+	}
+	c.progress("Encoding snapshot of model")
+	key, err := getSnapshotKey(path, newSnapshotCodec())
+	if err != nil {
+		c.abort("Can't %v", err)
+	}
+	r.key = key
+	r.data = c.encodeSnapshotData(r, &snapshotData{
+		natDomains:     natDomains,
+		natTag2natType: natTag2natType,
+		multiNAT:       multiNAT,
+	})
+}
+
+// Encode model again after services have been normalized.
+func (c *spoc) encodeSnapshotRules(sRules *serviceRules) {
+	r := c.syncSnapshot("stop")
+	if r == nil || r.data == nil {
+		return
+	}
+	c.progress("Encoding snapshot of rules")
+	r.rulesData = c.encodeSnapshotData(r, &snapshotData{
+		toplevel: r.toplevel,
+		rules:    sRules,
+	})
+}
+
+// Save encoded model to snapshot file.
+// Is called after pass 1 has finished without errors.
+func (c *spoc) writeSnapshot() {
+	r := c.snapshot
+	if r == nil || r.data == nil {
+		return
+	}
+	c.progress("Saving snapshot of model")
+	file := conf.Conf.Snapshot
+	tmp := file + ".tmp"
+	header := fmt.Sprintf("%s%s\n%d\n", snapshotMagic, r.key, len(r.data))
+	data := append([]byte(header), r.data...)
+	data = append(data, r.rulesData...)
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		c.abort("Can't %v", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		c.abort("Can't %v", err)
+	}
+}
@@ -2,9 +2,11 @@ package pass1
 
 import (
 	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/ast"
 	"github.com/hknutzen/Netspoc/go/pkg/conf"
 	"github.com/hknutzen/Netspoc/go/pkg/diag"
 	"os"
+	"runtime"
 	"sort"
 	"time"
 )
@@ -22,6 +24,7 @@ const (
 	progressM
 	diagM
 	checkErrM
+	snapshotM
 )
 
 type spoc struct {
@@ -29,6 +32,9 @@ type spoc struct {
 	msgChan chan spocMsg
 	// Report that all or some messages have been processed.
 	ready chan bool
+	// Is closed to stop processing in watch mode, where program
+	// doesn't terminate after errors. Is nil otherwise.
+	quit chan bool
 	// State of compiler
 	userObj               userInfo
 	allNetworks           netList
@@ -45,6 +51,13 @@ type spoc struct {
 	border2obj2auto       map[*routerIntf]map[netOrRouter]intfList
 	routerAutoInterfaces  map[*router]*autoIntf
 	networkAutoInterfaces map[networkAutoIntfKey]*autoIntf
+	exportFiles           *exportFiles
+	stats                 *passStats
+	snapshot              *snapshotRec
+	// IPv6 networks with attribute 'nat64'.
+	nat64Networks netList
+	// IPv4 objects may be referenced in IPv6 context of service.
+	allowNat64 bool
 }
 
 func initSpoc() *spoc {
@@ -65,14 +78,21 @@ type spocMsg struct {
 func (c *spoc) abort(format string, args ...interface{}) {
 	t := fmt.Sprintf(format, args...)
 	c.msgChan <- spocMsg{typ: abortM, text: t}
-	// Wait until program has terminated.
-	<-make(chan int)
+	// Wait until program has terminated or stop this goroutine.
+	<-c.quit
+	runtime.Goexit()
 }
 
 func (c *spoc) stopOnErr() bool {
 	c.msgChan <- spocMsg{typ: checkErrM}
 	// Continue or wait until program has terminated if some error was seen.
-	return <-c.ready
+	select {
+	case ok := <-c.ready:
+		return ok
+	case <-c.quit:
+		runtime.Goexit()
+	}
+	return false
 }
 
 func (c *spoc) err(format string, args ...interface{}) {
@@ -120,35 +140,62 @@ func (c *spoc) diag(format string, args ...interface{}) {
 }
 
 func (c *spoc) printMessages() int {
+	return c.handleMessages(func(_ int, t string) {
+		fmt.Fprintln(os.Stderr, t)
+	})
+}
+
+// Show each message with function 'show' and
+// return number of errors.
+// In watch mode, channel c.quit is closed after too many errors and
+// messages are discarded until processing has stopped.
+func (c *spoc) handleMessages(show func(typ int, t string)) int {
 	errCounter := 0
+	stop := func() int {
+		if c.quit != nil {
+			close(c.quit)
+			for range c.msgChan {
+			}
+		}
+		return errCounter
+	}
 	for m := range c.msgChan {
 		t := m.text
+		if r := c.snapshot; r != nil {
+			if m.typ == snapshotM {
+				if r.control(t) {
+					c.ready <- true
+				}
+				continue
+			}
+			r.record(m)
+		}
 		switch m.typ {
 		case abortM:
-			fmt.Fprintln(os.Stderr, "Error: "+t)
-			fmt.Fprintln(os.Stderr, "Aborted")
+			show(m.typ, "Error: "+t)
+			show(m.typ, "Aborted")
 			errCounter++
-			return errCounter
+			return stop()
 		case errM:
-			fmt.Fprintln(os.Stderr, "Error: "+t)
+			show(m.typ, "Error: "+t)
 			errCounter++
 			if errCounter >= conf.Conf.MaxErrors {
-				fmt.Fprintf(os.Stderr, "Aborted after %d errors\n", errCounter)
-				return errCounter
+				show(m.typ, fmt.Sprintf("Aborted after %d errors", errCounter))
+				return stop()
 			}
 		case checkErrM:
 			if errCounter > 0 {
-				fmt.Fprintf(os.Stderr, "Aborted with %d error(s)\n", errCounter)
-				return errCounter
+				show(m.typ, fmt.Sprintf("Aborted with %d error(s)", errCounter))
+				return stop()
 			} else {
 				c.ready <- true
 			}
 		case warnM:
-			fmt.Fprintln(os.Stderr, "Warning: "+t)
+			show(m.typ, "Warning: "+t)
 		case diagM:
-			fmt.Fprintln(os.Stderr, "DIAG: "+t)
+			show(m.typ, "DIAG: "+t)
 		default:
-			fmt.Fprintln(os.Stderr, t)
+			show(m.typ, t)
 		}
 	}
 	return errCounter
@@ -182,48 +229,107 @@ func (c *spoc) finish() {
 	<-c.ready
 }
 
+func (c *spoc) compile(inDir, outDir string) {
+	c.showReadStatistics()
+	c.checkKeysDir(inDir)
+	c.orderProtocols()
+	c.markDisabled()
+	c.phase("checkIPAdresses")
+	// Messages of this check aren't shown by query tools.
+	c.pauseSnapshot(true)
+	c.checkIPAdresses()
+	c.pauseSnapshot(false)
+	c.phase("setZone")
+	c.setZone()
+	c.phase("setPath")
+	c.setPath()
+	c.phase("distributeNatInfo")
+	NATDomains, NATTag2natType, multiNAT := c.distributeNatInfo()
+	c.phase("findSubnetsInZone")
+	c.findSubnetsInZone()
+	c.encodeSnapshot(inDir, NATDomains, NATTag2natType, multiNAT)
+	c.phase("normalizeServices")
+	sRules := c.normalizeServices()
+	c.encodeSnapshotRules(sRules)
+	c.stopOnErr()
+	c.phase("checkServiceOwner")
+	c.checkServiceOwner(sRules)
+	c.phase("convertHostsInRules")
+	pRules, dRules := c.convertHostsInRules(sRules)
+	c.phase("groupPathRules")
+	c.groupPathRules(pRules, dRules)
+	ch := c.startInBackground(
+		c.backgroundPhase("checkRedundantRules", (*spoc).checkRedundantRules))
+	c.phase("findSubnetsInNatDomain")
+	c.findSubnetsInNatDomain(NATDomains)
+	c.phase("checkUnstableNatRules")
+	c.checkUnstableNatRules()
+	c.markManagedLocal()
+	c.phase("checkDynamicNatRules")
+	c.checkDynamicNatRules(NATDomains, NATTag2natType)
+	c.checkPortNatRules()
+	c.checkUnusedGroups()
+	c.phase("checkSupernetRules")
+	c.checkSupernetRules(pRules)
+	c.collectMessages(ch)
+	c.phase("removeSimpleDuplicateRules")
+	c.removeSimpleDuplicateRules()
+	c.phase("combineSubnetsInRules")
+	c.combineSubnetsInRules()
+	c.setPolicyDistributionIP()
+	c.phase("expandCrypto")
+	c.expandCrypto()
+	c.checkCryptoPolicy()
+	c.phase("findActiveRoutes")
+	c.findActiveRoutes()
+	c.phase("genReverseRules")
+	c.genReverseRules()
+	if outDir != "" {
+		c.phase("markSecondaryRules")
+		c.markSecondaryRules()
+		c.phase("rulesDistribution")
+		c.rulesDistribution()
+		c.phase("printCode")
+		c.readWireguardKeys(inDir)
+		c.printCode(outDir)
+		c.copyRaw(inDir, outDir)
+	}
+	c.stopOnErr()
+}
+
 func SpocMain() int {
 	inDir, outDir := conf.GetArgs()
 	diag.Info(program + ", version " + version)
+	if conf.Conf.Watch {
+		watch(inDir, outDir)
+	}
 	c := initSpoc()
+	c.initSnapshot()
 	go func() {
-		c.readNetspoc(inDir)
-		c.showReadStatistics()
-		c.orderProtocols()
-		c.markDisabled()
-		c.checkIPAdresses()
-		c.setZone()
-		c.setPath()
-		NATDomains, NATTag2natType, _ := c.distributeNatInfo()
-		c.findSubnetsInZone()
-		sRules := c.normalizeServices()
-		c.stopOnErr()
-		c.checkServiceOwner(sRules)
-		pRules, dRules := c.convertHostsInRules(sRules)
-		c.groupPathRules(pRules, dRules)
-		ch := c.startInBackground((*spoc).checkRedundantRules)
-		c.findSubnetsInNatDomain(NATDomains)
-		c.checkUnstableNatRules()
-		c.markManagedLocal()
-		c.checkDynamicNatRules(NATDomains, NATTag2natType)
-		c.checkUnusedGroups()
-		c.checkSupernetRules(pRules)
-		c.collectMessages(ch)
-		c.removeSimpleDuplicateRules()
-		c.combineSubnetsInRules()
-		c.setPolicyDistributionIP()
-		c.expandCrypto()
-		c.findActiveRoutes()
-		c.genReverseRules()
-		if outDir != "" {
-			c.markSecondaryRules()
-			c.rulesDistribution()
-			c.printCode(outDir)
-			c.copyRaw(inDir, outDir)
+		c.initStats()
+		toplevel := parseFiles(inDir)
+		if r := c.snapshot; r != nil {
+			r.toplevel = toplevel
 		}
-		c.stopOnErr()
+		c.setupTopology(toplevel)
+		c.compile(inDir, outDir)
+		c.writeSnapshot()
+		c.writeStats()
 		c.progress("Finished pass1")
 		close(c.msgChan)
 	}()
 	return c.printMessages()
 }
+
+// CheckAST applies all checks of pass1 to already parsed toplevel
+// definitions, but doesn't generate code.
+// Returns number of errors found.
+func CheckAST(l []ast.Toplevel) int {
+	c := initSpoc()
+	go func() {
+		c.setupTopology(l)
+		c.compile("", "")
+		close(c.msgChan)
+	}()
+	return c.printMessages()
+}
@@ -0,0 +1,86 @@
+package pass1
+
+import (
+	"github.com/hknutzen/Netspoc/go/pkg/conf"
+	"github.com/hknutzen/Netspoc/go/pkg/stats"
+)
+
+// Report of option --stats.
+type passStats struct {
+	Phases []stats.Phase `json:"phases"`
+	Read   struct {
+		Routers  int `json:"routers"`
+		Networks int `json:"networks"`
+		Hosts    int `json:"hosts"`
+		Services int `json:"services"`
+	} `json:"read"`
+	Rules struct {
+		Grouped   int `json:"grouped"`
+		Expanded  int `json:"expanded"`
+		Duplicate int `json:"duplicate"`
+		Redundant int `json:"redundant"`
+	} `json:"rules"`
+	// Number of ACL lines of each device.
+	ACLLines map[string]int `json:"acl_lines"`
+	timer    *stats.Timer
+	// Functions that add results of phases executed in background.
+	background []func()
+}
+
+func (c *spoc) initStats() {
+	if conf.Conf.Stats != "" {
+		c.stats = &passStats{ACLLines: make(map[string]int)}
+		c.phase("readNetspoc")
+	}
+}
+
+// Start measurement of next phase and finish previous phase.
+func (c *spoc) phase(name string) {
+	s := c.stats
+	if s == nil {
+		return
+	}
+	if s.timer != nil {
+		s.Phases = append(s.Phases, s.timer.Stop())
+	}
+	s.timer = stats.Start(name)
+}
+
+// Measure phase, that is executed by background job f.
+// Position in list of phases is reserved, when job is started.
+// Result is filled in by writeStats, after job has finished.
+// Values of CPU and memory include those of phases running concurrently.
+func (c *spoc) backgroundPhase(name string, f func(*spoc)) func(*spoc) {
+	s := c.stats
+	if s == nil {
+		return f
+	}
+	if s.timer != nil {
+		s.Phases = append(s.Phases, s.timer.Stop())
+		s.timer = nil
+	}
+	i := len(s.Phases)
+	s.Phases = append(s.Phases, stats.Phase{Name: name})
+	var p stats.Phase
+	s.background = append(s.background, func() { s.Phases[i] = p })
+	return func(c *spoc) {
+		t := stats.Start(name)
+		f(c)
+		p = t.Stop()
+	}
+}
+
+func (c *spoc) writeStats() {
+	s := c.stats
+	if s == nil {
+		return
+	}
+	s.Phases = append(s.Phases, s.timer.Stop())
+	s.timer = nil
+	for _, f := range s.background {
+		f()
+	}
+	if err := stats.Write(conf.Conf.Stats, "pass1", s); err != nil {
+		c.abort("Can't write statistics: %v", err)
+	}
+}
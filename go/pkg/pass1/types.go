@@ -164,10 +164,12 @@ type network struct {
 	maxRoutingNet        *network
 	maxSecondaryNet      *network
 	nat                  map[string]*network
+	nat64                *network
 	natTag               string
 	networks             netList
 	noCheckSupernetRules bool
 	partition            string
+	portNat              []*portNat
 	radiusAttributes     map[string]string
 	subnetOf             *network
 	subnets              []*subnet
@@ -195,11 +197,21 @@ func (a *netList) push(e *network) {
 type netObj struct {
 	ipObj
 	usedObj
-	nat     map[string]net.IP
+	nat map[string]net.IP
+	// Translated ports of static NAT by NAT tag.
+	portNat map[string][]*portNat
 	network *network
 	up      someObj
 }
 
+// Static translation of single port of host or interface.
+// Port natPort is visible in NAT domain, realPort is used by host.
+type portNat struct {
+	proto    string
+	natPort  int
+	realPort int
+}
+
 func (x *netObj) getNetwork() *network { return x.network }
 func (x *netObj) getUp() someObj       { return x.up }
 
@@ -229,6 +241,7 @@ type model struct {
 	doAuth           bool
 	canACLUseRealIP  bool
 	canDynCrypto     bool
+	canWireguard     bool
 	canLogDeny       bool
 	canObjectgroup   bool
 	canVRF           bool
@@ -286,6 +299,7 @@ type router struct {
 	model                   *model
 	log                     map[string]string
 	logDeny                 bool
+	multiHopRoutes          bool
 	localMark               int
 	origIntfs               intfList
 	crosslinkIntfs          []*routerIntf
@@ -331,6 +345,7 @@ type routerIntf struct {
 	pathStoreData
 	router          *router
 	bindNat         []string
+	bfd             bool
 	crypto          *crypto
 	dhcpClient      bool
 	dhcpServer      bool
@@ -359,6 +374,7 @@ type routerIntf struct {
 	redundancyType  string
 	redundant       bool
 	reroutePermit   netList
+	routePreference int
 	routeInZone     map[*network]intfList
 	routes          map[*routerIntf]netMap
 	routing         *routing
@@ -395,9 +411,10 @@ type owner struct {
 }
 
 type routing struct {
-	name  string
-	prt   *proto
-	mcast mcastInfo
+	name     string
+	prt      *proto
+	srcRange *proto
+	mcast    mcastInfo
 }
 
 type xxrp struct {
@@ -406,20 +423,22 @@ type xxrp struct {
 }
 
 type hardware struct {
-	interfaces intfList
-	crosslink  bool
-	loopback   bool
-	name       string
-	bindNat    []string
-	natSet     natSet
-	dstNatSet  natSet
-	needOutAcl bool
-	noInAcl    bool
-	rules      ruleList
-	intfRules  ruleList
-	outRules   ruleList
-	ioRules    map[string]ruleList
-	subcmd     []string
+	interfaces     intfList
+	crosslink      bool
+	loopback       bool
+	name           string
+	bindNat        []string
+	natSet         natSet
+	dstNatSet      natSet
+	needOutAcl     bool
+	noInAcl        bool
+	rules          ruleList
+	intfRules      ruleList
+	outRules       ruleList
+	ioRules        map[string]ruleList
+	ipsecIntfRules ruleList
+	ipsecIoRules   map[string]ruleList
+	subcmd         []string
 }
 
 type pathRestriction struct {
@@ -433,6 +452,7 @@ type crypto struct {
 	bindNat           []string
 	detailedCryptoAcl bool
 	ipsec             *ipsec
+	wireguard         *wireguard
 	name              string
 	hub               *routerIntf
 	tunnels           netList
@@ -447,6 +467,16 @@ type ipsec struct {
 	espEncryption     string
 	pfsGroup          string
 }
+type wireguard struct {
+	usedObj
+	name       string
+	listenPort int
+	keepalive  int
+	keyDir     string
+	prt        *proto
+	// Public key of router, read from file; indexed by router name.
+	publicKey map[string]string
+}
 type isakmp struct {
 	usedObj
 	name           string
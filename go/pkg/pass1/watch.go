@@ -0,0 +1,220 @@
+package pass1
+
+import (
+	"fmt"
+	"github.com/hknutzen/Netspoc/go/pkg/ast"
+	"github.com/hknutzen/Netspoc/go/pkg/diag"
+	"github.com/hknutzen/Netspoc/go/pkg/fileop"
+	"github.com/hknutzen/Netspoc/go/pkg/filetree"
+	"github.com/hknutzen/Netspoc/go/pkg/parser"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+//###################################################################
+// Watch mode.
+// Parsed files are kept in memory. Files are polled for changes and
+// only changed files are parsed again. Then all checks are applied
+// to the whole input. Only messages are shown, that are new or have
+// been resolved since previous run.
+//###################################################################
+
+const watchInterval = time.Second
+
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	ipv6    bool
+	nodes   []ast.Toplevel
+	// Messages, if file can't be read or has syntax error.
+	errors []string
+}
+
+type watcher struct {
+	inPath, outDir string
+	// Files in order of processing.
+	order []string
+	files map[string]*watchedFile
+	// Public key files of WireGuard, these aren't parsed.
+	keys map[string]*watchedFile
+	// Messages of previous run.
+	messages []string
+}
+
+func readWatchedFile(input *filetree.Context, info os.FileInfo) *watchedFile {
+	f := &watchedFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		ipv6:    input.IPV6,
+	}
+	data, err := ioutil.ReadFile(input.Path)
+	if err != nil {
+		f.errors = []string{
+			fmt.Sprintf("Error: Can't read %s: %s", input.Path, err),
+			"Aborted",
+		}
+		return f
+	}
+	nodes, err := parser.ParseFileErr(data, input.Path)
+	if err != nil {
+		f.errors = []string{err.Error()}
+		return f
+	}
+	if input.IPV6 {
+		for _, n := range nodes {
+			n.SetIPV6()
+		}
+	}
+	f.nodes = nodes
+	return f
+}
+
+// Parse new and changed files.
+// Returns true, if some file or public key file was added, removed
+// or changed.
+func (w *watcher) update() bool {
+	// Always compile in first run.
+	changed := w.files == nil
+	var order []string
+	files := make(map[string]*watchedFile)
+	for _, input := range filetree.Files(w.inPath) {
+		path := input.Path
+		info, err := os.Stat(path)
+		if err != nil {
+			// File has been removed meanwhile.
+			changed = true
+			continue
+		}
+		f := w.files[path]
+		if f == nil || !f.modTime.Equal(info.ModTime()) ||
+			f.size != info.Size() || f.ipv6 != input.IPV6 {
+
+			f = readWatchedFile(input, info)
+			changed = true
+		}
+		files[path] = f
+		order = append(order, path)
+	}
+	if len(order) != len(w.order) {
+		changed = true
+	} else {
+		for i, path := range order {
+			if w.order[i] != path {
+				changed = true
+			}
+		}
+	}
+	keys := make(map[string]*watchedFile)
+	for _, path := range filetree.KeyFiles(w.inPath) {
+		info, err := os.Stat(path)
+		if err != nil {
+			changed = true
+			continue
+		}
+		f := w.keys[path]
+		if f == nil || !f.modTime.Equal(info.ModTime()) ||
+			f.size != info.Size() {
+
+			f = &watchedFile{modTime: info.ModTime(), size: info.Size()}
+			changed = true
+		}
+		keys[path] = f
+	}
+	if len(keys) != len(w.keys) {
+		changed = true
+	}
+	w.order, w.files, w.keys = order, files, keys
+	return changed
+}
+
+// Apply all checks to current input and generate code.
+// Returns messages and number of errors.
+func (w *watcher) compile() ([]string, int) {
+	var toplevel []ast.Toplevel
+	for _, path := range w.order {
+		f := w.files[path]
+		if f.errors != nil {
+			// Like first run, stop at first file with errors.
+			return f.errors, 1
+		}
+		toplevel = append(toplevel, f.nodes...)
+	}
+	c := initSpoc()
+	c.quit = make(chan bool)
+	go func() {
+		// Channel is also closed, if processing is stopped
+		// after errors.
+		defer close(c.msgChan)
+		c.setupTopology(toplevel)
+		c.compile(w.inPath, w.outDir)
+	}()
+	var messages []string
+	errors := c.handleMessages(func(typ int, t string) {
+		switch typ {
+		case infoM, progressM:
+		default:
+			messages = append(messages, t)
+		}
+	})
+	return messages, errors
+}
+
+// Run pass 2 with same arguments, but quiet.
+func (w *watcher) pass2() {
+	prog := "spoc2"
+	if dir := filepath.Dir(os.Args[0]); dir != "." {
+		if p := filepath.Join(dir, prog); fileop.IsRegular(p) {
+			prog = p
+		}
+	}
+	cmd := exec.Command(prog, append([]string{"-q"}, os.Args[1:]...)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Pass 2 failed: %v\n", err)
+	}
+}
+
+// Show messages of current run, that haven't been shown in
+// previous run and messages of previous run, that have been resolved.
+func showChangedMessages(prev, cur []string) {
+	diff := func(l1, l2 []string) []string {
+		count := make(map[string]int)
+		for _, m := range l2 {
+			count[m]++
+		}
+		var result []string
+		for _, m := range l1 {
+			if count[m] > 0 {
+				count[m]--
+			} else {
+				result = append(result, m)
+			}
+		}
+		return result
+	}
+	for _, m := range diff(cur, prev) {
+		fmt.Fprintln(os.Stderr, m)
+	}
+	for _, m := range diff(prev, cur) {
+		fmt.Fprintln(os.Stderr, "Resolved: "+m)
+	}
+}
+
+func watch(inPath, outDir string) {
+	w := &watcher{inPath: inPath, outDir: outDir}
+	for {
+		if w.update() {
+			messages, errors := w.compile()
+			showChangedMessages(w.messages, messages)
+			w.messages = messages
+			if errors == 0 && outDir != "" {
+				w.pass2()
+			}
+			diag.Progress("Watching for changes")
+		}
+		time.Sleep(watchInterval)
+	}
+}
@@ -18,8 +18,9 @@ import (
 //
 type Scanner struct {
 	// immutable state
-	src   []byte // source
-	fname string // name of source file
+	src        []byte // source
+	fname      string // name of source file
+	panicOnErr bool   // raise panic on syntax error
 
 	// scanning state
 	ch       rune // current character
@@ -112,9 +113,26 @@ func (s *Scanner) context(offset int) string {
 	return c
 }
 
+// Error describes a syntax error, that is raised by panic.
+type Error struct {
+	Msg string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// SetPanicOnErr must be called before Init.
+// Then syntax errors are raised by panic with value of type *Error
+// instead of terminating program.
+func (s *Scanner) SetPanicOnErr() {
+	s.panicOnErr = true
+}
+
 func (s *Scanner) SyntaxErr(offset int, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	msg = "Syntax error: " + msg + s.context(offset)
+	if s.panicOnErr {
+		panic(&Error{Msg: msg})
+	}
 	fmt.Fprintln(os.Stderr, msg)
 	os.Exit(1)
 }
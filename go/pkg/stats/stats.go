@@ -0,0 +1,97 @@
+// Package stats measures resource usage of phases of a program
+// and writes a report in JSON format.
+package stats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Phase describes resource usage of a single phase.
+// Time is given in seconds, allocated memory in bytes.
+type Phase struct {
+	Name  string  `json:"name"`
+	Wall  float64 `json:"wall"`
+	CPU   float64 `json:"cpu"`
+	Alloc uint64  `json:"alloc"`
+}
+
+type Timer struct {
+	name  string
+	wall  time.Time
+	cpu   float64
+	alloc uint64
+}
+
+// CPU time of current process in user and system mode.
+func cpuTime() float64 {
+	var r syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &r) != nil {
+		return 0
+	}
+	sec := func(t syscall.Timeval) float64 {
+		return float64(t.Sec) + float64(t.Usec)/1e6
+	}
+	return sec(r.Utime) + sec(r.Stime)
+}
+
+// Cumulative number of bytes allocated on heap.
+func allocated() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.TotalAlloc
+}
+
+func Start(name string) *Timer {
+	return &Timer{
+		name:  name,
+		wall:  time.Now(),
+		cpu:   cpuTime(),
+		alloc: allocated(),
+	}
+}
+
+func (t *Timer) Stop() Phase {
+	return Phase{
+		Name:  t.name,
+		Wall:  time.Since(t.wall).Seconds(),
+		CPU:   cpuTime() - t.cpu,
+		Alloc: allocated() - t.alloc,
+	}
+}
+
+// Write report of first program with given key to new file.
+func Write(path, key string, report interface{}) error {
+	return write(path, key, report, make(map[string]json.RawMessage))
+}
+
+// Add report of next program with given key to file.
+// Reports of other programs are kept.
+func Update(path, key string, report interface{}) error {
+	all := make(map[string]json.RawMessage)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &all)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return write(path, key, report, all)
+}
+
+func write(path, key string, report interface{},
+	all map[string]json.RawMessage) error {
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	all[key] = data
+	data, err = json.MarshalIndent(all, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0666)
+}
@@ -0,0 +1,250 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use IPC::Run3;
+use File::Find;
+use File::Temp qw/ tempdir /;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+# Output is shown as content of each file, preceeded by a line
+# with dashes and name of file.
+sub test_run {
+    my ($title, $input, $args, $expected) = @_;
+    my $in_dir = prepare_in_dir($input);
+    my $out_dir = tempdir( CLEANUP => 1 ) . '/out';
+    my $cmd = "bin/anonymize-netspoc -q --key test $args $in_dir $out_dir";
+    my $stderr;
+    run3($cmd, \undef, \undef, \$stderr);
+    if ($stderr) {
+        diag("Unexpected output on STDERR:\n$stderr");
+        fail($title);
+        return;
+    }
+    my @files;
+    find({ wanted => sub { push @files, $_ if -f },
+           no_chdir => 1 }, $out_dir);
+    my $output = '';
+    local $/ = undef;
+    for my $path (sort @files) {
+        open(my $fh, '<', $path) or die "Can't open $path";
+        my $data = <$fh>;
+        close($fh);
+        (my $name = $path) =~ s/^\Q$out_dir\E\///;
+        $output .= "--- $name\n$data";
+    }
+    eq_or_diff($output, $expected, $title);
+    return;
+}
+
+my ($title, $in, $out);
+
+############################################################
+$title = 'Names, addresses, comments and descriptions';
+############################################################
+
+$in = <<'END';
+-- topology
+# Confidential comment
+owner:db = { admins = alice@corp.example; watchers = bob@corp.example; }
+network:intern = {
+ description = Internal network
+ ip = 10.1.1.0/24;
+ nat:hidden = { hidden; }
+ host:server = { ip = 10.1.1.10; owner = db; }
+ host:clients = { range = 10.1.1.16 - 10.1.1.31; }
+}
+network:intern-sub = { ip = 10.1.1.64/26; subnet_of = network:intern; }
+network:dmz = { ip = 10.1.2.0/24; }
+router:gw = {
+ interface:intern = { ip = 10.1.1.2; }
+ interface:intern-sub = { ip = 10.1.1.65; }
+}
+router:fw = {
+ managed;
+ model = ASA;
+ interface:intern = { ip = 10.1.1.1; hardware = inside; }
+ interface:dmz = { ip = 10.1.2.1; hardware = dmz-link; bind_nat = hidden; }
+}
+-- rules/web
+service:web = {
+ description = Access to web server
+ user = network:dmz;
+ permit src = user; dst = host:server; prt = tcp 80;
+}
+service:admin = {
+ user = host:clients, network:intern-sub;
+ permit src = user; dst = interface:fw.dmz; prt = tcp 22;
+}
+END
+
+$out = <<'END';
+--- f1/f3
+service:s2 = {
+ user = network:n1;
+ permit src = user;
+        dst = host:h2;
+        prt = tcp 80;
+}
+
+service:s1 = {
+ user = host:h1,
+        network:n3,
+        ;
+ permit src = user;
+        dst = interface:r1.n1;
+        prt = tcp 22;
+}
+--- f2
+owner:o1 = {
+ admins = u1@d1;
+ watchers = u2@d1;
+}
+
+network:n2 = {
+ ip = 5.28.129.0/24;
+ nat:nat1 = { hidden; }
+ host:h2 = { ip = 5.28.129.138; owner = o1; }
+ host:h1 = { range = 5.28.129.144 - 5.28.129.159; }
+}
+
+network:n3 = {
+ ip = 5.28.129.192/26;
+ subnet_of = network:n2;
+}
+
+network:n1 = { ip = 5.28.131.0/24; }
+
+router:r2 = {
+ interface:n2 = { ip = 5.28.129.130; }
+ interface:n3 = { ip = 5.28.129.193; }
+}
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n2 = { ip = 5.28.129.129; hardware = inside; }
+ interface:n1 = {
+  ip = 5.28.131.1;
+  hardware = hw1;
+  bind_nat = nat1;
+ }
+}
+END
+
+test_run($title, $in, '', $out);
+
+############################################################
+$title = 'ID hosts, VRF, aggregate';
+############################################################
+
+$in = <<'END';
+network:vpn = {
+ ip = 10.9.0.0/16;
+ host:id:alice@corp.example = { ip = 10.9.1.1; }
+ host:id:@corp.example = { range = 10.9.2.0 - 10.9.2.255; }
+}
+router:r@vrf1 = {
+ interface:vpn;
+ interface:lo = { ip = 10.10.0.1; loopback; }
+}
+group:g = host:id:alice@corp.example.vpn,
+          any:[ip = 10.9.0.0/17 & network:vpn],
+          interface:r@vrf1.lo;
+END
+
+$out = <<'END';
+--- f1
+network:n2 = {
+ ip = 5.17.0.0/16;
+ host:id:u1@d1 = { ip = 5.17.129.1; }
+ host:id:@d1   = { range = 5.17.130.0 - 5.17.130.255; }
+}
+
+router:r1@vrf1 = {
+ interface:n2;
+ interface:n1 = { ip = 5.18.0.1; loopback; }
+}
+
+group:g1 =
+ host:id:u1@d1.n2,
+ any:[ip = 5.17.128.0/17 & network:n2],
+ interface:r1@vrf1.n1,
+;
+END
+
+test_run($title, $in, '', $out);
+
+# Compile original and anonymized input.
+# Compare ACLs of generated code, where addresses are left out
+# and only prefix lengths are kept.
+sub test_acl {
+    my ($title, $input, $args) = @_;
+    my $in_dir = prepare_in_dir($input);
+    my $anon_dir = tempdir( CLEANUP => 1 ) . '/anon';
+    my $stderr;
+    run3("bin/anonymize-netspoc -q --key test $args $in_dir $anon_dir",
+         \undef, \undef, \$stderr);
+    if ($stderr) {
+        diag("Unexpected output on STDERR:\n$stderr");
+        fail($title);
+        return;
+    }
+    my @acls;
+    for my $dir ($in_dir, $anon_dir) {
+        my $out_dir = tempdir( CLEANUP => 1 ) . '/code';
+        if (system("bin/spoc1 -q $dir $out_dir 2>/dev/null") != 0) {
+            diag("Can't compile $dir");
+            fail($title);
+            return;
+        }
+        my $acl = '';
+        local $/ = undef;
+        for my $path (sort glob("$out_dir/*.rules")) {
+            open(my $fh, '<', $path) or die "Can't open $path";
+            $acl .= <$fh>;
+            close($fh);
+        }
+        $acl =~ s/[\d.]+\//\//g;
+        push @acls, $acl;
+    }
+    eq_or_diff($acls[1], $acls[0], $title);
+    return;
+}
+
+############################################################
+$title = 'Range keeps order';
+############################################################
+
+$in = <<'END';
+network:n1 = {
+ ip = 10.1.0.0/16;
+ host:range = { range = 10.1.1.100 - 10.1.2.50; }
+}
+network:n2 = { ip = 10.1.2.0/24; subnet_of = network:n1; }
+network:n3 = { ip = 10.1.1.128/25; subnet_of = network:n1; }
+network:n4 = { ip = 10.2.1.0/24; host:server = { ip = 10.2.1.10; } }
+router:u = {
+ interface:n1 = { ip = 10.1.0.2; }
+ interface:n2 = { ip = 10.1.2.1; }
+ interface:n3 = { ip = 10.1.1.129; }
+}
+router:fw = {
+ managed;
+ model = IOS;
+ interface:n1 = { ip = 10.1.0.1; hardware = inside; }
+ interface:n4 = { ip = 10.2.1.1; hardware = outside; }
+}
+service:s1 = {
+ user = host:range;
+ permit src = user; dst = host:server; prt = tcp 80;
+}
+END
+
+test_acl($title, $in, '');
+
+############################################################
+done_testing;
@@ -123,6 +123,81 @@ END
 
 test_run($title, $in, $out);
 
+############################################################
+$title = "Interface with BGP";
+############################################################
+
+$in = <<'END';
+network:U = { ip = 10.1.1.0/24; }
+router:R = {
+ managed;
+ model = IOS;
+ interface:U = { ip = 10.1.1.1; hardware = e0; routing = BGP; }
+}
+END
+
+$out = <<'END';
+--R
+ip access-list extended e0_in
+ permit tcp 10.1.1.0 0.0.0.255 10.1.1.0 0.0.0.255 eq 179
+ permit tcp 10.1.1.0 0.0.0.255 eq 179 10.1.1.0 0.0.0.255
+ deny ip any any
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "Interface with IS-IS and BFD";
+############################################################
+
+$in = <<'END';
+network:U = { ip = 10.1.1.0/24; }
+network:V = { ip = 10.1.2.0/24; }
+router:R = {
+ managed;
+ model = IOS;
+ routing = IS-IS;
+ bfd;
+ interface:U = { ip = 10.1.1.1; hardware = e0; }
+ interface:V = { ip = 10.1.2.1; hardware = e1; routing = OSPF; }
+}
+END
+
+$out = <<'END';
+--R
+ip access-list extended e0_in
+ permit udp 10.1.1.0 0.0.0.255 10.1.1.0 0.0.0.255 range 3784 3785
+ deny ip any any
+--
+ip access-list extended e1_in
+ permit 89 10.1.2.0 0.0.0.255 host 224.0.0.5
+ permit 89 10.1.2.0 0.0.0.255 host 224.0.0.6
+ permit 89 10.1.2.0 0.0.0.255 10.1.2.0 0.0.0.255
+ permit udp 10.1.2.0 0.0.0.255 10.1.2.0 0.0.0.255 range 3784 3785
+ deny ip any any
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "BFD without dynamic routing";
+############################################################
+
+$in = <<'END';
+network:U = { ip = 10.1.1.0/24; }
+router:R = {
+ managed;
+ model = IOS;
+ interface:U = { ip = 10.1.1.1; hardware = e0; bfd; }
+}
+END
+
+$out = <<'END';
+Error: Attribute 'bfd' needs dynamic routing at interface:R.U
+END
+
+test_err($title, $in, $out);
+
 ############################################################
 $title = "Interface with HSRP";
 ############################################################
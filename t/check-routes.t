@@ -0,0 +1,155 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use IPC::Run3;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+sub test_run {
+    my ($title, $input, $routes, $expected) = @_;
+    my $in_dir = prepare_in_dir($input);
+    my $route_dir = prepare_in_dir($routes);
+    my $cmd = "bin/check-routes -q $in_dir $route_dir";
+    my ($stdout, $stderr);
+    run3($cmd, \undef, \$stdout, \$stderr);
+    eq_or_diff($stderr . $stdout, $expected, $title);
+    return;
+}
+
+my ($topo, $title, $routes, $out);
+
+############################################################
+$topo = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+
+router:r1 = {
+ model = IOS;
+ managed;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:t1 = { ip = 10.9.1.1; hardware = t1; }
+}
+
+network:t1 = { ip = 10.9.1.0/24; }
+
+router:u1 = {
+ interface:t1 = { ip = 10.9.1.2; }
+ interface:n2;
+ interface:n3;
+}
+
+router:u2 = {
+ interface:t1 = { ip = 10.9.1.3; }
+ interface:n4;
+ interface:n5;
+}
+
+network:n2 = { ip = 10.1.2.0/24; }
+network:n3 = { ip = 10.1.3.0/24; }
+network:n4 = { ip = 10.1.4.0/24; }
+network:n5 = { ip = 10.1.5.0/24; }
+
+service:test = {
+ user = network:n2, network:n3, network:n4, network:n5;
+ permit src = network:n1; dst = user; prt = tcp 80;
+}
+END
+
+############################################################
+$title = 'Routing table of IOS matches';
+############################################################
+
+$routes = <<'END';
+--r1
+Gateway of last resort is 10.9.1.3 to network 0.0.0.0
+
+S*    0.0.0.0/0 [1/0] via 10.9.1.3
+      10.0.0.0/8 is variably subnetted, 6 subnets, 2 masks
+C        10.1.1.0/24 is directly connected, GigabitEthernet0/0
+L        10.1.1.1/32 is directly connected, GigabitEthernet0/0
+S        10.1.2.0/23 [1/0] via 10.9.1.2
+C        10.9.1.0/24 is directly connected, GigabitEthernet0/1
+L        10.9.1.1/32 is directly connected, GigabitEthernet0/1
+END
+
+$out = <<'END';
+END
+
+test_run($title, $topo, $routes, $out);
+
+############################################################
+$title = 'Missing, wrong and shadowed routes';
+############################################################
+
+$routes = <<'END';
+--r1
+S        10.1.2.0 255.255.255.0 [1/0] via 10.9.1.2, outside
+S        10.1.4.0 255.255.254.0 [1/0] via 10.9.1.2, outside
+S        10.1.5.0 255.255.255.0 [1/0] via 10.9.1.3, outside
+S        10.1.2.128 255.255.255.128 [1/0] via 10.9.1.3, outside
+END
+
+$out = <<'END';
+shadowed: network:n2 at router:r1 by 10.1.2.128/25 via 10.9.1.3
+missing: network:n3 at router:r1, expected via 10.9.1.2
+wrong next hop: network:n4 at router:r1, expected via 10.9.1.3, found 10.1.4.0/23 via 10.9.1.2
+END
+
+test_run($title, $topo, $routes, $out);
+
+############################################################
+$title = 'Routing table of NX-OS with equal cost routes';
+############################################################
+
+$routes = <<'END';
+--r1
+10.1.0.0/16, ubest/mbest: 2/0
+    *via 10.9.1.2, [1/0], 1d02h, static
+    *via 10.9.1.3, [1/0], 1d02h, static
+10.9.1.0/24, ubest/mbest: 1/0, attached
+    *via 10.9.1.1, Eth1/2, [0/0], 1d02h, direct
+END
+
+$out = <<'END';
+END
+
+test_run($title, $topo, $routes, $out);
+
+############################################################
+$title = 'Routing table of Linux';
+############################################################
+
+$routes = <<'END';
+--r1
+default via 10.9.1.3 dev eth1
+10.1.1.0/24 dev eth0 proto kernel scope link src 10.1.1.1
+10.1.2.0/24 via 10.9.1.2 dev eth1
+10.1.3.0/24 dev eth1 scope link
+10.9.1.0/24 dev eth1 proto kernel scope link src 10.9.1.1
+END
+
+$out = <<'END';
+wrong next hop: network:n3 at router:r1, expected via 10.9.1.2, found 10.1.3.0/24 via connected
+END
+
+test_run($title, $topo, $routes, $out);
+
+############################################################
+$title = 'Missing routing table';
+############################################################
+
+$routes = <<'END';
+--r2
+S        10.1.2.0/24 [1/0] via 10.9.1.2
+END
+
+$out = <<'END';
+Warning: Missing routing table of router:r1
+END
+
+test_run($title, $topo, $routes, $out);
+
+############################################################
+done_testing;
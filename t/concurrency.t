@@ -43,6 +43,172 @@ END
 
 test_run($title, $in, $out, '--concurrency_pass1=2');
 
+############################################################
+$title = 'Parse files concurrently';
+############################################################
+
+# Definitions are read in order of files,
+# even if files are parsed concurrently.
+$in = '';
+for my $i (1 .. 6) {
+    $in .= <<"END";
+-- file$i
+network:n$i = { ip = 10.1.$i.0/24; }
+network:n1 = { ip = 10.2.$i.0/24; }
+END
+}
+$in .= <<'END';
+-- file0
+network:n1 = { ip = 10.1.1.0/24; }
+END
+
+$out = <<'END';
+Error: Duplicate definition of network:n1 in file0 and file1
+Error: Duplicate definition of network:n1 in file1
+Error: Duplicate definition of network:n1 in file1 and file2
+Error: Duplicate definition of network:n1 in file2 and file3
+Error: Duplicate definition of network:n1 in file3 and file4
+Error: Duplicate definition of network:n1 in file4 and file5
+Error: Duplicate definition of network:n1 in file5 and file6
+END
+
+test_err($title, $in, $out, '--concurrency_pass1=4');
+
+############################################################
+$title = 'Syntax error of first file is shown';
+############################################################
+
+# Later files with syntax error are parsed faster,
+# but error of first file in order is shown.
+$in = "-- f00\n" . ("network:n1 = { ip = 10.1.1.0/24; }\n" x 20000) . "network:n2\n";
+for my $i (1 .. 40) {
+    $in .= sprintf("-- f%02d\nnetwork:n%d\n", $i, $i + 2);
+}
+
+$out = <<'END';
+Syntax error: Expected '=' at line 20001 of f00, at EOF
+END
+
+test_err($title, $in, $out, '--concurrency_pass1=8');
+
+############################################################
+$title = 'Walk paths, distribute rules and print code concurrently';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+network:n2 = { ip = 10.1.2.0/24; }
+network:n3 = { ip = 10.1.3.0/24; }
+network:n4 = { ip = 10.1.4.0/24; }
+network:n5 = { ip = 10.1.5.0/24; }
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+router:r2 = {
+ managed;
+ model = IOS;
+ interface:n2 = { ip = 10.1.2.2; hardware = n2; }
+ interface:n3 = { ip = 10.1.3.1; hardware = n3; }
+}
+router:r3 = {
+ managed;
+ model = ASA;
+ interface:n3 = { ip = 10.1.3.2; hardware = n3; }
+ interface:n4 = { ip = 10.1.4.1; hardware = n4; }
+ interface:n5 = { ip = 10.1.5.1; hardware = n5; }
+}
+
+service:s1 = {
+ user = network:n1;
+ permit src = user; dst = network:n4, network:n5; prt = tcp 80;
+}
+service:s2 = {
+ user = network:n4;
+ permit src = user; dst = network:n1, network:n2; prt = udp 53;
+}
+service:s3 = {
+ user = any:[network:n2];
+ permit src = user; dst = network:n4; prt = tcp 22;
+}
+service:s4 = {
+ user = network:n5;
+ permit src = network:n1; dst = user; prt = tcp 25;
+ deny src = network:n2; dst = user; prt = tcp 25;
+}
+END
+
+$out = <<'END';
+Warning: This supernet rule would permit unexpected access:
+  permit src=any:[network:n2]; dst=network:n4; prt=tcp 22; of service:s3
+ Generated ACL at interface:r3.n3 would permit access from additional networks:
+ - network:n3
+ Either replace any:[network:n2] by smaller networks that are not supernet
+ or add above-mentioned networks to src of rule.
+END
+
+test_warn($title, $in, $out, '--concurrency_pass1=4');
+
+$out = <<'END';
+-- r1
+route n2 10.1.4.0 255.255.254.0 10.1.2.2
+--
+! n1_in
+access-list n1_in extended permit tcp 10.1.1.0 255.255.255.0 10.1.4.0 255.255.254.0 eq 80
+access-list n1_in extended permit tcp 10.1.1.0 255.255.255.0 10.1.5.0 255.255.255.0 eq 25
+access-list n1_in extended deny ip any4 any4
+access-group n1_in in interface n1
+--
+! n2_in
+access-list n2_in extended permit udp 10.1.4.0 255.255.255.0 10.1.1.0 255.255.255.0 eq 53
+access-list n2_in extended deny ip any4 any4
+access-group n2_in in interface n2
+-- r2
+ip route 10.1.1.0 255.255.255.0 10.1.2.1
+ip route 10.1.4.0 255.255.254.0 10.1.3.2
+--
+ip access-list extended n2_in
+ deny tcp 10.1.2.0 0.0.0.255 10.1.5.0 0.0.0.255 eq 25
+ permit tcp 10.1.1.0 0.0.0.255 10.1.4.0 0.0.1.255 eq 80
+ permit tcp any 10.1.4.0 0.0.0.255 eq 22
+ permit tcp 10.1.1.0 0.0.0.255 10.1.5.0 0.0.0.255 eq 25
+ permit udp 10.1.1.0 0.0.0.255 eq 53 10.1.4.0 0.0.0.255
+ permit udp 10.1.2.0 0.0.0.255 eq 53 10.1.4.0 0.0.0.255
+ deny ip any any
+--
+ip access-list extended n3_in
+ deny ip any host 10.1.2.2
+ permit udp 10.1.4.0 0.0.0.255 10.1.1.0 0.0.0.255 eq 53
+ permit udp 10.1.4.0 0.0.0.255 10.1.2.0 0.0.0.255 eq 53
+ permit tcp 10.1.5.0 0.0.0.255 10.1.1.0 0.0.0.255 established
+ permit tcp 10.1.4.0 0.0.0.255 any established
+ deny ip any any
+-- r3
+route n3 0.0.0.0 0.0.0.0 10.1.3.1
+--
+! n3_in
+access-list n3_in extended deny tcp 10.1.2.0 255.255.255.0 10.1.5.0 255.255.255.0 eq 25
+access-list n3_in extended permit tcp 10.1.1.0 255.255.255.0 10.1.4.0 255.255.254.0 eq 80
+access-list n3_in extended permit tcp any4 10.1.4.0 255.255.255.0 eq 22
+access-list n3_in extended permit tcp 10.1.1.0 255.255.255.0 10.1.5.0 255.255.255.0 eq 25
+access-list n3_in extended deny ip any4 any4
+access-group n3_in in interface n3
+--
+! n4_in
+object-group network g0
+ network-object 10.1.1.0 255.255.255.0
+ network-object 10.1.2.0 255.255.255.0
+access-list n4_in extended permit udp 10.1.4.0 255.255.255.0 object-group g0 eq 53
+access-list n4_in extended deny ip any4 any4
+access-group n4_in in interface n4
+END
+
+test_run($title, $in, $out,
+         '--concurrency_pass1=4 --check_supernet_rules=0');
+
 ############################################################
 $title = 'Warning from background job';
 ############################################################
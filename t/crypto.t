@@ -180,7 +180,7 @@ network:n1 = { ip = 10.1.1.0/24; }
 END
 
 $out = <<'END';
-Error: Must only use ipsec type in 'type' of crypto:c
+Error: Must only use ipsec or wireguard type in 'type' of crypto:c
 Error: Missing 'type' for crypto:c
 END
 
@@ -4155,5 +4155,537 @@ END
 
 test_run($title, $in, $out);
 
+############################################################
+$title = 'Linux as VPN hub and spoke with strongSwan';
+############################################################
+
+$in = <<'END';
+ipsec:aes256SHA = {
+ key_exchange = isakmp:aes256SHA;
+ esp_encryption = aes256;
+ esp_authentication = sha256;
+ pfs_group = 19;
+ lifetime = 1 hour 100000 kilobytes;
+}
+
+isakmp:aes256SHA = {
+ ike_version = 2;
+ authentication = rsasig;
+ encryption = aes256;
+ hash = sha256;
+ group = 19;
+ lifetime = 43200 sec;
+ trust_point = gw-cert;
+}
+
+crypto:sts = {
+ type = ipsec:aes256SHA;
+}
+
+network:intern = {
+ ip = 10.1.1.0/24;
+ host:netspoc = { ip = 10.1.1.111; }
+}
+
+router:gw = {
+ model = Linux;
+ managed;
+ interface:intern = { ip = 10.1.1.101; hardware = eth0; }
+ interface:dmz = { ip = 192.168.0.101; hub = crypto:sts; hardware = eth1; }
+}
+
+network:dmz = { ip = 192.168.0.0/24; }
+
+router:extern = {
+ interface:dmz = { ip = 192.168.0.1; }
+ interface:internet;
+}
+
+network:internet = { ip = 0.0.0.0/0; has_subnets; }
+
+router:vpn1 = {
+ interface:internet = {
+  ip = 172.16.1.2;
+  id = vpn1@example.com;
+  spoke = crypto:sts;
+ }
+ interface:lan1 = { ip = 10.99.1.1; }
+}
+
+network:lan1 = { ip = 10.99.1.0/24; }
+
+router:vpn2 = {
+ model = Linux;
+ managed;
+ interface:internet = {
+  negotiated;
+  id = vpn2@example.com;
+  spoke = crypto:sts;
+  hardware = eth0;
+ }
+ interface:lan2 = { ip = 10.99.2.1; hardware = eth1; }
+}
+
+network:lan2 = { ip = 10.99.2.0/24; }
+
+service:test = {
+ user = network:lan1, network:lan2;
+ permit src = user; dst = host:netspoc; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+--gw
+cat <<EOF > /etc/swanctl/conf.d/netspoc.conf
+connections {
+ vpn1 {
+  version = 2
+  local_addrs = 192.168.0.101
+  remote_addrs = 172.16.1.2
+  proposals = aes256-sha256-ecp256
+  rekey_time = 43200s
+  local {
+   auth = pubkey
+   certs = gw-cert.pem
+  }
+  remote {
+   auth = pubkey
+   id = vpn1@example.com
+  }
+  children {
+   vpn1 {
+    local_ts = 0.0.0.0/0
+    remote_ts = 10.99.1.0/24
+    esp_proposals = aes256-sha256-ecp256
+    rekey_time = 3600s
+    rekey_bytes = 102400000
+   }
+  }
+ }
+ vpn2 {
+  version = 2
+  local_addrs = 192.168.0.101
+  remote_addrs = %any
+  proposals = aes256-sha256-ecp256
+  rekey_time = 43200s
+  local {
+   auth = pubkey
+   certs = gw-cert.pem
+  }
+  remote {
+   auth = pubkey
+   id = vpn2@example.com
+  }
+  children {
+   vpn2 {
+    local_ts = 0.0.0.0/0
+    remote_ts = 10.99.2.0/24
+    esp_proposals = aes256-sha256-ecp256
+    rekey_time = 3600s
+    rekey_bytes = 102400000
+   }
+  }
+ }
+}
+EOF
+--
+:eth1_self -
+-A eth1_self -g c1 -d 192.168.0.101
+-A INPUT -j eth1_self -i eth1
+:eth1_eth0_ipsec -
+-A eth1_eth0_ipsec -g c2 -s 10.99.0.0/22 -d 10.1.1.111 -p tcp --dport 80
+-A FORWARD -j eth1_eth0_ipsec -i eth1 -o eth0 -m policy --pol ipsec --dir in
+--vpn2
+cat <<EOF > /etc/swanctl/conf.d/netspoc.conf
+connections {
+ gw {
+  version = 2
+  remote_addrs = 192.168.0.101
+  proposals = aes256-sha256-ecp256
+  rekey_time = 43200s
+  local {
+   auth = pubkey
+   certs = gw-cert.pem
+   id = vpn2@example.com
+  }
+  remote {
+   auth = pubkey
+  }
+  children {
+   gw {
+    local_ts = 10.99.2.0/24
+    remote_ts = 0.0.0.0/0
+    esp_proposals = aes256-sha256-ecp256
+    rekey_time = 3600s
+    rekey_bytes = 102400000
+    start_action = trap
+   }
+  }
+ }
+}
+EOF
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = 'Linux hub with NAT traversal and negotiated spoke';
+############################################################
+
+$in =~ s/ike_version = 2;/ike_version = 2;\n nat_traversal = additional;/;
+
+$out = <<'END';
+--gw
+# [ ACL ]
+:c1 -
+:c2 -
+-A c1 -j ACCEPT -p 50
+-A c1 -j ACCEPT -p udp --sport 4500 --dport 4500
+-A c1 -j ACCEPT -p udp --sport 500 --dport 500
+-A c2 -j ACCEPT -s 10.99.2.0/24
+-A c2 -j ACCEPT -s 10.99.1.0/24
+--
+:eth1_self -
+-A eth1_self -g c1 -d 192.168.0.101
+-A INPUT -j eth1_self -i eth1
+:eth1_eth0_ipsec -
+-A eth1_eth0_ipsec -g c2 -s 10.99.0.0/22 -d 10.1.1.111 -p tcp --dport 80
+-A FORWARD -j eth1_eth0_ipsec -i eth1 -o eth0 -m policy --pol ipsec --dir in
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = 'Missing trust_point at Linux';
+############################################################
+
+$in =~ s/trust_point/#trust_point/;
+
+$out = <<"END";
+Error: Missing attribute 'trust_point' in isakmp:aes256SHA for router:vpn2
+Error: Missing attribute 'trust_point' in isakmp:aes256SHA for router:gw
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = 'WireGuard hub and spokes at Linux';
+############################################################
+
+$in = <<'END';
+--keys/branch/gw.pub
+HUBKEY=
+--keys/branch/vpn1.pub
+SPOKE1KEY=
+--keys/branch/vpn2.pub
+SPOKE2KEY=
+--topo
+wireguard:branch = {
+ listen_port = 51821;
+ keepalive = 25 sec;
+ key_dir = branch;
+}
+
+crypto:branch = {
+ type = wireguard:branch;
+}
+
+network:intern = {
+ ip = 10.1.1.0/24;
+ host:netspoc = { ip = 10.1.1.111; }
+}
+
+router:gw = {
+ model = Linux;
+ managed;
+ interface:intern = { ip = 10.1.1.101; hardware = eth0; }
+ interface:dmz = { ip = 192.168.0.101; hub = crypto:branch; hardware = eth1; }
+}
+
+network:dmz = { ip = 192.168.0.0/24; }
+
+router:extern = {
+ interface:dmz = { ip = 192.168.0.1; }
+ interface:internet;
+}
+
+network:internet = { ip = 0.0.0.0/0; has_subnets; }
+
+router:vpn1 = {
+ interface:internet = {
+  ip = 172.16.1.2;
+  spoke = crypto:branch;
+ }
+ interface:lan1 = { ip = 10.99.1.1; }
+}
+
+network:lan1 = { ip = 10.99.1.0/24; }
+
+router:vpn2 = {
+ model = Linux;
+ managed;
+ interface:internet = {
+  negotiated;
+  spoke = crypto:branch;
+  hardware = eth0;
+ }
+ interface:lan2 = { ip = 10.99.2.1; hardware = eth1; }
+}
+
+network:lan2 = { ip = 10.99.2.0/24; }
+
+service:test = {
+ user = network:lan1, network:lan2;
+ permit src = user; dst = host:netspoc; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+--gw
+ip link add wg-branch type wireguard
+cat <<EOF > /etc/wireguard/wg-branch.conf
+[Interface]
+ListenPort = 51821
+[Peer]
+PublicKey = SPOKE1KEY=
+Endpoint = 172.16.1.2:51821
+AllowedIPs = 10.99.1.0/24
+[Peer]
+PublicKey = SPOKE2KEY=
+AllowedIPs = 10.99.2.0/24
+EOF
+wg setconf wg-branch /etc/wireguard/wg-branch.conf
+wg set wg-branch private-key /etc/wireguard/private.key
+ip link set wg-branch up
+--
+-A eth1_self -j ACCEPT -d 192.168.0.101 -p udp --dport 51821
+-A INPUT -j eth1_self -i eth1
+--
+:wg-branch_self -
+-A INPUT -j wg-branch_self -i wg-branch
+:wg-branch_eth0 -
+-A wg-branch_eth0 -g c1 -s 10.99.0.0/22 -d 10.1.1.111 -p tcp --dport 80
+-A FORWARD -j wg-branch_eth0 -i wg-branch -o eth0
+--vpn2
+ip route add 192.168.0.0/24 dev eth0
+ip route add 10.1.1.0/24 dev wg-branch
+--
+ip link add wg-branch type wireguard
+cat <<EOF > /etc/wireguard/wg-branch.conf
+[Interface]
+ListenPort = 51821
+[Peer]
+PublicKey = HUBKEY=
+Endpoint = 192.168.0.101:51821
+AllowedIPs = 0.0.0.0/0
+PersistentKeepalive = 25
+EOF
+wg setconf wg-branch /etc/wireguard/wg-branch.conf
+wg set wg-branch private-key /etc/wireguard/private.key
+ip link set wg-branch up
+--
+-A eth0_self -j ACCEPT -s 192.168.0.101 -p udp --dport 51821
+-A INPUT -j eth0_self -i eth0
+--
+:eth1_wg-branch -
+-A eth1_wg-branch -j ACCEPT -s 10.99.2.0/24 -d 10.1.1.111 -p tcp --dport 80
+-A FORWARD -j eth1_wg-branch -i eth1 -o wg-branch
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = 'Missing public key of WireGuard peer';
+############################################################
+
+$in =~ s/^--keys\/branch\/vpn1.pub\n.*\n//m;
+
+$out = <<'END';
+Error: Can't read public key of router:vpn1 from keys/branch/vpn1.pub
+END
+
+test_err($title, $in, $out, undef, prepare_out_dir());
+
+############################################################
+$title = 'Name of WireGuard device too long';
+############################################################
+
+$in = <<'END';
+wireguard:branch = { key_dir = branch; }
+crypto:branch-offices = { type = wireguard:branch; }
+END
+
+$out = <<'END';
+Error: Name of crypto:branch-offices is too long for WireGuard device 'wg-branch-offices', max. 15 characters are allowed
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = 'Ignore toplevel keys without WireGuard';
+############################################################
+
+$in = <<'END';
+-- topo
+network:n1 = { ip = 10.1.1.0/24; }
+-- keys
+network:n2 = { ip = 10.1.2.0/24; }
+END
+
+$out = <<'END';
+Warning: Ignoring 'keys' at toplevel of policy;
+ it is reserved for public keys of WireGuard
+END
+
+test_warn($title, $in, $out);
+
+############################################################
+$title = 'WireGuard not supported at IOS';
+############################################################
+
+$in = <<'END';
+wireguard:branch = { key_dir = branch; }
+crypto:branch = { type = wireguard:branch; }
+router:r1 = {
+ model = IOS;
+ managed;
+ interface:n1 = { ip = 10.1.1.1; hub = crypto:branch; hardware = n1; }
+}
+network:n1 = { ip = 10.1.1.0/24; }
+END
+
+$out = <<'END';
+Error: WireGuard not supported for router:r1 of model IOS
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = 'Crypto policy violated';
+############################################################
+
+$in = <<'END';
+ipsec:aes256SHA = {
+ key_exchange = isakmp:aes256SHA;
+ esp_encryption = aes256;
+ esp_authentication = sha256;
+ pfs_group = 19;
+ lifetime = 1 hour 100000 kilobytes;
+}
+
+isakmp:aes256SHA = {
+ ike_version = 2;
+ authentication = rsasig;
+ encryption = aes256;
+ hash = sha256;
+ group = 19;
+ lifetime = 43200 sec;
+ trust_point = gw-cert;
+}
+
+crypto:sts = {
+ type = ipsec:aes256SHA;
+}
+
+network:intern = {
+ ip = 10.1.1.0/24;
+ host:netspoc = { ip = 10.1.1.111; }
+}
+
+router:gw = {
+ model = Linux;
+ managed;
+ interface:intern = { ip = 10.1.1.101; hardware = eth0; }
+ interface:dmz = { ip = 192.168.0.101; hub = crypto:sts; hardware = eth1; }
+}
+
+network:dmz = { ip = 192.168.0.0/24; }
+
+router:extern = {
+ interface:dmz = { ip = 192.168.0.1; }
+ interface:internet;
+}
+
+network:internet = { ip = 0.0.0.0/0; has_subnets; }
+
+router:vpn1 = {
+ interface:internet = {
+  ip = 172.16.1.2;
+  id = vpn1@example.com;
+  spoke = crypto:sts;
+ }
+ interface:lan1 = { ip = 10.99.1.1; }
+}
+
+network:lan1 = { ip = 10.99.1.0/24; }
+
+router:vpn2 = {
+ model = Linux;
+ managed;
+ interface:internet = {
+  negotiated;
+  id = vpn2@example.com;
+  spoke = crypto:sts;
+  hardware = eth0;
+ }
+ interface:lan2 = { ip = 10.99.2.1; hardware = eth1; }
+}
+
+network:lan2 = { ip = 10.99.2.0/24; }
+
+service:test = {
+ user = network:lan1, network:lan2;
+ permit src = user; dst = host:netspoc; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+Warning: ipsec:aes256SHA violates crypto policy:
+ - esp_authentication = sha256
+ - lifetime = 100000 kilobytes, exceeds 50000 kilobytes
+ Affected hubs:
+ - router:gw
+ Affected spokes:
+ - router:vpn1
+ - router:vpn2
+Warning: isakmp:aes256SHA violates crypto policy:
+ - hash = sha256
+ - lifetime = 43200 sec, exceeds 28800 sec
+ Affected hubs:
+ - router:gw
+ Affected spokes:
+ - router:vpn1
+ - router:vpn2
+END
+
+test_warn($title, $in, $out,
+          '--check_crypto_policy=warn --crypto_policy_encryption=aes256' .
+          ' --crypto_policy_hash=sha384,sha512 --crypto_policy_group=19,20' .
+          ' --crypto_policy_isakmp_lifetime=28800' .
+          ' --crypto_policy_ipsec_kilobytes=50000');
+
+############################################################
+$title = 'Crypto policy from config file';
+############################################################
+
+$in = <<'END' . "--topology\n" . $in;
+--config
+check_crypto_policy = 1;
+crypto_policy_ike_version = 1;
+crypto_policy_group = 14, 19;
+END
+
+$out = <<'END';
+Error: isakmp:aes256SHA violates crypto policy:
+ - ike_version = 2
+ Affected hubs:
+ - router:gw
+ Affected spokes:
+ - router:vpn1
+ - router:vpn2
+END
+
+test_err($title, $in, $out);
+
 ############################################################
 done_testing;
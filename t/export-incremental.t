@@ -0,0 +1,127 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use JSON;
+use IPC::Run3;
+use File::Temp qw/ tempdir /;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+# Export first input, then export second input incrementally
+# into same directory.
+sub run {
+    my ($input1, $input2) = @_;
+    my $out_dir = tempdir( CLEANUP => 1 );
+    for my $input ($input1, $input2) {
+        my $in_dir = prepare_in_dir($input);
+        my $cmd = "bin/export-netspoc -q --incremental $in_dir $out_dir";
+        my $stderr;
+        run3($cmd, \undef, \undef, \$stderr);
+        if ($stderr) {
+            diag("Unexpected output on STDERR:\n$stderr");
+            return;
+        }
+    }
+    return $out_dir;
+}
+
+sub read_json {
+    my ($path) = @_;
+    local $/ = undef;
+    open(my $fh, '<', $path) or die "Can't open $path";
+    my $data = <$fh>;
+    close($fh);
+    return from_json($data);
+}
+
+sub test_run {
+    my ($title, $input1, $input2, $expected) = @_;
+    my $out_dir = run($input1, $input2) or do { fail($title); return; };
+    eq_or_diff(read_json("$out_dir/changes.json"), from_json($expected),
+               $title);
+    return $out_dir;
+}
+
+my ($in1, $in2, $out, $title, $out_dir);
+
+my $topo = <<'END';
+network:n1 = { ip = 10.1.1.0/24; owner = x; host:h1 = { ip = 10.1.1.10; } }
+network:n2 = { ip = 10.1.2.0/24; owner = y; }
+router:r = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+END
+
+############################################################
+$title = 'Unchanged export';
+############################################################
+
+$in1 = $topo . <<'END';
+owner:x = { admins = x@b.c; }
+owner:y = { admins = y@b.c; }
+service:s1 = {
+ user = network:n1;
+ permit src = user; dst = network:n2; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+{
+ "by_owner": {},
+ "objects": { "added": [], "modified": [], "removed": [] },
+ "owner_assignments": { "objects": [], "services": [] },
+ "owners": { "added": [], "removed": [] },
+ "services": { "added": [], "modified": [], "removed": [] }
+}
+END
+
+test_run($title, $in1, $in1, $out);
+
+############################################################
+$title = 'Changed services, objects and owners';
+############################################################
+
+$in2 = $topo . <<'END';
+owner:x = { admins = x@b.c; }
+owner:z = { admins = z@b.c; }
+service:s1 = {
+ user = network:n1;
+ permit src = user; dst = network:n2; prt = tcp 81;
+}
+service:s2 = {
+ user = host:h1;
+ permit src = user; dst = network:n2; prt = tcp 22;
+}
+END
+$in2 =~ s/owner = y;/owner = z;/;
+
+$out = <<'END';
+{
+ "by_owner": {
+  "x": { "services": [ "s1", "s2" ] },
+  "y": { "objects": [ "network:n2" ], "services": [ "s1" ] },
+  "z": { "objects": [ "network:n2" ], "services": [ "s1", "s2" ] }
+ },
+ "objects": { "added": [], "modified": [ "network:n2" ], "removed": [] },
+ "owner_assignments": {
+  "objects": [ { "name": "network:n2", "new": [ "z" ], "old": [ "y" ] } ],
+  "services": [ { "name": "s1", "new": [ "z" ], "old": [ "y" ] } ]
+ },
+ "owners": { "added": [ "z" ], "removed": [ "y" ] },
+ "services": { "added": [ "s2" ], "modified": [ "s1" ], "removed": [] }
+}
+END
+
+$out_dir = test_run($title, $in1, $in2, $out);
+
+ok((-d "$out_dir/owner/z" and not -e "$out_dir/owner/y"),
+   "$title: owner directories");
+
+############################################################
+done_testing;
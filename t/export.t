@@ -3715,8 +3715,10 @@ $title = 'Invalid options and arguments';
 $out = <<'END';
 Usage: bin/export-netspoc [options] netspoc-data out-directory
 
-  -6, --ipv6    Expect IPv6 definitions
-  -q, --quiet   Don't print progress messages
+  -i, --incremental       Write only changed files and add file changes.json
+  -6, --ipv6              Expect IPv6 definitions
+  -q, --quiet             Don't print progress messages
+      --snapshot string   Read model from this file, if it matches input
 END
 
 my %in2out = (
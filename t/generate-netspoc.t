@@ -0,0 +1,60 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use IPC::Run3;
+use File::Temp qw/ tempdir /;
+
+# Run command, return STDOUT and STDERR.
+sub run {
+    my ($cmd) = @_;
+    my ($stdout, $stderr);
+    run3($cmd, \undef, \$stdout, \$stderr);
+    return ($stdout, $stderr);
+}
+
+# Generate policy with given options and compile it.
+# Generated policy must compile without any messages.
+sub test_generate {
+    my ($title, $options) = @_;
+    my $dir = tempdir( CLEANUP => 1 );
+    my ($stdout, $stderr) = run("bin/generate-netspoc $options $dir/in");
+    eq_or_diff($stderr, '', "$title: generate");
+    ($stdout, $stderr) = run("bin/spoc1 -q $dir/in $dir/out");
+    eq_or_diff($stderr, '', "$title: pass 1");
+    ($stdout, $stderr) = run("bin/spoc2 -q $dir/in $dir/out");
+    eq_or_diff($stderr, '', "$title: pass 2");
+}
+
+############################################################
+test_generate('Default', '');
+test_generate('Loops', '--zones 60 --loops 10');
+test_generate('NAT', '--zones 40 --nat 3 --loops 6');
+test_generate('Crypto', '--zones 30 --crypto 2 --spokes 3');
+test_generate('Nested groups', '--zones 50 --services 200 --group-depth 4');
+test_generate('All', '--zones 80 --nat 2 --loops 5 --crypto 1 --seed 7');
+
+############################################################
+my $title = 'Same seed generates same policy';
+############################################################
+
+my $dir = tempdir( CLEANUP => 1 );
+run("bin/generate-netspoc --seed 3 $dir/a");
+run("bin/generate-netspoc --seed 3 $dir/b");
+my ($diff) = run("diff -r $dir/a $dir/b");
+eq_or_diff($diff, '', $title);
+
+############################################################
+$title = 'Output directory must not exist';
+############################################################
+
+my ($stdout, $stderr) = run("bin/generate-netspoc $dir/a");
+eq_or_diff($stderr, <<"END", $title);
+Error: $dir/a already exists
+Aborted
+END
+
+############################################################
+done_testing;
@@ -591,6 +591,61 @@ END
 test_group($title, $in, 'network:n1, network:n2, network:n3a', $out,
            '--name -a');
 
+############################################################
+$title = 'Show all attributes as JSON';
+############################################################
+
+$in = <<'END';
+owner:o1 = { admins = o1@b.c; }
+area:a1 = { border = interface:r1.n1; owner = o1; }
+network:n1 = {
+ ip = 10.1.1.0/24;
+ nat:x = { ip = 10.8.1.0/24; }
+ host:h1 = { ip = 10.1.1.10; }
+}
+network:n2 = { ip = 10.1.2.0/24; }
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; bind_nat = x; }
+}
+END
+
+$out = <<'END';
+[
+ {
+  "admins": [
+   "o1@b.c"
+  ],
+  "areas": [
+   "area:a1"
+  ],
+  "ip": "10.1.1.10",
+  "name": "host:h1",
+  "nat_ip": "10.8.1.10",
+  "owner": "owner:o1",
+  "zone": "any:[network:n1]"
+ },
+ {
+  "ip": "10.1.2.1",
+  "name": "interface:r1.n2",
+  "nat_ip": "10.1.2.1",
+  "zone": "any:[network:n2]"
+ },
+ {
+  "admins": [
+   "o1@b.c"
+  ],
+  "name": "area:a1",
+  "owner": "owner:o1"
+ }
+]
+END
+
+test_group($title, $in, 'host:h1, interface:r1.n2, area:a1', $out,
+           '--json --nat n2');
+
 ############################################################
 $title = 'Mark group in empty rule as used';
 ############################################################
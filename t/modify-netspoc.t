@@ -0,0 +1,367 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use IPC::Run3;
+use File::Temp qw(tempfile);
+
+sub run {
+    my ($input, $jobs) = @_;
+    my ($in_fh, $filename) = tempfile(UNLINK => 1);
+    print $in_fh $input;
+    close $in_fh;
+    my $cmd = "bin/modify-netspoc -q $filename";
+    my $stderr;
+    run3($cmd, \$jobs, \undef, \$stderr);
+    my $status = $? >> 8;
+    $stderr ||= '';
+    $stderr =~ s/\Q$filename\E/INPUT/g;
+    open(my $fh, '<', $filename) or die("Can't open $filename: $!\n");
+    local $/ = undef;
+    my $output = <$fh>;
+    close($fh);
+    return($status, $output, $stderr);
+}
+
+sub test_run {
+    my ($title, $input, $jobs, $expected) = @_;
+    my ($status, $output, $stderr) = run($input, $jobs);
+    if ($status != 0) {
+        diag("Unexpected failure:\n$stderr");
+        fail($title);
+        return;
+    }
+    eq_or_diff("$stderr$output", $expected, $title);
+}
+
+sub test_err {
+    my ($title, $input, $jobs, $expected) = @_;
+    my ($status, $output, $stderr) = run($input, $jobs);
+    if ($status == 0) {
+        diag("Unexpected success\n");
+        fail($title);
+        return;
+    }
+    $stderr =~ s/Aborted\n$//;
+    eq_or_diff($stderr, $expected, $title);
+    eq_or_diff($output, $input, "$title: unchanged");
+}
+
+my ($title, $in, $jobs, $out);
+
+my $topo = <<'END';
+network:n1 = { ip = 10.1.1.0/24; host:h1 = { ip = 10.1.1.10; } }
+network:n2 = { ip = 10.1.2.0/24; }
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+END
+
+############################################################
+$title = 'Create and delete service';
+############################################################
+
+$in = $topo . <<'END';
+
+service:s1 = {
+ user = host:h1;
+ permit src = user; dst = network:n2; prt = tcp 80;
+}
+END
+
+$jobs = <<'END';
+[
+ { "method": "create_service",
+   "params": {
+     "name": "service:s2",
+     "description": "Access to r1",
+     "user": "network:n1",
+     "rules": [
+       { "action": "permit", "src": "user",
+         "dst": "interface:r1.n1", "prt": "tcp 22, icmp 8" } ] } },
+ { "method": "delete_service", "params": { "name": "s1" } }
+]
+END
+
+$out = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ host:h1 = { ip = 10.1.1.10; }
+}
+
+network:n2 = { ip = 10.1.2.0/24; }
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+
+service:s2 = {
+ description = Access to r1
+
+ user = network:n1;
+ permit src = user;
+        dst = interface:r1.n1;
+        prt = icmp 8,
+              tcp 22,
+              ;
+}
+END
+
+test_run($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Create host and add to user and rule';
+############################################################
+
+$in = $topo . <<'END';
+owner:o1 = { admins = a@example.com; }
+
+service:s1 = {
+ user = host:h1;
+ permit src = user; dst = network:n2; prt = tcp 80;
+}
+END
+
+$jobs = <<'END';
+[
+ { "method": "create_host",
+   "params": { "network": "n1", "name": "h2", "ip": "10.1.1.11",
+               "owner": "o1" } },
+ { "method": "add_to_user",
+   "params": { "service": "s1", "user": "host:h2" } },
+ { "method": "add_to_rule",
+   "params": { "service": "s1", "rule_num": 1,
+               "dst": "interface:r1.n2", "prt": "udp 53" } },
+ { "method": "remove_from_rule",
+   "params": { "service": "s1", "rule_num": 1, "prt": "tcp 80" } }
+]
+END
+
+$out = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ host:h1 = { ip = 10.1.1.10; }
+ host:h2 = { ip = 10.1.1.11; owner = o1; }
+}
+
+network:n2 = { ip = 10.1.2.0/24; }
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+
+owner:o1 = {
+ admins = a@example.com;
+}
+
+service:s1 = {
+ user = host:h1,
+        host:h2,
+        ;
+ permit src = user;
+        dst = network:n2,
+              interface:r1.n2,
+              ;
+        prt = udp 53;
+}
+END
+
+test_run($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Change owner and set attribute';
+############################################################
+
+$in = <<'END';
+owner:o1 = { admins = a@example.com; }
+owner:o2 = { admins = b@example.com; }
+area:a1 = { border = interface:r1.n1; owner = o1; }
+network:n1 = { ip = 10.1.1.0/24; host:h1 = { ip = 10.1.1.10; owner = o1; } }
+network:n2 = { ip = 10.1.2.0/24; }
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+END
+
+$jobs = <<'END';
+[
+ { "method": "change_owner",
+   "params": { "name": "network:n2", "owner": "owner:o2" } },
+ { "method": "change_owner",
+   "params": { "name": "host:h1", "owner": "" } },
+ { "method": "set_attribute",
+   "params": { "name": "area:a1", "attribute": "owner", "value": null } },
+ { "method": "set_attribute",
+   "params": { "name": "network:n1", "attribute": "owner", "value": "o1" } },
+ { "method": "set_attribute",
+   "params": { "name": "network:n2", "attribute": "has_subnets",
+               "value": "" } },
+ { "method": "set_attribute",
+   "params": { "name": "interface:r1.n2", "attribute": "routing",
+               "value": "OSPF" } }
+]
+END
+
+$out = <<'END';
+owner:o1 = {
+ admins = a@example.com;
+}
+
+owner:o2 = {
+ admins = b@example.com;
+}
+
+area:a1 = {
+ border = interface:r1.n1;
+}
+
+network:n1 = {
+ ip = 10.1.1.0/24;
+ owner = o1;
+ host:h1 = { ip = 10.1.1.10; }
+}
+
+network:n2 = {
+ ip = 10.1.2.0/24;
+ owner = o2;
+ has_subnets;
+}
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = {
+  ip = 10.1.2.1;
+  hardware = n2;
+  routing = OSPF;
+ }
+}
+END
+
+test_run($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Unknown service';
+############################################################
+
+$in = $topo;
+
+$jobs = <<'END';
+{ "method": "add_to_user", "params": { "service": "s1", "user": "host:h1" } }
+END
+
+$out = <<'END';
+Error: Can't find service:s1 in job 1 (add_to_user)
+END
+
+test_err($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Unknown method';
+############################################################
+
+$jobs = <<'END';
+[ { "method": "create_network", "params": {} } ]
+END
+
+$out = <<'END';
+Error: Unknown method 'create_network' in job 1
+END
+
+test_err($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Invalid rule number';
+############################################################
+
+$in = $topo . <<'END';
+
+service:s1 = {
+ user = host:h1;
+ permit src = user; dst = network:n2; prt = tcp 80;
+}
+END
+
+$jobs = <<'END';
+{ "method": "add_to_rule",
+  "params": { "service": "s1", "rule_num": 2, "prt": "tcp 81" } }
+END
+
+$out = <<'END';
+Error: Invalid rule_num 2, have 1 rules in service:s1 in job 1 (add_to_rule)
+END
+
+test_err($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Element to remove not found';
+############################################################
+
+$jobs = <<'END';
+{ "method": "remove_from_user",
+  "params": { "service": "s1", "user": "network:n1" } }
+END
+
+$out = <<'END';
+Error: Can't find network:n1 in user of service:s1 in job 1 (remove_from_user)
+END
+
+test_err($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Nothing is written if check fails';
+############################################################
+
+$jobs = <<'END';
+[
+ { "method": "add_to_rule",
+   "params": { "service": "s1", "rule_num": 1, "prt": "tcp 81" } },
+ { "method": "add_to_user",
+   "params": { "service": "s1", "user": "host:h3" } }
+]
+END
+
+$out = <<'END';
+Error: Can't resolve host:h3 in user of service:s1
+Aborted with 1 error(s)
+Error: Changes are not applied because of errors
+END
+
+test_err($title, $in, $jobs, $out);
+
+############################################################
+$title = 'Duplicate host';
+############################################################
+
+$in = $topo;
+
+$jobs = <<'END';
+{ "method": "create_host",
+  "params": { "network": "n1", "name": "h1", "ip": "10.1.1.11" } }
+END
+
+$out = <<'END';
+Error: Duplicate definition of host:h1 in job 1 (create_host)
+END
+
+test_err($title, $in, $jobs, $out);
+
+############################################################
+done_testing;
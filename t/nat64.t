@@ -0,0 +1,265 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use lib 't';
+use Test_Netspoc;
+
+my ($title, $in, $out, $topo);
+
+############################################################
+$topo = <<'END';
+-- topo
+network:pool = { ip = 192.0.2.0/28; }
+network:t = { ip = 10.9.9.0/24; }
+network:srv = { ip = 10.1.1.0/24; host:web = { ip = 10.1.1.10; } }
+router:gw = {
+ managed;
+ model = ASA;
+ interface:pool = { ip = 192.0.2.1; hardware = pool; }
+ interface:t = { ip = 10.9.9.1; hardware = t; }
+}
+router:r4 = {
+ managed;
+ model = IOS;
+ interface:t = { ip = 10.9.9.2; hardware = t; }
+ interface:srv = { ip = 10.1.1.1; hardware = srv; }
+}
+-- ipv6/topo
+network:c6 = { ip = 2001:db8:1::/64; }
+network:n64 = { ip = 64:ff9b::/96; nat64 = network:pool; }
+router:gw = {
+ managed;
+ model = ASA;
+ interface:c6 = { ip = 2001:db8:1::1; hardware = inside; }
+ interface:n64 = { ip = 64:ff9b::1; hardware = nat64; }
+}
+END
+
+############################################################
+$title = "IPv6 client and IPv4 server with NAT64";
+############################################################
+
+$in = $topo . <<'END';
+-- ipv6/rules
+service:s1 = {
+ user = network:c6;
+ permit src = user; dst = host:web; prt = tcp 80;
+ permit src = user; dst = network:srv; prt = tcp 443;
+}
+END
+
+$out = <<'END';
+-- gw
+! pool_in
+access-list pool_in extended permit tcp 192.0.2.0 255.255.255.240 host 10.1.1.10 eq 80
+access-list pool_in extended permit tcp 192.0.2.0 255.255.255.240 10.1.1.0 255.255.255.0 eq 443
+access-list pool_in extended deny ip any4 any4
+access-group pool_in in interface pool
+-- r4
+ip access-list extended t_in
+ deny ip any host 10.1.1.1
+ permit tcp 192.0.2.0 0.0.0.15 host 10.1.1.10 eq 80
+ permit tcp 192.0.2.0 0.0.0.15 10.1.1.0 0.0.0.255 eq 443
+ deny ip any any
+-- ipv6/gw
+! inside_in
+access-list inside_in extended permit tcp 2001:db8:1::/64 host 64:ff9b::a01:10a eq 80
+access-list inside_in extended permit tcp 2001:db8:1::/64 64:ff9b::a01:100/120 eq 443
+access-list inside_in extended deny ip any6 any6
+access-group inside_in in interface inside
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "NAT64 with IPv4 NAT at pool network";
+############################################################
+
+$in = $topo . <<'END';
+-- ipv6/rules
+service:s1 = {
+ user = network:c6;
+ permit src = user; dst = host:web; prt = tcp 80;
+}
+END
+
+$in =~ s/(network:srv = \{ ip = 10.1.1.0\/24;)/$1 nat:x = { ip = 10.7.7.0\/24; }/;
+$in =~ s/(interface:t = \{ ip = 10.9.9.2; hardware = t;)/$1 bind_nat = x;/;
+
+$out = <<'END';
+-- r4
+ip access-list extended t_in
+ permit tcp 192.0.2.0 0.0.0.15 host 10.7.7.10 eq 80
+ deny ip any any
+-- ipv6/gw
+! inside_in
+access-list inside_in extended permit tcp 2001:db8:1::/64 host 64:ff9b::a07:70a eq 80
+access-list inside_in extended deny ip any6 any6
+access-group inside_in in interface inside
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "Use only NAT64 network in partition of source";
+############################################################
+
+$in = $topo . <<'END';
+-- ipv6/rules
+service:s1 = {
+ user = network:c6;
+ permit src = user; dst = host:web; prt = tcp 80;
+}
+-- pool2
+network:pool2 = { ip = 192.0.2.16/28; }
+router:gw2 = {
+ managed;
+ model = ASA;
+ interface:pool2 = { ip = 192.0.2.17; hardware = pool; }
+ interface:t = { ip = 10.9.9.3; hardware = t; }
+}
+-- ipv6/site2
+network:c6b = { ip = 2001:db8:2::/64; partition = site2; }
+network:n64b = { ip = 64:ff9c::/96; nat64 = network:pool2; }
+router:gw2 = {
+ managed;
+ model = ASA;
+ interface:c6b = { ip = 2001:db8:2::1; hardware = inside; }
+ interface:n64b = { ip = 64:ff9c::1; hardware = nat64; }
+}
+END
+
+$in =~ s/(network:c6 = \{ ip = 2001:db8:1::\/64;)/$1 partition = site1;/;
+
+$out = <<'END';
+-- gw2
+! pool_in
+access-list pool_in extended deny ip any4 any4
+access-group pool_in in interface pool
+-- r4
+ip access-list extended t_in
+ permit tcp 192.0.2.0 0.0.0.15 host 10.1.1.10 eq 80
+ deny ip any any
+-- ipv6/gw
+! inside_in
+access-list inside_in extended permit tcp 2001:db8:1::/64 host 64:ff9b::a01:10a eq 80
+access-list inside_in extended deny ip any6 any6
+access-group inside_in in interface inside
+-- ipv6/gw2
+! inside_in
+access-list inside_in extended deny ip any6 any6
+access-group inside_in in interface inside
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "IPv4 object as source";
+############################################################
+
+$in = $topo . <<'END';
+-- ipv6/rules
+service:s1 = {
+ user = network:c6;
+ permit src = host:web; dst = user; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+Error: IPv4 host:web must not be used as source in rule
+ permit src=host:web; dst=network:c6; prt=tcp 80; of service:s1
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = "Invalid reference in attribute nat64";
+############################################################
+
+$in = <<'END';
+-- topo
+network:pool = { ip = 192.0.2.0/28; }
+network:n1 = { ip = 10.1.1.0/24; nat64 = network:pool; }
+router:r1 = {
+ interface:pool;
+ interface:n1;
+}
+-- ipv6/topo
+network:c6 = { ip = 2001:db8:1::/64; }
+network:n64 = { ip = 64:ff9b::/96; nat64 = network:c6; }
+router:gw = {
+ interface:c6;
+ interface:n64;
+}
+END
+
+$out = <<'END';
+Error: Must not reference IPv6 network:c6 in IPv4 context 'nat64' of network:n64
+Error: Attribute 'nat64' must only be used at IPv6 network:n1
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = "Invalid NAT64 network";
+############################################################
+
+$in = <<'END';
+-- topo
+network:pool = { ip = 192.0.2.0/28; }
+network:n1 = { ip = 10.1.1.0/24; }
+router:r1 = {
+ interface:pool;
+ interface:n1;
+}
+-- ipv6/topo
+network:c6 = { ip = 2001:db8:1::/64; }
+network:n64 = { ip = 64:ff9b::/64; nat64 = network:pool; }
+router:gw = {
+ interface:c6;
+ interface:n64;
+}
+END
+
+$out = <<'END';
+Error: network:n64 with attribute 'nat64' must have prefix length 96
+Error: network:n64 and network:pool of 'nat64' must be connected to same router
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = "NPTv6 by static NAT of IPv6 network";
+############################################################
+
+$in = <<'END';
+-- ipv6/topo
+network:n1 = { ip = fd00:1::/64; nat:npt = { ip = 2001:db8:1::/64; } }
+network:n2 = { ip = 2001:db8:2::/64; }
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = fd00:1::1; hardware = n1; }
+ interface:n2 = { ip = 2001:db8:2::1; hardware = n2; bind_nat = npt; }
+}
+service:s1 = {
+ user = network:n2;
+ permit src = user; dst = network:n1; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+-- ipv6/r1
+! n2_in
+access-list n2_in extended permit tcp 2001:db8:2::/64 2001:db8:1::/64 eq 80
+access-list n2_in extended deny ip any6 any6
+access-group n2_in in interface n2
+END
+
+test_run($title, $in, $out);
+
+############################################################
+done_testing;
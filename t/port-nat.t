@@ -0,0 +1,315 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use lib 't';
+use Test_Netspoc;
+
+my ($title, $in, $out, $topo);
+
+############################################################
+$topo = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ nat:pub = { ip = 198.51.100.5/32; dynamic; }
+ host:h1 = {
+  ip = 10.1.1.10;
+  nat:pub = { ip = 198.51.100.5; tcp = 443 -> 8443; udp = 53 -> 5353; }
+ }
+ host:h2 = { ip = 10.1.1.11; nat:pub = { ip = 198.51.100.5; tcp = 8080 -> 443; } }
+}
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; bind_nat = pub; }
+}
+network:n2 = { ip = 10.1.2.0/24; }
+END
+
+############################################################
+$title = "Translate address and port of host";
+############################################################
+
+$in = $topo . <<'END';
+service:s1 = {
+ user = network:n2;
+ permit src = user; dst = host:h1; prt = tcp 8443, udp 5353;
+ permit src = user; dst = host:h2; prt = tcp 443;
+}
+service:s2 = {
+ user = network:n2;
+ permit src = user; dst = network:n1; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+-- r1
+! n2_in
+access-list n2_in extended permit tcp 10.1.2.0 255.255.255.0 host 198.51.100.5 eq 443
+access-list n2_in extended permit udp 10.1.2.0 255.255.255.0 host 198.51.100.5 eq 53
+access-list n2_in extended permit tcp 10.1.2.0 255.255.255.0 host 198.51.100.5 eq 8080
+access-list n2_in extended permit tcp 10.1.2.0 255.255.255.0 host 198.51.100.5 eq 80
+access-list n2_in extended deny ip any4 any4
+access-group n2_in in interface n2
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "Real port in NAT domain without port translation";
+############################################################
+
+$in = $topo . <<'END';
+network:n0 = { ip = 10.1.0.0/24; }
+router:r0 = {
+ managed;
+ model = ASA;
+ interface:n0 = { ip = 10.1.0.1; hardware = n0; }
+ interface:n1 = { ip = 10.1.1.2; hardware = n1; }
+}
+service:s1 = {
+ user = network:n0, network:n2;
+ permit src = user; dst = host:h1; prt = tcp 8443;
+}
+END
+
+$out = <<'END';
+-- r0
+! n0_in
+access-list n0_in extended permit tcp 10.1.0.0 255.255.255.0 host 10.1.1.10 eq 8443
+access-list n0_in extended deny ip any4 any4
+access-group n0_in in interface n0
+-- r1
+! n2_in
+access-list n2_in extended permit tcp 10.1.2.0 255.255.255.0 host 198.51.100.5 eq 443
+access-list n2_in extended deny ip any4 any4
+access-group n2_in in interface n2
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "Translate port of reverse rule at stateless device";
+############################################################
+
+$in = $topo . <<'END';
+router:r2 = {
+ managed;
+ model = IOS;
+ interface:n2 = { ip = 10.1.2.2; hardware = n2; }
+ interface:n3 = { ip = 10.1.3.1; hardware = n3; }
+}
+network:n3 = { ip = 10.1.3.0/24; }
+service:s1 = {
+ user = network:n3;
+ permit src = user; dst = host:h1; prt = udp 5353;
+ permit src = user; dst = host:h2, network:n1; prt = tcp 443;
+}
+END
+
+$out = <<'END';
+-- r2
+ip access-list extended n2_in
+ deny ip any host 10.1.3.1
+ permit udp host 198.51.100.5 eq 53 10.1.3.0 0.0.0.255
+ permit tcp host 198.51.100.5 10.1.3.0 0.0.0.255 established
+ deny ip any any
+--
+ip access-list extended n3_in
+ permit udp 10.1.3.0 0.0.0.255 host 198.51.100.5 eq 53
+ permit tcp 10.1.3.0 0.0.0.255 host 198.51.100.5 eq 443
+ permit tcp 10.1.3.0 0.0.0.255 host 198.51.100.5 eq 8080
+ deny ip any any
+END
+
+test_run($title, $in, $out, '--check_redundant_rules=warn');
+
+############################################################
+$title = "Redundant rule only in NAT domain without port translation";
+############################################################
+
+$in = $topo . <<'END';
+network:n0 = { ip = 10.1.0.0/24; }
+router:r0 = {
+ managed;
+ model = ASA;
+ interface:n0 = { ip = 10.1.0.1; hardware = n0; }
+ interface:n1 = { ip = 10.1.1.2; hardware = n1; }
+}
+service:s1 = {
+ user = network:n0, network:n2;
+ permit src = user; dst = host:h1; prt = tcp 8443;
+}
+service:s2 = {
+ user = network:n0, network:n2;
+ permit src = user; dst = network:n1; prt = tcp;
+}
+END
+
+$out = <<'END';
+Warning: Redundant rules in service:s1 compared to service:s2:
+  permit src=network:n0; dst=host:h1; prt=tcp 8443; of service:s1
+< permit src=network:n0; dst=network:n1; prt=tcp; of service:s2
+END
+
+test_warn($title, $in, $out, '--check_redundant_rules=warn');
+
+############################################################
+$title = "Transient supernet rule with translated port";
+############################################################
+
+$in = $topo . <<'END';
+router:r2 = {
+ managed;
+ model = ASA;
+ interface:n2 = { ip = 10.1.2.2; hardware = n2; }
+ interface:n3 = { ip = 10.1.3.1; hardware = n3; }
+}
+network:n3 = { ip = 10.1.3.0/24; }
+service:s1 = {
+ user = network:n3;
+ permit src = user; dst = any:[network:n2]; prt = tcp 443;
+}
+service:s2 = {
+ user = any:[network:n2];
+ permit src = user; dst = host:h1; prt = tcp 8443;
+}
+END
+
+$out = <<'END';
+Warning: Missing transient supernet rules
+ between src of service:s1 and dst of service:s2,
+ matching at any:[network:n2].
+ Add missing src elements to service:s2:
+ - network:n3
+ or add missing dst elements to service:s1:
+ - host:h1
+END
+
+test_warn($title, $in, $out, '--check_transient_supernet_rules=warn');
+
+############################################################
+$title = "Translate port of interface";
+############################################################
+
+$in = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ nat:pub = { ip = 198.51.100.5/32; dynamic; }
+}
+router:u = {
+ interface:n1 = {
+  ip = 10.1.1.2;
+  nat:pub = { ip = 198.51.100.5; tcp = 2222 -> 22; }
+ }
+}
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; bind_nat = pub; }
+}
+network:n2 = { ip = 10.1.2.0/24; }
+service:s1 = {
+ user = network:n2;
+ permit src = user; dst = interface:u.n1; prt = tcp 22;
+}
+END
+
+$out = <<'END';
+-- r1
+! n2_in
+access-list n2_in extended permit tcp 10.1.2.0 255.255.255.0 host 198.51.100.5 eq 2222
+access-list n2_in extended deny ip any4 any4
+access-group n2_in in interface n2
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = "Invalid port translation";
+############################################################
+
+$in = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ nat:pub = { ip = 198.51.100.0/28; dynamic; tcp = 80 -> 8080; }
+ host:h1 = { ip = 10.1.1.10; nat:pub = { ip = 198.51.100.5; tcp = 80; } }
+ host:h2 = { ip = 10.1.1.11; nat:pub = { ip = 198.51.100.5; udp = 0 -> 53; } }
+ host:h3 = {
+  ip = 10.1.1.12;
+  nat:pub = { ip = 198.51.100.6; tcp = 80 -> 8080, 81 -> 8080, 80 -> 8081; }
+ }
+}
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; bind_nat = pub; }
+}
+network:n2 = { ip = 10.1.2.0/24; }
+END
+
+$out = <<'END';
+Error: Unexpected attribute in nat:pub of network:n1: tcp
+Error: Expected 'PORT -> PORT' in 'tcp' of nat:pub of host:h1
+Error: Expected 'PORT -> PORT' in 'udp' of nat:pub of host:h2
+Error: Duplicate port tcp 8080 in nat:pub of host:h3
+Error: Duplicate port tcp 80 in nat:pub of host:h3
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = "Same translated port at different hosts";
+############################################################
+
+$in = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ nat:pub = { ip = 198.51.100.0/28; dynamic; }
+ host:h1 = { ip = 10.1.1.10; nat:pub = { ip = 198.51.100.5; tcp = 443 -> 443; } }
+ host:h2 = { ip = 10.1.1.11; nat:pub = { ip = 198.51.100.5; tcp = 443 -> 8443; } }
+ host:h3 = { ip = 10.1.1.12; nat:pub = { ip = 198.51.100.6; tcp = 443 -> 443; } }
+}
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; bind_nat = pub; }
+}
+network:n2 = { ip = 10.1.2.0/24; }
+END
+
+$out = <<'END';
+Error: nat:pub: host:h1 and host:h2 use same translated port tcp 443
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = "Untranslated port or host with port translation as source";
+############################################################
+
+$in = $topo . <<'END';
+service:s1 = {
+ user = network:n2;
+ permit src = user; dst = host:h1; prt = tcp 443, tcp 8443;
+ permit src = host:h2; dst = user; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+Error: host:h1 has no port translation of nat:pub for tcp 443 in rule
+ permit src=network:n2; dst=host:h1; prt=tcp 443; of service:s1
+Error: host:h2 with port translation of nat:pub must not be used as source in rule
+ permit src=host:h2; dst=network:n2; prt=tcp 80; of service:s1
+END
+
+test_err($title, $in, $out);
+
+############################################################
+done_testing;
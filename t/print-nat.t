@@ -0,0 +1,159 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use IPC::Run3;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+sub test_run {
+    my ($title, $input, $args, $expected) = @_;
+    my $in_dir = prepare_in_dir($input);
+    my $cmd = "bin/print-nat -q $in_dir $args";
+    my ($stdout, $stderr);
+    run3($cmd, \undef, \$stdout, \$stderr);
+    if ($stderr) {
+        diag("Unexpected output on STDERR:\n$stderr");
+        fail($title);
+        return;
+    }
+    eq_or_diff($stdout, $expected, $title);
+    return;
+}
+
+my ($topo, $title, $out);
+
+############################################################
+$topo = <<'END';
+network:n1 = {
+ ip = 10.1.1.0/24;
+ nat:a = { ip = 10.8.1.0/24; }
+ nat:b = { ip = 10.9.1.0/28; dynamic; }
+ host:h1 = { ip = 10.1.1.10; nat:b = { ip = 10.9.1.10; } }
+ host:h2 = { ip = 10.1.1.11; }
+}
+network:n2 = { ip = 10.1.2.0/24; nat:h = { hidden; } }
+network:n3 = { ip = 10.1.3.0/24; }
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; bind_nat = a; }
+ interface:n3 = { ip = 10.1.3.1; hardware = n3; bind_nat = b, h; }
+}
+END
+
+############################################################
+$title = 'NAT domains';
+############################################################
+
+$out = <<'END';
+nat_domain:[network:n1]
+ interfaces: interface:r1.n1
+nat_domain:[network:n2]
+ interfaces: interface:r1.n2
+ nat: a
+nat_domain:[network:n3]
+ interfaces: interface:r1.n3
+ nat: b, h
+multi_nat: a, b
+END
+
+test_run($title, $topo, '', $out);
+
+############################################################
+$title = 'Addresses of objects';
+############################################################
+
+$out = <<'END';
+nat_domain:[network:n1]	10.1.1.0/24	real	network:n1
+nat_domain:[network:n2]	10.8.1.0/24	static	network:n1
+nat_domain:[network:n3]	10.9.1.0/28	dynamic	network:n1
+nat_domain:[network:n1]	10.1.1.10	real	host:h1
+nat_domain:[network:n2]	10.8.1.10	static	host:h1
+nat_domain:[network:n3]	10.9.1.10	static	host:h1
+nat_domain:[network:n1]	10.1.1.11	real	host:h2
+nat_domain:[network:n2]	10.8.1.11	static	host:h2
+nat_domain:[network:n3]	10.9.1.0/28	dynamic	host:h2
+nat_domain:[network:n1]	10.1.2.0/24	real	network:n2
+nat_domain:[network:n2]	10.1.2.0/24	real	network:n2
+nat_domain:[network:n3]	hidden	hidden	network:n2
+nat_domain:[network:n1]	10.1.3.1	real	interface:r1.n3
+nat_domain:[network:n2]	10.1.3.1	real	interface:r1.n3
+nat_domain:[network:n3]	10.1.3.1	real	interface:r1.n3
+END
+
+test_run($title, $topo,
+         "'network:n1, host:h1, host:h2, network:n2, interface:r1.n3'", $out);
+
+############################################################
+$title = 'JSON output';
+############################################################
+
+$out = <<'END';
+{
+ "multi_nat": [
+  [
+   "a",
+   "b"
+  ]
+ ],
+ "nat_domains": [
+  {
+   "interfaces": [
+    "interface:r1.n1"
+   ],
+   "name": "nat_domain:[network:n1]",
+   "nat": []
+  },
+  {
+   "interfaces": [
+    "interface:r1.n2"
+   ],
+   "name": "nat_domain:[network:n2]",
+   "nat": [
+    "a"
+   ]
+  },
+  {
+   "interfaces": [
+    "interface:r1.n3"
+   ],
+   "name": "nat_domain:[network:n3]",
+   "nat": [
+    "b",
+    "h"
+   ]
+  }
+ ],
+ "objects": [
+  {
+   "addresses": [
+    {
+     "ip": "10.1.2.0/24",
+     "nat_domain": "nat_domain:[network:n1]",
+     "type": "real"
+    },
+    {
+     "ip": "10.1.2.0/24",
+     "nat_domain": "nat_domain:[network:n2]",
+     "type": "real"
+    },
+    {
+     "ip": "hidden",
+     "nat_domain": "nat_domain:[network:n3]",
+     "type": "hidden"
+    }
+   ],
+   "name": "network:n2"
+  }
+ ]
+}
+END
+
+test_run($title, $topo, "--json network:n2", $out);
+
+############################################################
+done_testing;
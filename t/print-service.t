@@ -131,5 +131,209 @@ END
 
 test_run($title, $in, '--name service:s1 service:s2', $out);
 
+############################################################
+$title = 'Show rules as JSON';
+############################################################
+
+$in = $topo . <<'END';
+protocol:ftp-data = tcp 20:1024-65535, stateless;
+service:s1 = {
+    user = host:h1;
+    permit src = user;
+           dst = interface:asa2.n3;
+           prt = protocol:ftp-data, icmp 3/4;
+}
+END
+
+$out = <<'END';
+{
+ "s1": [
+  {
+   "action": "permit",
+   "dst": {
+    "ip": "10.1.3.2",
+    "name": "interface:asa2.n3",
+    "nat_ip": "10.1.3.2",
+    "zone": "any:[network:n3]"
+   },
+   "prt": {
+    "modifiers": [
+     "stateless"
+    ],
+    "name": "tcp 20:1024-65535",
+    "ports": [
+     1024,
+     65535
+    ],
+    "protocol": "tcp",
+    "src_ports": [
+     20,
+     20
+    ]
+   },
+   "src": {
+    "ip": "10.1.1.10",
+    "name": "host:h1",
+    "nat_ip": "10.1.9.10",
+    "zone": "any:[network:n1]"
+   }
+  },
+  {
+   "action": "permit",
+   "dst": {
+    "ip": "10.1.3.2",
+    "name": "interface:asa2.n3",
+    "nat_ip": "10.1.3.2",
+    "zone": "any:[network:n3]"
+   },
+   "prt": {
+    "code": 4,
+    "name": "icmp 3/4",
+    "protocol": "icmp",
+    "type": 3
+   },
+   "src": {
+    "ip": "10.1.1.10",
+    "name": "host:h1",
+    "nat_ip": "10.1.9.10",
+    "zone": "any:[network:n1]"
+   }
+  }
+ ]
+}
+END
+
+test_run($title, $in, '--json --nat n3 service:s1', $out);
+
+############################################################
+$title = 'Filter by source and destination address';
+############################################################
+
+$in = $topo . <<'END';
+owner:db = { admins = db@example.com; }
+network:n4 = {
+ ip = 10.1.4.0/24;
+ host:h4 = { ip = 10.1.4.10; }
+ host:h5 = { ip = 10.1.4.11; owner = db; }
+}
+router:r4 = {
+ managed;
+ model = ASA;
+ interface:n3 = { ip = 10.1.3.4; hardware = n3; }
+ interface:n4 = { ip = 10.1.4.1; hardware = n4; }
+}
+service:s1 = {
+    user = host:h1, network:n2;
+    permit src = user; dst = network:n3; prt = tcp 80-90, udp 53;
+    permit src = user; dst = host:range3; prt = tcp 22, icmp 8;
+}
+service:s2 = {
+    user = host:h4, host:h5;
+    permit src = user; dst = network:n3; prt = tcp 25;
+}
+END
+
+$out = <<'END';
+s1:permit 10.1.1.10 10.1.3.0/24 tcp 80-90
+s1:permit 10.1.1.10 10.1.3.0/24 udp 53
+s1:permit 10.1.1.10 10.1.3.9 tcp 22
+s1:permit 10.1.1.10 10.1.3.9 icmp 8
+s1:permit 10.1.1.10 10.1.3.10 tcp 22
+s1:permit 10.1.1.10 10.1.3.10 icmp 8
+END
+
+test_run($title, $in, '--src 10.1.1.0/24 --dst host:range3', $out);
+
+############################################################
+$title = 'Filter by address with NAT';
+############################################################
+
+$out = <<'END';
+s1:permit 10.1.9.10 10.1.3.0/24 tcp 80-90
+s1:permit 10.1.9.10 10.1.3.0/24 udp 53
+s1:permit 10.1.2.0/24 10.1.3.0/24 tcp 80-90
+s1:permit 10.1.2.0/24 10.1.3.0/24 udp 53
+s1:permit 10.1.9.10 10.1.3.9 tcp 22
+s1:permit 10.1.9.10 10.1.3.9 icmp 8
+s1:permit 10.1.2.0/24 10.1.3.9 tcp 22
+s1:permit 10.1.2.0/24 10.1.3.9 icmp 8
+END
+
+test_run($title, $in, '--nat n3 --dst 10.1.3.9 service:s1', $out);
+
+############################################################
+$title = 'Filter by protocol';
+############################################################
+
+$out = <<'END';
+s1:permit 10.1.1.10 10.1.3.0/24 tcp 80-90
+s1:permit 10.1.2.0/24 10.1.3.0/24 tcp 80-90
+END
+
+test_run($title, $in, '--prt tcp:85', $out);
+
+$out = <<'END';
+s1:permit 10.1.1.10 10.1.3.9 icmp 8
+s1:permit 10.1.1.10 10.1.3.10 icmp 8
+s1:permit 10.1.2.0/24 10.1.3.9 icmp 8
+s1:permit 10.1.2.0/24 10.1.3.10 icmp 8
+END
+
+test_run($title, $in, '--prt icmp', $out);
+
+############################################################
+$title = 'Filter by owner';
+############################################################
+
+$out = <<'END';
+s2:permit 10.1.4.11 10.1.3.0/24 tcp 25
+END
+
+test_run($title, $in, '--owner owner:db', $out);
+
+############################################################
+$title = 'Show unenforceable rules';
+############################################################
+
+$in .= <<'END';
+service:s3 = {
+    user = host:h4;
+    permit src = user; dst = host:h5; prt = tcp 25;
+}
+END
+
+$out = <<'END';
+Warning: service:s3 is fully unenforceable
+END
+
+test_run($title, $in, 'service:s3 2>&1', $out);
+
+$out = <<'END';
+s3:permit 10.1.4.10 10.1.4.11 tcp 25
+END
+
+test_run($title, $in, '--unenforceable service:s3 2>&1', $out);
+
+############################################################
+$title = 'Protocol name with dash in filter';
+############################################################
+
+$in = $topo . <<'END';
+protocol:http-alt = tcp 8080;
+protocol:range-8000 = tcp 8000-8999;
+service:s1 = {
+    user = host:h1;
+    permit src = user; dst = network:n3; prt = protocol:http-alt, tcp 22;
+}
+END
+
+$out = <<'END';
+s1:permit 10.1.1.10 10.1.3.0/24 tcp 8080
+END
+
+test_run($title, $in, '--prt protocol:http-alt', $out);
+test_run($title, $in, '--prt protocol:range-8000', $out);
+test_run($title, $in, "--prt 'tcp 8000-8999'", $out);
+
 ############################################################
 done_testing;
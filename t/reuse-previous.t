@@ -7,9 +7,34 @@ use strict;
 use warnings;
 use Test::More;
 use Test::Differences;
+use IPC::Run3;
 use lib 't';
 use Test_Netspoc;
 
+# Compile each input using same output directory.
+# Return list of changed devices after each run.
+sub changed_devices {
+    my ($options, @inputs) = @_;
+    my $out_dir = prepare_out_dir();
+    my $result = '';
+    for my $input (@inputs) {
+        my $in_dir = prepare_in_dir($input);
+        my $args = "-q $options $in_dir $out_dir";
+        my $cmd = $options =~ /--pipe/
+                ? "bin/spoc1 $args | bin/spoc2 $args"
+                : "bin/spoc1 $args && bin/spoc2 $args";
+        my $stderr;
+        run3($cmd, \undef, \undef, \$stderr);
+        $stderr =~ s/^DIAG: .*\n//mg;
+        $result .= $stderr;
+        open(my $fh, '<', "$out_dir/.changed") or
+            return "$result\nCan't open .changed: $!";
+        $result .= "--\n" . join('', <$fh>);
+        close($fh);
+    }
+    return $result;
+}
+
 my ($title, $in, $out, $topo);
 
 ############################################################
@@ -98,5 +123,49 @@ END
 
 test_reuse_prev($title, $in, $in2, $out);
 
+############################################################
+$title = 'List changed devices';
+############################################################
+
+$out = <<'END';
+--
+r1
+r2
+r3
+--
+r2
+--
+r2
+--
+END
+
+eq_or_diff(changed_devices('', $in, $in2, $in, $in), $out, $title);
+
+############################################################
+$title = 'List changed devices with pipe from pass 1 to pass 2';
+############################################################
+
+eq_or_diff(changed_devices('--pipe', $in, $in2, $in, $in), $out, $title);
+
+############################################################
+$title = 'List removed device';
+############################################################
+
+my $in3 = $in;
+$in3 =~ s/router:r3 = \{\n managed;\n/router:r3 = {\n/;
+
+$out = <<'END';
+--
+r1
+r2
+r3
+--
+r3
+--
+r3
+END
+
+eq_or_diff(changed_devices('', $in, $in3, $in), $out, $title);
+
 ############################################################
 done_testing;
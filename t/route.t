@@ -686,5 +686,305 @@ END
 
 test_run($title, $in, $out);
 
+############################################################
+$title = 'Summarize routes to same hop';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+
+router:r1 = {
+ model = NX-OS;
+ managed;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:t1 = { ip = 10.9.1.1; hardware = t1; }
+}
+
+network:t1 = { ip = 10.9.1.0/24; }
+
+router:u1 = {
+ interface:t1 = { ip = 10.9.1.2; }
+ interface:n8;
+ interface:n9;
+ interface:n12;
+ interface:n14;
+ interface:n20;
+}
+
+network:n8  = { ip = 10.1.8.0/24; }
+network:n9  = { ip = 10.1.9.0/24; }
+network:n12 = { ip = 10.1.12.0/24; }
+network:n14 = { ip = 10.1.14.0/23; }
+network:n20 = { ip = 10.2.0.0/16; }
+
+router:u2 = {
+ interface:t1 = { ip = 10.9.1.3; }
+ interface:n4;
+ interface:n16;
+}
+
+network:n4  = { ip = 10.1.4.0/24; }
+network:n16 = { ip = 10.1.16.0/24; }
+
+service:test = {
+ user = network:n8, network:n9, network:n12, network:n14, network:n20,
+        network:n4, network:n16;
+ permit src = network:n1; dst = user; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+--r1
+! [ Routing ]
+ip route 10.1.8.0/21 10.9.1.2
+ip route 10.2.0.0/16 10.9.1.2
+ip route 10.1.4.0/24 10.9.1.3
+ip route 10.1.16.0/24 10.9.1.3
+END
+
+test_run($title, $in, $out, '--noauto_default_route --summarize_routes');
+
+############################################################
+$title = 'Must not summarize subnet of network behind other hop';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+
+router:r1 = {
+ model = IOS;
+ managed;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:t1 = { ip = 10.9.1.1; hardware = t1; }
+}
+
+network:t1 = { ip = 10.9.1.0/24; }
+
+router:u1 = {
+ interface:t1 = { ip = 10.9.1.2; }
+ interface:sub;
+ interface:n21;
+ interface:n22;
+}
+
+network:sub = { ip = 10.3.2.0/24; subnet_of = network:big; }
+network:n21 = { ip = 10.2.1.0/24; }
+network:n22 = { ip = 10.2.2.0/24; }
+
+router:u2 = {
+ interface:t1 = { ip = 10.9.1.3; }
+ interface:big;
+}
+
+network:big = { ip = 10.3.0.0/16; }
+
+service:test = {
+ user = network:sub, network:n21, network:n22;
+ permit src = network:n1; dst = user; prt = tcp 80;
+}
+
+service:test2 = {
+ user = network:big;
+ permit src = network:n1; dst = user; prt = tcp 81;
+}
+END
+
+$out = <<'END';
+--r1
+! [ Routing ]
+ip route 10.3.2.0 255.255.255.0 10.9.1.2
+ip route 10.2.0.0 255.255.252.0 10.9.1.2
+ip route 10.3.0.0 255.255.0.0 10.9.1.3
+END
+
+test_run($title, $in, $out, '--summarize_routes');
+
+############################################################
+$title = 'Must not summarize unused network behind other router';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+
+router:r1 = {
+ model = IOS;
+ managed;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:t1 = { ip = 10.9.1.1; hardware = t1; }
+}
+
+network:t1 = { ip = 10.9.1.0/24; }
+
+router:u1 = {
+ interface:t1 = { ip = 10.9.1.2; }
+ interface:a;
+ interface:c;
+}
+
+network:a = { ip = 10.2.0.0/24; }
+network:c = { ip = 10.2.2.0/24; }
+
+router:r3 = {
+ interface:t1 = { ip = 10.9.1.3; }
+ interface:b;
+}
+
+network:b = { ip = 10.2.1.0/24; }
+
+service:test = {
+ user = network:a, network:c;
+ permit src = network:n1; dst = user; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+--r1
+! [ Routing ]
+ip route 10.2.0.0 255.255.255.0 10.9.1.2
+ip route 10.2.2.0 255.255.255.0 10.9.1.2
+END
+
+test_run($title, $in, $out, '--noauto_default_route --summarize_routes');
+
+############################################################
+$title = 'Equal cost and floating static routes';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+
+router:r1 = {
+ model = IOS;
+ managed;
+ multi_hop_routes;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:t1 = { ip = 10.9.1.1; hardware = t1; }
+ interface:t2 = { ip = 10.9.2.1; hardware = t2; }
+}
+
+network:t1 = { ip = 10.9.1.0/24; }
+network:t2 = { ip = 10.9.2.0/24; }
+
+router:u1 = {
+ interface:t1 = { ip = 10.9.1.2; }
+ interface:n2;
+}
+
+router:u2 = {
+ interface:t2 = { ip = 10.9.2.2; route_preference = 10; }
+ interface:n2;
+}
+
+router:u3 = {
+ interface:t1 = { ip = 10.9.1.3; }
+ interface:t1b = { ip = 10.9.3.1; }
+}
+
+network:t1b = { ip = 10.9.3.0/24; }
+
+router:u4 = {
+ interface:t1b = { ip = 10.9.3.2; }
+ interface:n2;
+}
+
+network:n2 = { ip = 10.1.2.0/24; }
+
+service:test = {
+ user = network:n2;
+ permit src = network:n1; dst = user; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+--r1
+! [ Routing ]
+ip route 10.1.2.0 255.255.255.0 10.9.1.2
+ip route 10.1.2.0 255.255.255.0 10.9.1.3
+ip route 10.1.2.0 255.255.255.0 10.9.2.2 10
+--
+ip access-list extended t2_in
+ permit tcp 10.1.2.0 0.0.0.255 10.1.1.0 0.0.0.255 established
+ deny ip any any
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = 'Equal cost and floating static routes at Linux';
+############################################################
+
+$in =~ s/model = IOS;/model = Linux;/;
+
+$out = <<'END';
+--r1
+# [ Routing ]
+ip route add 10.1.2.0/24 nexthop via 10.9.1.2 nexthop via 10.9.1.3
+ip route add 10.1.2.0/24 via 10.9.2.2 metric 10
+END
+
+test_run($title, $in, $out);
+
+############################################################
+$title = 'Two static routes without multi_hop_routes';
+############################################################
+
+$in =~ s/ multi_hop_routes;\n//;
+
+$out = <<'END';
+Error: Two static routes for network:n2
+ at interface:r1.t1 via interface:u3.t1 and interface:u1.t1
+Error: Two static routes for network:n2
+ via interface:r1.t2 and interface:r1.t1
+END
+
+test_err($title, $in, $out);
+
+############################################################
+$title = 'Multi hop routes respect pathrestriction';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+
+router:r1 = {
+ model = NX-OS;
+ managed;
+ multi_hop_routes;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:t1 = { ip = 10.9.1.1; hardware = t1; }
+ interface:t2 = { ip = 10.9.2.1; hardware = t2; }
+}
+
+network:t1 = { ip = 10.9.1.0/24; }
+network:t2 = { ip = 10.9.2.0/24; }
+
+router:u1 = {
+ interface:t1 = { ip = 10.9.1.2; }
+ interface:n2;
+}
+
+router:u2 = {
+ interface:t2 = { ip = 10.9.2.2; }
+ interface:n2;
+}
+
+network:n2 = { ip = 10.1.2.0/24; }
+
+pathrestriction:p = interface:r1.n1, interface:r1.t2;
+
+service:test = {
+ user = network:n2;
+ permit src = network:n1; dst = user; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+--r1
+! [ Routing ]
+ip route 10.1.2.0/24 10.9.1.2
+END
+
+test_run($title, $in, $out);
+
 ############################################################
 done_testing;
@@ -0,0 +1,177 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use IPC::Run3;
+use File::Temp qw/ tempdir /;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+# Run command, return STDOUT and STDERR.
+sub run {
+    my ($cmd, $in_dir) = @_;
+    my ($stdout, $stderr);
+    run3($cmd, \undef, \$stdout, \$stderr);
+    $? == 0 or die "Failed: $cmd\n$stderr";
+    $stderr =~ s/\Q$in_dir\E\///g;
+    return ($stdout, $stderr);
+}
+
+# Run query with snapshot, that has been written by pass 1 for
+# input $in. Input is changed by $change afterwards, if given.
+# String IN in $query is replaced by name of input directory.
+# Compare messages of query and
+# compare result with result of same query without snapshot.
+sub test_snapshot {
+    my ($title, $in, $query, $expected_err, $change) = @_;
+    my $in_dir = prepare_in_dir($in);
+    my $out_dir = tempdir( CLEANUP => 1 );
+    my $snapshot = "$out_dir/.snapshot";
+    run("bin/spoc1 -q --snapshot=$snapshot $in_dir $out_dir/code", $in_dir);
+    if ($change) {
+        my ($file, $content) = @$change;
+        open(my $fh, '>', "$in_dir/$file") or die "Can't open $file: $!";
+        print $fh $content;
+        close($fh);
+    }
+    $query =~ s/\bIN\b/$in_dir/;
+    my ($expected) = run("bin/$query -q", $in_dir);
+    my ($stdout, $stderr) = run("bin/$query --snapshot=$snapshot", $in_dir);
+    $stderr =~ s/^(?!Using|Ignoring|Warning| ).*\n//mg;
+    eq_or_diff($stderr, $expected_err, "$title: messages");
+    eq_or_diff($stdout, $expected, "$title: result");
+}
+
+my ($title, $in, $out);
+
+############################################################
+$in = <<'END';
+-- topo
+network:n1 = { ip = 10.1.1.0/24; host:h1 = { ip = 10.1.1.10; } owner = o1; }
+network:n2 = { ip = 10.1.2.0/24; nat:x = { ip = 10.9.9.0/24; } }
+network:n3 = { ip = 10.1.3.0/24; }
+owner:o1 = { admins = a@example.com; }
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; bind_nat = x; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+router:r2 = {
+ interface:n2 = { ip = 10.1.2.2; }
+ interface:n3;
+}
+area:a1 = { border = interface:r1.n1; }
+pathrestriction:p = interface:r1.n1;
+-- rules
+service:s1 = {
+ user = host:h1;
+ permit src = user; dst = network:n2, network:n3; prt = tcp 22;
+}
+END
+############################################################
+
+############################################################
+$title = 'Print group from snapshot';
+############################################################
+
+$out = <<'END';
+Using snapshot of model
+Warning: Ignoring pathrestriction:p with only interface:r1.n1
+END
+
+test_snapshot($title, $in,
+              q{print-group --owner --nat n1 IN 'network:[area:a1],network:n2'},
+              $out);
+
+############################################################
+$title = 'Print service from snapshot';
+############################################################
+
+test_snapshot($title, $in, 'print-service IN s1', $out);
+
+############################################################
+$title = 'Print unused objects from snapshot';
+############################################################
+
+my $in_rules = $in . <<'END';
+-- rules2
+service:s2 = {
+ user = network:n3, network:n3;
+ permit src = user; dst = host:h1; prt = tcp 80;
+}
+END
+
+$out = <<'END';
+Using snapshot of model
+Warning: Ignoring pathrestriction:p with only interface:r1.n1
+Warning: Duplicate elements in user of service:s2:
+ - network:n3
+END
+
+test_snapshot($title, $in_rules,
+              q{print-group --unused IN 'interface:r1.[all],network:n2'},
+              $out);
+
+############################################################
+$title = 'Cut from snapshot';
+############################################################
+
+test_snapshot($title, $in_rules, 'cut-netspoc IN', $out);
+
+############################################################
+$title = 'Cut some services without snapshot';
+############################################################
+
+$out = <<'END';
+Warning: Ignoring pathrestriction:p with only interface:r1.n1
+Warning: Duplicate elements in user of service:s2:
+ - network:n3
+END
+
+test_snapshot($title, $in_rules, 'cut-netspoc IN s2', $out);
+
+############################################################
+$title = 'Ignore outdated snapshot';
+############################################################
+
+$out = <<'END';
+Ignoring outdated snapshot
+Warning: Ignoring pathrestriction:p with only interface:r1.n1
+END
+
+test_snapshot($title, $in, 'print-service IN s1', $out, [ 'rules', <<'END' ]);
+service:s1 = {
+ user = host:h1;
+ permit src = user; dst = network:n3; prt = tcp 80;
+}
+END
+
+############################################################
+$title = 'Ignore snapshot after changed public key';
+############################################################
+
+test_snapshot($title, $in . <<'END', 'print-service IN s1', $out,
+-- keys/r2.pub
+KEY1=
+END
+              [ 'keys/r2.pub', "KEY2=\n" ]);
+
+############################################################
+$title = 'Export from snapshot';
+############################################################
+
+my $in_dir = prepare_in_dir($in);
+my $out_dir = tempdir( CLEANUP => 1 );
+my $snapshot = "$out_dir/.snapshot";
+run("bin/spoc1 -q --snapshot=$snapshot $in_dir $out_dir/code", $in_dir);
+run("bin/export-netspoc -q $in_dir $out_dir/exp1", $in_dir);
+run("bin/export-netspoc -q --snapshot=$snapshot $in_dir $out_dir/exp2",
+    $in_dir);
+my ($diff) = run("diff -r $out_dir/exp1 $out_dir/exp2 || true", $in_dir);
+eq_or_diff($diff, '', $title);
+
+############################################################
+done_testing;
@@ -0,0 +1,138 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use JSON;
+use IPC::Run3;
+use File::Temp qw/ tempdir /;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+# Run pass 1 and pass 2 with option --stats.
+# Return report, but only names of phases without measured values.
+sub run {
+    my ($input, $options, $out_dir) = @_;
+    my $in_dir = prepare_in_dir($input);
+    $out_dir ||= tempdir( CLEANUP => 1 );
+    my $file = "$out_dir/.stats";
+    my $args = "-q --check_redundant_rules=0 --stats=$file $options";
+    $args .= " $in_dir $out_dir";
+    my $stderr;
+    run3("bin/spoc1 $args && bin/spoc2 $args", \undef, \undef, \$stderr);
+    if ($stderr) {
+        diag("Unexpected output on STDERR:\n$stderr");
+        return {};
+    }
+    local $/ = undef;
+    open(my $fh, '<', $file) or die "Can't open $file";
+    my $report = from_json(<$fh>);
+    close($fh);
+    for my $pass (values %$report) {
+        for my $phase (@{ $pass->{phases} }) {
+            for my $key (qw(wall cpu alloc)) {
+                $phase->{$key} >= 0 or
+                    diag("Invalid value of '$key' in $phase->{name}");
+            }
+            $phase = $phase->{name};
+        }
+    }
+    return $report;
+}
+
+my ($title, $in, $out);
+
+############################################################
+$title = 'Report statistics of pass 1 and pass 2';
+############################################################
+
+$in = <<'END';
+network:n1 = { ip = 10.1.1.0/24; host:h1 = { ip = 10.1.1.10; } }
+network:n2 = { ip = 10.1.2.0/24; }
+network:n3 = { ip = 10.1.3.0/24; }
+
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+router:r2 = {
+ managed;
+ model = IOS;
+ interface:n2 = { ip = 10.1.2.2; hardware = n2; }
+ interface:n3 = { ip = 10.1.3.1; hardware = n3; }
+}
+
+service:s1 = {
+ user = host:h1, network:n1;
+ permit src = user; dst = network:n3; prt = tcp 80, tcp 81;
+}
+service:s2 = {
+ user = network:n2;
+ permit src = user; dst = network:n3; prt = tcp 80;
+}
+END
+
+$out = {
+    pass1 => {
+        phases => [qw(
+            readNetspoc checkIPAdresses setZone setPath distributeNatInfo
+            findSubnetsInZone normalizeServices checkServiceOwner
+            convertHostsInRules groupPathRules checkRedundantRules
+            findSubnetsInNatDomain checkUnstableNatRules
+            checkDynamicNatRules checkSupernetRules
+            removeSimpleDuplicateRules combineSubnetsInRules
+            expandCrypto findActiveRoutes genReverseRules
+            markSecondaryRules rulesDistribution printCode
+        )],
+        read => { routers => 2, networks => 3, hosts => 1, services => 2 },
+        rules => { grouped => 2, expanded => 5,
+                   duplicate => 0, redundant => 2 },
+        acl_lines => { r1 => 1, r2 => 4 },
+    },
+    pass2 => {
+        phases => [ 'pass2' ],
+        acl_lines => { r1 => 3, r2 => 7 },
+    },
+};
+
+eq_or_diff(run($in, ''), $out, $title);
+
+############################################################
+$title = 'Report statistics with concurrent processing';
+############################################################
+
+eq_or_diff(run($in, '--concurrency_pass1=2 --concurrency_pass2=2'),
+           $out, $title);
+
+############################################################
+$title = 'Report ACL lines of reused devices';
+############################################################
+
+my $out_dir = tempdir( CLEANUP => 1 );
+run($in, '', $out_dir);
+eq_or_diff(run($in, '', $out_dir), $out, $title);
+
+############################################################
+$title = 'Leave out unknown ACL lines of reused devices';
+############################################################
+
+# Manifest of older version doesn't know number of ACL lines.
+my $manifest = "$out_dir/.output-manifest";
+{
+    local $/ = undef;
+    open(my $fh, '<', $manifest) or die "Can't open $manifest";
+    my $data = <$fh>;
+    close($fh);
+    $data =~ s/,\s*"acl_lines": *\d+//g;
+    open($fh, '>', $manifest) or die "Can't open $manifest";
+    print $fh $data;
+    close($fh);
+}
+$out->{pass2}->{acl_lines} = {};
+eq_or_diff(run($in, '', $out_dir), $out, $title);
+
+############################################################
+done_testing;
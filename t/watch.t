@@ -0,0 +1,181 @@
+#!/usr/bin/perl
+
+use strict;
+use warnings;
+use Test::More;
+use Test::Differences;
+use File::Temp qw/ tempdir /;
+use lib 't';
+use Test_Netspoc qw(prepare_in_dir);
+
+# Start pass 1 in watch mode with first input.
+# Then apply each change to files of input directory.
+# Returns output of each run.
+sub run {
+    my ($input, @changes) = @_;
+    my $in_dir = prepare_in_dir($input);
+    my $out_dir = tempdir( CLEANUP => 1 );
+    my $pid = open(my $fh, '-|', "bin/spoc1 --watch $in_dir $out_dir 2>&1")
+        or die "Can't start spoc1: $!";
+    local $SIG{ALRM} = sub { kill 'TERM', $pid; die "Timeout\n" };
+    alarm 30;
+    my @result;
+
+    # Read output until end of next run.
+    my $read = sub {
+        my $out = '';
+        while (my $line = <$fh>) {
+            last if $line eq "Watching for changes\n";
+            next if $line =~ /^Netspoc, version/;
+            $line =~ s/\Q$in_dir\E\///g;
+            $out .= $line;
+        }
+        push @result, $out;
+    };
+    $read->();
+    for my $change (@changes) {
+        my ($file, $content) = @$change;
+        my $path = "$in_dir/$file";
+        if (defined $content) {
+            open(my $out, '>', $path) or die "Can't open $path: $!";
+            print $out $content;
+            close($out);
+        }
+        else {
+            unlink($path);
+        }
+        $read->();
+    }
+    alarm 0;
+    kill 'TERM', $pid;
+    close($fh);
+    open(my $code, '<', "$out_dir/r1") or die "Can't open r1: $!";
+    push @result, join('', grep { /^access-list/ } <$code>);
+    close($code);
+    return \@result;
+}
+
+my ($title, $in, $out);
+
+############################################################
+$title = 'Show new and resolved messages after changes';
+############################################################
+
+my $topo = <<'END';
+network:n1 = { ip = 10.1.1.0/24; }
+network:n2 = { ip = 10.1.2.0/24; }
+router:r1 = {
+ managed;
+ model = ASA;
+ interface:n1 = { ip = 10.1.1.1; hardware = n1; }
+ interface:n2 = { ip = 10.1.2.1; hardware = n2; }
+}
+END
+
+my $rules = <<'END';
+service:s1 = {
+ user = network:n1;
+ permit src = user; dst = network:n2; prt = tcp 80;
+}
+service:s2 = {
+ user = network:n1;
+ permit src = user; dst = network:n2; prt = tcp;
+}
+END
+
+my $rules2 = $rules . <<'END';
+service:s3 = {
+ user = network:n3;
+ permit src = user; dst = network:n1; prt = udp 53;
+}
+END
+
+my $rules3 = $rules2 . <<'END';
+network:n3 = { ip = 10.1.3.0/24; }
+router:r2 = {
+ interface:n2 = { ip = 10.1.2.2; }
+ interface:n3;
+}
+END
+
+$in = <<"END";
+-- topo
+$topo
+-- rules
+$rules
+END
+
+$out = [
+<<'END',
+Warning: Redundant rules in service:s1 compared to service:s2:
+  permit src=network:n1; dst=network:n2; prt=tcp 80; of service:s1
+< permit src=network:n1; dst=network:n2; prt=tcp; of service:s2
+END
+<<'END',
+Error: Can't resolve network:n3 in user of service:s3
+Aborted with 1 error(s)
+Resolved: Warning: Redundant rules in service:s1 compared to service:s2:
+  permit src=network:n1; dst=network:n2; prt=tcp 80; of service:s1
+< permit src=network:n1; dst=network:n2; prt=tcp; of service:s2
+END
+<<'END',
+Syntax error: Expected '=' at line 1 of bad, at EOF
+Resolved: Error: Can't resolve network:n3 in user of service:s3
+Resolved: Aborted with 1 error(s)
+END
+# Syntax error is still present.
+'',
+<<'END',
+Warning: Redundant rules in service:s1 compared to service:s2:
+  permit src=network:n1; dst=network:n2; prt=tcp 80; of service:s1
+< permit src=network:n1; dst=network:n2; prt=tcp; of service:s2
+Resolved: Syntax error: Expected '=' at line 1 of bad, at EOF
+END
+<<'END',
+access-list n1_in extended permit tcp 10.1.1.0 255.255.255.0 10.1.2.0 255.255.255.0
+access-list n1_in extended deny ip any4 any4
+access-list n2_in extended permit udp 10.1.3.0 255.255.255.0 10.1.1.0 255.255.255.0 eq 53
+access-list n2_in extended deny ip any4 any4
+END
+];
+
+eq_or_diff(run($in,
+                [ rules => $rules2 ],
+                [ bad => 'network:n4' ],
+                [ rules => $rules3 ],
+                [ bad => undef ]),
+           $out, $title);
+
+############################################################
+$title = 'Compile again after changed public key';
+############################################################
+
+$in = <<"END";
+-- topo
+$topo
+-- rules
+service:s1 = {
+ user = network:n1;
+ permit src = user; dst = network:n2; prt = tcp 80;
+}
+-- keys/r2.pub
+KEY1=
+END
+
+$out = [
+<<'END',
+Warning: Ignoring 'keys' at toplevel of policy;
+ it is reserved for public keys of WireGuard
+END
+'',
+<<'END',
+access-list n1_in extended permit tcp 10.1.1.0 255.255.255.0 10.1.2.0 255.255.255.0 eq 80
+access-list n1_in extended deny ip any4 any4
+access-list n2_in extended deny ip any4 any4
+END
+];
+
+eq_or_diff(run($in, [ 'keys/r2.pub' => "KEY2=\n" ]), $out, $title);
+
+############################################################
+done_testing;